@@ -0,0 +1,121 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"sync"
+	"time"
+
+	"github.com/limetext/backend/log"
+)
+
+// The values of the "auto_save" setting
+const (
+	AUTO_SAVE_OFF              = "off"              // Views are only saved by the user
+	AUTO_SAVE_ON_FOCUS_LOST    = "on_focus_lost"    // A view is saved when it's deactivated
+	AUTO_SAVE_AFTER_DELAY      = "after_delay"      // A view is saved "auto_save_delay" milliseconds after it was last modified
+	AUTO_SAVE_ON_WINDOW_CHANGE = "on_window_change" // The views of a window are saved when another window is activated
+)
+
+// The default of the "auto_save_delay" setting, in milliseconds
+const defaultAutoSaveDelay = 1000
+
+// The pending AUTO_SAVE_AFTER_DELAY saves of views
+var autoSaveTimers = struct {
+	sync.Mutex
+	m map[*View]*time.Timer
+}{m: make(map[*View]*time.Timer)}
+
+// Returns the auto save mode of v.
+func autoSaveMode(v *View) string {
+	return v.Settings().String("auto_save", AUTO_SAVE_OFF)
+}
+
+// Saves v if it's dirty, and if it's a file, which scratch and untitled
+// views aren't. Saves are made with Save, which respects "atomic_save" and
+// sets "lime.saving" so that they don't trigger reload prompts. Views being
+// saved already are skipped, as are read-only files, which the user would
+// be asked about overwriting.
+func autoSave(v *View) {
+	if v.isClosed() || v.IsScratch() || v.FileName() == "" || !v.IsDirty() {
+		return
+	}
+	if isReadOnlyFile(v.FileName()) {
+		return
+	}
+	if v.Settings().Bool("lime.saving", false) {
+		return
+	}
+	log.Fine("Auto saving %s", v.FileName())
+	if err := v.Save(); err != nil {
+		log.Error("Couldn't auto save %s: %s", v.FileName(), err)
+		if fe := GetEditor().Frontend(); fe != nil {
+			fe.StatusMessage("Couldn't auto save " + v.FileName())
+		}
+	}
+}
+
+// (Re)starts the timer saving v after "auto_save_delay" milliseconds. The
+// save is made by the goroutine handling the input, so that the OnPreSave
+// callbacks don't edit v while the user is typing in it.
+func scheduleAutoSave(v *View) {
+	delay := v.Settings().Int("auto_save_delay", defaultAutoSaveDelay)
+	autoSaveTimers.Lock()
+	defer autoSaveTimers.Unlock()
+	if t := autoSaveTimers.m[v]; t != nil {
+		t.Stop()
+	}
+	var t *time.Timer
+	t = time.AfterFunc(time.Duration(delay)*time.Millisecond, func() {
+		GetEditor().post(func() {
+			autoSaveTimers.Lock()
+			// The save may have been rescheduled or
+			// cancelled while waiting for the input
+			pending := autoSaveTimers.m[v] == t
+			if pending {
+				delete(autoSaveTimers.m, v)
+			}
+			autoSaveTimers.Unlock()
+			// The mode may have changed since
+			if pending && autoSaveMode(v) == AUTO_SAVE_AFTER_DELAY {
+				autoSave(v)
+			}
+		})
+	})
+	autoSaveTimers.m[v] = t
+}
+
+func cancelAutoSave(v *View) {
+	autoSaveTimers.Lock()
+	defer autoSaveTimers.Unlock()
+	if t := autoSaveTimers.m[v]; t != nil {
+		t.Stop()
+		delete(autoSaveTimers.m, v)
+	}
+}
+
+func init() {
+	OnModified.Add(func(v *View) {
+		if autoSaveMode(v) == AUTO_SAVE_AFTER_DELAY {
+			scheduleAutoSave(v)
+		}
+	})
+	OnDeactivated.Add(func(v *View) {
+		if autoSaveMode(v) == AUTO_SAVE_ON_FOCUS_LOST {
+			autoSave(v)
+		}
+	})
+	OnWindowDeactivated.Add(func(w *Window) {
+		for _, v := range w.Views() {
+			if autoSaveMode(v) == AUTO_SAVE_ON_WINDOW_CHANGE {
+				autoSave(v)
+			}
+		}
+	})
+	// Saving a view, automatically or not, makes a pending save
+	// pointless, and closed views mustn't be saved
+	OnPostSave.Add(cancelAutoSave)
+	OnClose.Add(cancelAutoSave)
+}
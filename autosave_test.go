@@ -0,0 +1,126 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type dialogFrontend struct {
+	dummyFrontend
+	dialogs int
+}
+
+func (fe *dialogFrontend) OkCancelDialog(msg string, button string) bool {
+	fe.m.Lock()
+	defer fe.m.Unlock()
+	fe.dialogs++
+	return false
+}
+
+func TestAutoSave(t *testing.T) {
+	dir, rm := tempDir(t)
+	defer rm()
+
+	ed := GetEditor()
+	fe := &dialogFrontend{}
+	defer setFrontend(fe)()
+
+	open := func(w *Window, name, mode string) *View {
+		fn := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(fn, []byte("a"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		v := w.OpenFile(fn, 0)
+		v.Settings().Set("auto_save", mode)
+		v.Settings().Set("auto_save_delay", 10)
+		return v
+	}
+	modify := func(v *View) {
+		e := v.BeginEdit()
+		v.Insert(e, 0, "b")
+		v.EndEdit(e)
+	}
+	saved := func(v *View) bool {
+		for i := 0; i < 100; i++ {
+			if d, _ := ioutil.ReadFile(v.FileName()); string(d) == "ba" && !v.IsDirty() {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+
+	w := ed.NewWindow()
+	defer w.Close()
+	w2 := ed.NewWindow()
+	defer w2.Close()
+
+	delayed := open(w, "delayed", AUTO_SAVE_AFTER_DELAY)
+	modify(delayed)
+	if !saved(delayed) {
+		t.Error("Expected the view to be saved after the delay")
+	}
+
+	// The save waits for the input, e.g a command, being handled
+	busy := make(chan bool)
+	ed.post(func() { <-busy })
+	blocked := open(w, "blocked", AUTO_SAVE_AFTER_DELAY)
+	modify(blocked)
+	time.Sleep(50 * time.Millisecond)
+	if !blocked.IsDirty() {
+		t.Error("Expected the view not to be saved while the input is handled")
+	}
+	close(busy)
+	if !saved(blocked) {
+		t.Error("Expected the view to be saved once the input was handled")
+	}
+
+	focus := open(w, "focus", AUTO_SAVE_ON_FOCUS_LOST)
+	modify(focus)
+	w.SetActiveView(delayed)
+	if !saved(focus) {
+		t.Error("Expected the view to be saved when it lost focus")
+	}
+
+	window := open(w, "window", AUTO_SAVE_ON_WINDOW_CHANGE)
+	modify(window)
+	ed.SetActiveWindow(w)
+	ed.SetActiveWindow(w2)
+	if !saved(window) {
+		t.Error("Expected the view to be saved when another window was activated")
+	}
+
+	off := open(w, "off", AUTO_SAVE_OFF)
+	modify(off)
+	w.SetActiveView(delayed)
+	ed.SetActiveWindow(w)
+	time.Sleep(50 * time.Millisecond)
+	if !off.IsDirty() {
+		t.Error("Expected the view not to be saved with auto save off")
+	}
+
+	untitled := w.NewFile()
+	untitled.Settings().Set("auto_save", AUTO_SAVE_AFTER_DELAY)
+	untitled.Settings().Set("auto_save_delay", 10)
+	modify(untitled)
+	time.Sleep(50 * time.Millisecond)
+	if !untitled.IsDirty() {
+		t.Error("Expected an untitled view not to be saved")
+	}
+
+	// Being told about our own saves doesn't prompt for a reload
+	delayed.FileChanged(delayed.FileName())
+	if fe.dialogs != 0 {
+		t.Errorf("Expected no reload prompts, but got %d", fe.dialogs)
+	}
+
+	for _, v := range append(w.Views(), w2.Views()...) {
+		v.SetScratch(true)
+	}
+}
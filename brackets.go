@@ -0,0 +1,392 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/limetext/backend/log"
+	"github.com/limetext/backend/render"
+	"github.com/limetext/text"
+)
+
+type (
+	// Moves each cursor to the bracket matching the one next to it, or
+	// to the closing bracket of the pair enclosing the cursor. It's what
+	// move_to does with "to": "brackets", which the commands implementing
+	// move_to can run.
+	MoveToBracketCommand struct {
+		DefaultCommand
+	}
+
+	// Expands each selection to the contents of the enclosing pair of
+	// brackets, or to the pair itself if its contents are already
+	// selected, as expand_selection does with "to": "brackets".
+	ExpandSelectionToBracketsCommand struct {
+		DefaultCommand
+	}
+
+	// Expands each selection to the contents of the enclosing pair of
+	// tags, or to the pair itself if its contents are already selected,
+	// as expand_selection does with "to": "tag".
+	ExpandSelectionToTagCommand struct {
+		DefaultCommand
+	}
+
+	// bracketScanner walks a window of the buffer looking for
+	// unbalanced brackets. The buffer data is read in chunks
+	// as the scan goes, as most scans end close to where they
+	// started.
+	bracketScanner struct {
+		v      *View
+		lo, hi int
+		chunk  []rune
+		offset int
+		// Whether the brackets being paired are inside a string or comment
+		quoted bool
+	}
+
+	tagPair struct {
+		open, close text.Region
+	}
+)
+
+const (
+	// The number of characters searched in each direction
+	// when looking for matching brackets and tags.
+	bracketSearchLimit = 1 << 14
+	// The brackets highlighted as the cursors move are only searched
+	// for in about a screenful of text around them, as every bracket
+	// passed needs its scope looked up.
+	bracketHighlightLimit = 1 << 12
+	bracketChunkSize      = 256
+)
+
+var (
+	bracketPartners = map[rune]rune{
+		'(': ')', '[': ']', '{': '}',
+		')': '(', ']': '[', '}': '{',
+	}
+	tagPattern = regexp.MustCompile(`<(/?)([A-Za-z][\w:.-]*)(?:\s[^<>]*?)?(/?)>`)
+)
+
+func isOpeningBracket(r rune) bool {
+	return r == '(' || r == '[' || r == '{'
+}
+
+func isClosingBracket(r rune) bool {
+	return r == ')' || r == ']' || r == '}'
+}
+
+// Returns whether the scope name sn is that of a string or a comment,
+// i.e if one of its scopes has a "string" or "comment" segment.
+func isStringOrComment(sn string) bool {
+	for _, scope := range strings.Fields(sn) {
+		for _, seg := range strings.Split(scope, ".") {
+			if seg == "string" || seg == "comment" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns whether the scope at point is a string or a comment.
+func (v *View) inStringOrComment(point int) bool {
+	return isStringOrComment(v.ScopeName(point))
+}
+
+func newBracketScanner(v *View, r text.Region, limit int) *bracketScanner {
+	a, b := r.Begin()-limit, r.End()+limit
+	if a < 0 {
+		a = 0
+	}
+	if s := v.Size(); b > s {
+		b = s
+	}
+	return &bracketScanner{v: v, lo: a, hi: b}
+}
+
+func (bs *bracketScanner) at(point int) rune {
+	if point < bs.lo || point >= bs.hi {
+		return 0
+	}
+	if i := point - bs.offset; i < 0 || i >= len(bs.chunk) {
+		a := point - bracketChunkSize/2
+		if a < bs.lo {
+			a = bs.lo
+		}
+		b := a + bracketChunkSize
+		if b > bs.hi {
+			b = bs.hi
+		}
+		bs.chunk = bs.v.SubstrR(text.Region{A: a, B: b})
+		bs.offset = a
+	}
+	return bs.chunk[point-bs.offset]
+}
+
+// Brackets are only paired with brackets of the same kind,
+// where a bracket inside a string or comment never matches
+// one in code and vice versa.
+func (bs *bracketScanner) counts(point int) bool {
+	return bs.v.inStringOrComment(point) == bs.quoted
+}
+
+// scan walks from point in the direction dir (1 or -1) and returns
+// the position of the first bracket that isn't balanced within the
+// scanned text, or -1 if there is none.
+func (bs *bracketScanner) scan(point, dir int) int {
+	var stack []rune
+	for p := point; p >= bs.lo && p < bs.hi; p += dir {
+		r := bs.at(p)
+		push, pop := isOpeningBracket(r), isClosingBracket(r)
+		if dir < 0 {
+			push, pop = pop, push
+		}
+		if !(push || pop) || !bs.counts(p) {
+			continue
+		}
+		if push {
+			stack = append(stack, bracketPartners[r])
+		} else if l := len(stack); l == 0 {
+			return p
+		} else if stack[l-1] == r {
+			stack = stack[:l-1]
+		} else {
+			// Mismatched brackets, give up rather than guess
+			return -1
+		}
+	}
+	return -1
+}
+
+// Returns the pair of brackets enclosing r.
+func (bs *bracketScanner) enclosing(r text.Region) (open, close text.Region, ok bool) {
+	a := bs.scan(r.Begin()-1, -1)
+	if a == -1 {
+		return
+	}
+	b := bs.scan(r.End(), 1)
+	if b == -1 || bracketPartners[bs.at(a)] != bs.at(b) {
+		return
+	}
+	return text.Region{A: a, B: a + 1}, text.Region{A: b, B: b + 1}, true
+}
+
+// FindBrackets returns the Regions of the opening and the closing
+// bracket of the pair at point. A bracket right after or right before
+// point is matched first, otherwise the innermost pair enclosing point
+// is returned. Brackets inside strings and comments, as determined by
+// the syntax highlighter, are only paired with each other.
+//
+// ok is false if no such pair could be found.
+func (v *View) FindBrackets(point int) (open, close text.Region, ok bool) {
+	return v.findBrackets(point, bracketSearchLimit)
+}
+
+// Searches limit characters in each direction of point for its brackets.
+func (v *View) findBrackets(point, limit int) (open, close text.Region, ok bool) {
+	if point < 0 || point > v.Size() {
+		return
+	}
+	bs := newBracketScanner(v, text.Region{A: point, B: point}, limit)
+	if r := bs.at(point); isOpeningBracket(r) {
+		bs.quoted = v.inStringOrComment(point)
+		if b := bs.scan(point+1, 1); b != -1 && bs.at(b) == bracketPartners[r] {
+			return text.Region{A: point, B: point + 1}, text.Region{A: b, B: b + 1}, true
+		}
+	}
+	if r := bs.at(point - 1); isClosingBracket(r) {
+		bs.quoted = v.inStringOrComment(point - 1)
+		if a := bs.scan(point-2, -1); a != -1 && bs.at(a) == bracketPartners[r] {
+			return text.Region{A: a, B: a + 1}, text.Region{A: point - 1, B: point}, true
+		}
+	}
+	bs.quoted = v.inStringOrComment(point)
+	return bs.enclosing(text.Region{A: point, B: point})
+}
+
+// Returns the tag pairs enclosing r, innermost first.
+func (v *View) enclosingTags(r text.Region) (ret []tagPair) {
+	a, b := r.Begin()-bracketSearchLimit, r.End()+bracketSearchLimit
+	if a < 0 {
+		a = 0
+	}
+	if s := v.Size(); b > s {
+		b = s
+	}
+	data := v.Substr(text.Region{A: a, B: b})
+
+	var stack []tagPair
+	var names []string
+	// The regexp indices are byte offsets and need converting to runes
+	pos, bytePos := a, 0
+	toPoint := func(i int) int {
+		pos += len([]rune(data[bytePos:i]))
+		bytePos = i
+		return pos
+	}
+	for _, m := range tagPattern.FindAllStringSubmatchIndex(data, -1) {
+		if m[7] != m[6] {
+			// Self closing tag
+			continue
+		}
+		tag := text.Region{A: toPoint(m[0]), B: toPoint(m[1])}
+		if v.inStringOrComment(tag.A) {
+			continue
+		}
+		name := strings.ToLower(data[m[4]:m[5]])
+		if m[3] == m[2] {
+			stack = append(stack, tagPair{open: tag})
+			names = append(names, name)
+			continue
+		}
+		// Closing tag, pop up to the matching opening tag as
+		// unclosed tags (e.g. <br>) are allowed in html
+		for i := len(names) - 1; i >= 0; i-- {
+			if names[i] != name {
+				continue
+			}
+			tp := stack[i]
+			tp.close = tag
+			if tp.open.Begin() <= r.Begin() && tp.close.End() >= r.End() {
+				ret = append(ret, tp)
+			}
+			stack, names = stack[:i], names[:i]
+			break
+		}
+	}
+	return
+}
+
+// The contents of the pair are selected first, and
+// the whole pair once the contents already are.
+func expandToPair(r, open, close text.Region) (text.Region, bool) {
+	contents := text.Region{A: open.End(), B: close.Begin()}
+	whole := text.Region{A: open.Begin(), B: close.End()}
+	if contents.Covers(r) && contents != r {
+		return contents, true
+	} else if whole.Covers(r) && whole != r {
+		return whole, true
+	}
+	return r, false
+}
+
+func (c *MoveToBracketCommand) Run(v *View, e *Edit) error {
+	sel := v.Sel()
+	rs := sel.Regions()
+	for i, r := range rs {
+		open, close, ok := v.FindBrackets(r.B)
+		if !ok {
+			continue
+		}
+		switch r.B {
+		case open.Begin():
+			rs[i] = text.Region{A: close.End(), B: close.End()}
+		case close.End():
+			rs[i] = text.Region{A: open.Begin(), B: open.Begin()}
+		case close.Begin():
+			rs[i] = text.Region{A: open.End(), B: open.End()}
+		default:
+			rs[i] = text.Region{A: close.Begin(), B: close.Begin()}
+		}
+	}
+	sel.Clear()
+	sel.AddAll(rs)
+	return nil
+}
+
+func (c *ExpandSelectionToBracketsCommand) Run(v *View, e *Edit) error {
+	v.expandToBrackets()
+	return nil
+}
+
+func (c *ExpandSelectionToTagCommand) Run(v *View, e *Edit) error {
+	v.expandToTag()
+	return nil
+}
+
+// Expands each selection to the pair of brackets enclosing it.
+func (v *View) expandToBrackets() {
+	sel := v.Sel()
+	rs := sel.Regions()
+	for i, r := range rs {
+		bs := newBracketScanner(v, r, bracketSearchLimit)
+		bs.quoted = v.inStringOrComment(r.Begin())
+		for {
+			open, close, ok := bs.enclosing(r)
+			if !ok {
+				break
+			}
+			if nr, ok := expandToPair(rs[i], open, close); ok {
+				rs[i] = nr
+				break
+			}
+			// The whole pair is already selected, try the next one out
+			r = text.Region{A: open.Begin(), B: close.End()}
+		}
+	}
+	sel.Clear()
+	sel.AddAll(rs)
+}
+
+// Expands each selection to the pair of tags enclosing it.
+func (v *View) expandToTag() {
+	sel := v.Sel()
+	rs := sel.Regions()
+	for i, r := range rs {
+		for _, tp := range v.enclosingTags(r) {
+			if nr, ok := expandToPair(r, tp.open, tp.close); ok {
+				rs[i] = nr
+				break
+			}
+		}
+	}
+	sel.Clear()
+	sel.AddAll(rs)
+}
+
+// Keeps the "lime.brackets" regions up to date with
+// the brackets matching the cursors of the view.
+func updateBrackets(v *View) {
+	if v.isClosed() {
+		return
+	}
+	if !v.Settings().Bool("match_brackets", true) {
+		v.EraseRegions("lime.brackets")
+		return
+	}
+	var rs []text.Region
+	for _, r := range v.Sel().Regions() {
+		if !r.Empty() {
+			continue
+		}
+		if open, close, ok := v.findBrackets(r.B, bracketHighlightLimit); ok {
+			rs = append(rs, open, close)
+		}
+	}
+	if len(rs) == 0 {
+		v.EraseRegions("lime.brackets")
+		return
+	}
+	v.AddRegions("lime.brackets", rs, "brackets", "", render.DRAW_SOLID_UNDERLINE|render.DRAW_NO_FILL|render.DRAW_NO_OUTLINE|render.HIDE_ON_MINIMAP)
+}
+
+func init() {
+	ch := GetEditor().CommandHandler()
+	cmds := []interface{}{
+		&MoveToBracketCommand{},
+		&ExpandSelectionToBracketsCommand{},
+		&ExpandSelectionToTagCommand{},
+	}
+	for _, cmd := range cmds {
+		if err := ch.RegisterWithDefault(cmd); err != nil {
+			log.Error("Failed to register command: %s", err)
+		}
+	}
+	OnSelectionModified.Add(updateBrackets)
+}
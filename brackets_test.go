@@ -0,0 +1,196 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/limetext/text"
+)
+
+func TestFindBrackets(t *testing.T) {
+	tests := []struct {
+		text  string
+		point int
+		open  text.Region
+		close text.Region
+		ok    bool
+	}{
+		{"a(b)c", 1, text.Region{A: 1, B: 2}, text.Region{A: 3, B: 4}, true},
+		{"a(b)c", 4, text.Region{A: 1, B: 2}, text.Region{A: 3, B: 4}, true},
+		{"a(b)c", 2, text.Region{A: 1, B: 2}, text.Region{A: 3, B: 4}, true},
+		{"{a[b]c}", 6, text.Region{A: 0, B: 1}, text.Region{A: 6, B: 7}, true},
+		{"{a[b]c}", 3, text.Region{A: 2, B: 3}, text.Region{A: 4, B: 5}, true},
+		{"(a[b)c]", 2, text.Region{}, text.Region{}, false},
+		{"abc", 1, text.Region{}, text.Region{}, false},
+		{"(abc", 2, text.Region{}, text.Region{}, false},
+	}
+
+	w := GetEditor().NewWindow()
+	defer w.Close()
+
+	for i, test := range tests {
+		v := w.NewFile()
+		e := v.BeginEdit()
+		v.Insert(e, 0, test.text)
+		v.EndEdit(e)
+
+		open, close, ok := v.FindBrackets(test.point)
+		if ok != test.ok {
+			t.Errorf("Test %d: Expected ok %v, but got %v", i, test.ok, ok)
+		} else if open != test.open || close != test.close {
+			t.Errorf("Test %d: Expected brackets %s %s, but got %s %s", i, test.open, test.close, open, close)
+		}
+		v.SetScratch(true)
+		v.Close()
+	}
+}
+
+func TestIsStringOrComment(t *testing.T) {
+	tests := []struct {
+		scope string
+		exp   bool
+	}{
+		{"source.go", false},
+		{"source.go string.quoted.double.go", true},
+		{"source.go comment.line.double-slash.go", true},
+		{"source.python meta.function-call.python", false},
+		{"source.go support.function.substring.go", false},
+		{"source.go meta.commentary.go", false},
+		{"text.html.basic source.js.embedded.html string.quoted.single.js", true},
+	}
+	for i, test := range tests {
+		if got := isStringOrComment(test.scope); got != test.exp {
+			t.Errorf("Test %d: Expected %v for %q, but got %v", i, test.exp, test.scope, got)
+		}
+	}
+}
+
+func TestBracketRegions(t *testing.T) {
+	w := GetEditor().NewWindow()
+	defer w.Close()
+
+	v := w.NewFile()
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+
+	e := v.BeginEdit()
+	v.Insert(e, 0, "f(a, b)")
+	v.EndEdit(e)
+
+	v.Sel().Clear()
+	v.Sel().Add(text.Region{A: 3, B: 3})
+	exp := []text.Region{{A: 1, B: 2}, {A: 6, B: 7}}
+	if got := v.GetRegions("lime.brackets"); !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected bracket regions %v, but got %v", exp, got)
+	}
+
+	v.Sel().Clear()
+	v.Sel().Add(text.Region{A: 0, B: 0})
+	if got := v.GetRegions("lime.brackets"); len(got) != 0 {
+		t.Errorf("Expected no bracket regions, but got %v", got)
+	}
+}
+
+func TestBracketCommands(t *testing.T) {
+	tests := []struct {
+		text string
+		cmd  string
+		in   []text.Region
+		exp  []text.Region
+	}{
+		{
+			"a(bc)d",
+			"move_to_bracket",
+			[]text.Region{{A: 1, B: 1}},
+			[]text.Region{{A: 5, B: 5}},
+		},
+		{
+			"a(bc)d",
+			"move_to_bracket",
+			[]text.Region{{A: 3, B: 3}},
+			[]text.Region{{A: 4, B: 4}},
+		},
+		{
+			"a(bc)d",
+			"move_to_bracket",
+			[]text.Region{{A: 4, B: 4}},
+			[]text.Region{{A: 2, B: 2}},
+		},
+		{
+			"a(b[c]d)e",
+			"expand_selection_to_brackets",
+			[]text.Region{{A: 2, B: 2}},
+			[]text.Region{{A: 2, B: 7}},
+		},
+		{
+			"a(b[c]d)e",
+			"expand_selection_to_brackets",
+			[]text.Region{{A: 2, B: 7}},
+			[]text.Region{{A: 1, B: 8}},
+		},
+		{
+			"a(b[c]d)e",
+			"expand_selection_to_brackets",
+			[]text.Region{{A: 4, B: 5}},
+			[]text.Region{{A: 3, B: 6}},
+		},
+		{
+			"a(b[c]d)e",
+			"expand_selection_to_brackets",
+			[]text.Region{{A: 3, B: 6}},
+			[]text.Region{{A: 2, B: 7}},
+		},
+		{
+			"<a><b>text</b><br></a>",
+			"expand_selection_to_tag",
+			[]text.Region{{A: 7, B: 7}},
+			[]text.Region{{A: 6, B: 10}},
+		},
+		{
+			"<a><b>text</b><br></a>",
+			"expand_selection_to_tag",
+			[]text.Region{{A: 6, B: 10}},
+			[]text.Region{{A: 3, B: 14}},
+		},
+		{
+			"<a><b>text</b><br></a>",
+			"expand_selection_to_tag",
+			[]text.Region{{A: 3, B: 14}},
+			[]text.Region{{A: 3, B: 18}},
+		},
+		{
+			"<a><b>text</b><br></a>",
+			"expand_selection_to_tag",
+			[]text.Region{{A: 3, B: 18}},
+			[]text.Region{{A: 0, B: 22}},
+		},
+	}
+
+	ed := GetEditor()
+	w := ed.NewWindow()
+	defer w.Close()
+
+	for i, test := range tests {
+		v := w.NewFile()
+		e := v.BeginEdit()
+		v.Insert(e, 0, test.text)
+		v.EndEdit(e)
+
+		v.Sel().Clear()
+		v.Sel().AddAll(test.in)
+		if err := ed.CommandHandler().RunTextCommand(v, test.cmd, nil); err != nil {
+			t.Errorf("Test %d: Error running %s: %s", i, test.cmd, err)
+		}
+		if got := v.Sel().Regions(); !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("Test %d: Expected selection %v, but got %v", i, test.exp, got)
+		}
+		v.SetScratch(true)
+		v.Close()
+	}
+}
@@ -0,0 +1,385 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/limetext/backend/log"
+	"github.com/limetext/backend/vfs"
+)
+
+// The names of the built-in file codecs
+const (
+	CODEC_GZIP  = "gzip"
+	CODEC_BZIP2 = "bzip2"
+	CODEC_ZSTD  = "zstd"
+)
+
+type (
+	// A FileCodec transforms the bytes of files, such as compressed files,
+	// to and from those of the text they contain, which is what's shown in
+	// a View. The codec of a file is detected by the magic bytes it starts
+	// with, or else by its extension. Codecs other than the built-in ones
+	// can be added with RegisterFileCodec.
+	FileCodec interface {
+		// The name of the codec, e.g "gzip"
+		Name() string
+		// The extensions of the files written with the codec, e.g ".gz"
+		Extensions() []string
+		// The bytes the files written with the codec start with
+		Magic() []byte
+		// Returns a reader of the text contained in what's read from r.
+		NewReader(r io.Reader) (io.ReadCloser, error)
+		// Returns whether NewWriter can be called, read-only
+		// codecs only being able to read files.
+		CanWrite() bool
+		// Returns a writer of the text to w. What's written
+		// isn't complete until the writer is closed.
+		NewWriter(w io.Writer) (io.WriteCloser, error)
+	}
+
+	gzipCodec struct{}
+
+	bzip2Codec struct{}
+
+	// A codec which runs a command to read files,
+	// and another to write them, through pipes
+	commandCodec struct {
+		name        string
+		extensions  []string
+		magic       []byte
+		read, write []string
+	}
+
+	// Reads the stdout of a command, which is waited
+	// for when the reader is closed
+	commandReader struct {
+		io.ReadCloser
+		cmd *exec.Cmd
+	}
+
+	// Writes to the stdin of a command, which is waited
+	// for when the writer is closed
+	commandWriter struct {
+		io.WriteCloser
+		cmd    *exec.Cmd
+		stderr *bytes.Buffer
+	}
+
+	// Closes all its closers in order
+	closers []io.Closer
+)
+
+var codecs = struct {
+	sync.Mutex
+	m map[string]FileCodec
+}{m: make(map[string]FileCodec)}
+
+func (c *gzipCodec) Name() string          { return CODEC_GZIP }
+func (c *gzipCodec) Extensions() []string  { return []string{".gz"} }
+func (c *gzipCodec) Magic() []byte         { return []byte{0x1f, 0x8b, 0x08} }
+func (c *gzipCodec) CanWrite() bool        { return true }
+func (c *bzip2Codec) Name() string         { return CODEC_BZIP2 }
+func (c *bzip2Codec) Extensions() []string { return []string{".bz2"} }
+func (c *bzip2Codec) Magic() []byte        { return []byte("BZh") }
+func (c *bzip2Codec) CanWrite() bool       { return false }
+
+func (c *gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func (c *gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (c *bzip2Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bzip2.NewReader(r)), nil
+}
+
+func (c *bzip2Codec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("%s files can't be written", c.Name())
+}
+
+func (c *commandCodec) Name() string         { return c.name }
+func (c *commandCodec) Extensions() []string { return c.extensions }
+func (c *commandCodec) Magic() []byte        { return c.magic }
+func (c *commandCodec) CanWrite() bool       { return len(c.write) != 0 }
+
+func (c *commandCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	cmd := exec.Command(c.read[0], c.read[1:]...)
+	cmd.Stdin = r
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("couldn't read the %s file: %s", c.name, err)
+	}
+	return &commandReader{out, cmd}, nil
+}
+
+func (r *commandReader) Close() error {
+	r.ReadCloser.Close()
+	return r.cmd.Wait()
+}
+
+func (c *commandCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	if !c.CanWrite() {
+		return nil, fmt.Errorf("%s files can't be written", c.name)
+	}
+	cmd := exec.Command(c.write[0], c.write[1:]...)
+	stderr := new(bytes.Buffer)
+	cmd.Stdout, cmd.Stderr = w, stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("couldn't write the %s file: %s", c.name, err)
+	}
+	return &commandWriter{in, cmd, stderr}, nil
+}
+
+func (w *commandWriter) Close() error {
+	w.WriteCloser.Close()
+	if err := w.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(w.stderr.String()))
+	}
+	return nil
+}
+
+// RegisterFileCodec adds c to the codecs files are read and written
+// with, replacing any codec with the same name.
+func RegisterFileCodec(c FileCodec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.m[c.Name()] = c
+}
+
+// GetFileCodec returns the FileCodec with the given name,
+// or nil if there's no such codec.
+func GetFileCodec(name string) FileCodec {
+	codecs.Lock()
+	defer codecs.Unlock()
+	return codecs.m[name]
+}
+
+// FileCodecs returns the sorted names of the registered codecs.
+func FileCodecs() (ret []string) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	for _, c := range codecs.m {
+		ret = append(ret, c.Name())
+	}
+	sort.Strings(ret)
+	return
+}
+
+// Returns the codec with an extension filename has,
+// and the extension, which is case insensitive.
+func codecByExtension(filename string) (FileCodec, string) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == "" {
+		return nil, ""
+	}
+	codecs.Lock()
+	defer codecs.Unlock()
+	for _, c := range codecs.m {
+		for _, e := range c.Extensions() {
+			if strings.ToLower(e) == ext {
+				return c, ext
+			}
+		}
+	}
+	return nil, ""
+}
+
+// Returns whether the magic bytes m are unlike the start of a text file,
+// so that files starting with them are written with their codec whatever
+// their names. Short magic bytes, or text ones, aren't.
+func distinctMagic(m []byte) bool {
+	if len(m) < 3 {
+		return false
+	}
+	for _, b := range m {
+		if b < 0x20 || b >= 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectFileCodec returns the codec of the file filename, which starts
+// with head. The codec with the longest magic bytes head starts with is
+// returned, or if there's none the codec for the extension of filename.
+// Magic bytes which a text file could start with only count if filename
+// has the extension of their codec too. Returns nil if the file isn't
+// written with a codec.
+func DetectFileCodec(filename string, head []byte) FileCodec {
+	byExt, _ := codecByExtension(filename)
+	codecs.Lock()
+	var ret FileCodec
+	for _, c := range codecs.m {
+		m := c.Magic()
+		if len(m) == 0 || !bytes.HasPrefix(head, m) || !distinctMagic(m) && c != byExt {
+			continue
+		}
+		if ret == nil || len(m) > len(ret.Magic()) {
+			ret = c
+		}
+	}
+	codecs.Unlock()
+	if ret != nil {
+		return ret
+	}
+	// Files which aren't long enough to have magic bytes,
+	// an empty one for example, are still written with a codec
+	if byExt != nil && len(head) < len(byExt.Magic()) {
+		return byExt
+	}
+	return nil
+}
+
+// Returns filename without the extension of its codec, if it has one.
+func trimCodecExtension(filename string) string {
+	if _, ext := codecByExtension(filename); ext != "" {
+		return filename[:len(filename)-len(ext)]
+	}
+	return filename
+}
+
+// Returns the name of the codec the View's file is read and written
+// with, or "" if it's read and written as is.
+func (v *View) FileCodec() string {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.codec
+}
+
+// Returns the codec of the View, nil if there's none.
+func (v *View) fileCodec() FileCodec {
+	if name := v.FileCodec(); name != "" {
+		return GetFileCodec(name)
+	}
+	return nil
+}
+
+func (v *View) setFileCodec(c FileCodec) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if c == nil {
+		v.codec = ""
+	} else {
+		v.codec = c.Name()
+	}
+}
+
+// Opens filename for reading what it contains, through its codec if it
+// has one, which is returned. Files the codec can't read are read as they
+// are instead, without a codec. Closing the returned Closer closes the file.
+func openFile(filename string) (*bufio.Reader, io.Closer, FileCodec, error) {
+	f, err := vfs.Open(filename)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	r := bufio.NewReaderSize(f, loadChunkSize)
+	head, _ := r.Peek(encodingSniffLength)
+	c := DetectFileCodec(filename, head)
+	if c == nil || len(head) == 0 {
+		return r, f, c, nil
+	}
+	cr, err := c.NewReader(r)
+	if err == nil {
+		// Many codecs only find out the file is invalid once read from
+		br := bufio.NewReaderSize(cr, loadChunkSize)
+		if _, err = br.Peek(1); err == nil {
+			return br, closers{cr, f}, c, nil
+		} else if err == io.EOF {
+			// Commands only tell they failed once they've exited
+			if err = cr.Close(); err == nil {
+				return bufio.NewReader(bytes.NewReader(nil)), f, c, nil
+			}
+		} else {
+			cr.Close()
+		}
+	}
+	f.Close()
+	msg := fmt.Sprintf("Couldn't read %s as a %s file, opening it as is: %s", filename, c.Name(), err)
+	log.Warn(msg)
+	if fe := GetEditor().Frontend(); fe != nil {
+		fe.StatusMessage(msg)
+	}
+	if f, err = vfs.Open(filename); err != nil {
+		return nil, nil, nil, err
+	}
+	return bufio.NewReaderSize(f, loadChunkSize), f, nil, nil
+}
+
+func (cs closers) Close() (err error) {
+	for _, c := range cs {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+// Reads what the file filename contains, through its codec.
+func readCodecFile(filename string) ([]byte, FileCodec, error) {
+	r, f, c, err := openFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := ioutil.ReadAll(r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return d, c, err
+}
+
+// Writes the buffer to w through the codec c, if it's not nil.
+func (v *View) writeFile(w io.Writer, c FileCodec) error {
+	if c == nil {
+		return v.writeTo(w)
+	}
+	cw, err := c.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	if err := v.writeTo(cw); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+func init() {
+	for _, c := range []FileCodec{
+		&gzipCodec{},
+		&bzip2Codec{},
+		// There's no zstd in the standard library
+		&commandCodec{
+			name:       CODEC_ZSTD,
+			extensions: []string{".zst"},
+			magic:      []byte{0x28, 0xb5, 0x2f, 0xfd},
+			read:       []string{"zstd", "-d", "-c", "-q"},
+			write:      []string{"zstd", "-c", "-q"},
+		},
+	} {
+		RegisterFileCodec(c)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/limetext/text"
+)
+
+// "bz2 text\n" compressed with bzip2
+var bzip2Data = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x4b, 0xcb,
+	0x4c, 0xce, 0x00, 0x00, 0x01, 0xd9, 0x80, 0x00, 0x10, 0x40, 0x00, 0x10,
+	0x00, 0x12, 0x00, 0x04, 0x50, 0x20, 0x00, 0x22, 0x06, 0x27, 0xa8, 0x43,
+	0x02, 0x22, 0x12, 0x20, 0x78, 0xbb, 0x92, 0x29, 0xc2, 0x84, 0x82, 0x5e,
+	0x5a, 0x66, 0x70,
+}
+
+func gzipData(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectFileCodec(t *testing.T) {
+	tests := []struct {
+		filename string
+		head     []byte
+		exp      string
+	}{
+		{"a.txt", []byte("text"), ""},
+		{"a.txt", []byte{0x1f, 0x8b, 8}, CODEC_GZIP},
+		{"a.gz", []byte{0x1f, 0x8b, 8}, CODEC_GZIP},
+		{"a.GZ", nil, CODEC_GZIP},
+		// Not actually compressed
+		{"a.gz", []byte("text"), ""},
+		// Magic bytes which are text need the extension
+		{"a", bzip2Data, ""},
+		{"a.bz2", bzip2Data, CODEC_BZIP2},
+		{"notes.txt", []byte("BZh is how bzip2 files start"), ""},
+		{"a.txt", []byte{0x1f, 0x8b}, ""},
+		{"a.zst", []byte{0x28, 0xb5, 0x2f, 0xfd, 0}, CODEC_ZSTD},
+		{"a.zst", nil, CODEC_ZSTD},
+	}
+	for i, test := range tests {
+		name := ""
+		if c := DetectFileCodec(test.filename, test.head); c != nil {
+			name = c.Name()
+		}
+		if name != test.exp {
+			t.Errorf("Test %d: Expected the codec %q, but got %q", i, test.exp, name)
+		}
+	}
+}
+
+func TestCompressedFile(t *testing.T) {
+	dir, rm := tempDir(t)
+	defer rm()
+	gz := filepath.Join(dir, "log.txt.gz")
+	if err := ioutil.WriteFile(gz, gzipData(t, "gzip text\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bz := filepath.Join(dir, "log.txt.bz2")
+	if err := ioutil.WriteFile(bz, bzip2Data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := GetEditor().NewWindow()
+	defer w.Close()
+	content := func(v *View) string {
+		return v.Substr(text.Region{A: 0, B: v.Size()})
+	}
+
+	v := w.OpenFile(gz, 0)
+	if s := content(v); s != "gzip text\n" || v.FileCodec() != CODEC_GZIP {
+		t.Errorf("Expected the decompressed text, but got %q with codec %q", s, v.FileCodec())
+	}
+	e := v.BeginEdit()
+	v.Insert(e, 0, "more ")
+	v.EndEdit(e)
+	if err := v.Save(); err != nil {
+		t.Fatalf("Couldn't save %s: %s", gz, err)
+	}
+	if d, c, err := readCodecFile(gz); err != nil || string(d) != "more gzip text\n" || c == nil || c.Name() != CODEC_GZIP {
+		t.Errorf("Expected the file to be saved compressed, but got %q, %v", d, err)
+	}
+
+	// Saving as another file uses the codec of its extension
+	plain := filepath.Join(dir, "log.txt")
+	if err := v.SaveAs(plain); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := ioutil.ReadFile(plain); string(d) != "more gzip text\n" || v.FileCodec() != "" {
+		t.Errorf("Expected the file to be saved uncompressed, but got %q with codec %q", d, v.FileCodec())
+	}
+	v.Close()
+
+	v = w.OpenFile(bz, 0)
+	if s := content(v); s != "bz2 text\n" || !v.IsReadOnly() {
+		t.Errorf("Expected the read-only decompressed text, but got %q, read-only: %v", s, v.IsReadOnly())
+	}
+	if err := v.Save(); err == nil {
+		t.Error("Expected an error saving a bzip2 file")
+	}
+	if d, _ := ioutil.ReadFile(bz); !bytes.Equal(d, bzip2Data) {
+		t.Error("Expected the bzip2 file to be left alone")
+	}
+	v.Close()
+}
+
+func TestCorruptCompressedFile(t *testing.T) {
+	dir, rm := tempDir(t)
+	defer rm()
+	fn := filepath.Join(dir, "log.gz")
+	data := append([]byte{0x1f, 0x8b, 8}, "not gzip text\n"...)
+	if err := ioutil.WriteFile(fn, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := GetEditor().NewWindow()
+	defer w.Close()
+	v := w.OpenFile(fn, 0)
+	defer v.Close()
+	// The gzip magic bytes aren't UTF-8 and are decoded with another encoding
+	if s := v.Substr(text.Region{A: 0, B: v.Size()}); v.Size() != len(data) || !strings.HasSuffix(s, "not gzip text\n") || v.FileCodec() != "" || v.IsReadOnly() {
+		t.Errorf("Expected the file as is, but got %q with codec %q, read-only: %v", s, v.FileCodec(), v.IsReadOnly())
+	}
+}
+
+func TestZstdFile(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("There's no zstd to compress with")
+	}
+	dir, rm := tempDir(t)
+	defer rm()
+	fn := filepath.Join(dir, "fixture.zst")
+
+	w := GetEditor().NewWindow()
+	defer w.Close()
+	v := w.NewFile()
+	e := v.BeginEdit()
+	v.Insert(e, 0, "zstd text\n")
+	v.EndEdit(e)
+	if err := v.SaveAs(fn); err != nil {
+		t.Fatalf("Couldn't save %s: %s", fn, err)
+	}
+	v.Close()
+
+	if d, _ := ioutil.ReadFile(fn); !bytes.HasPrefix(d, GetFileCodec(CODEC_ZSTD).Magic()) {
+		t.Errorf("Expected the file to be compressed, but got %q", d)
+	}
+	v = w.OpenFile(fn, 0)
+	defer v.Close()
+	if s := v.Substr(text.Region{A: 0, B: v.Size()}); s != "zstd text\n" {
+		t.Errorf("Expected the decompressed text, but got %q", s)
+	}
+}
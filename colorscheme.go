@@ -4,7 +4,18 @@
 
 package backend
 
-import "github.com/limetext/backend/render"
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/limetext/backend/log"
+	"github.com/limetext/backend/packages"
+	"github.com/limetext/backend/render"
+	"github.com/limetext/sublime/textmate/theme"
+	"github.com/limetext/util"
+)
 
 // Any color scheme view should implement this interface
 // also it should register it self from editor.AddColorSCheme
@@ -46,3 +57,357 @@ func defaultScheme() ColorScheme {
 	}
 	return colorscheme
 }
+
+type (
+	// A SublimeColorScheme is a ColorScheme in the .sublime-color-scheme
+	// format, see https://www.sublimetext.com/docs/3/color_schemes.html.
+	//
+	// Each of the foreground, background, font_style, foreground_adjust
+	// and selection_foreground properties is taken from the rule with the
+	// best matching scope selector which sets it, with later rules winning
+	// ties. Colours which fail to parse are logged and left out.
+	SublimeColorScheme struct {
+		lock     sync.RWMutex
+		path     string
+		name     string
+		settings render.Settings
+		rules    []colorRule
+		// Incremented each time the scheme is loaded
+		version int
+	}
+
+	colorRule struct {
+		name     string
+		selector string
+		// The properties set by the rule, nil when not set
+		foreground          *render.Colour
+		background          *render.Colour
+		selectionForeground *render.Colour
+		fontStyle           *render.FontStyle
+		foregroundAdjust    string
+		vars                map[string]string
+	}
+
+	// A TextMateColorScheme is a ColorScheme in the .tmTheme format,
+	// whose scopes are styled the way theme.Theme styles them.
+	TextMateColorScheme struct {
+		*theme.Theme
+	}
+)
+
+// LoadTextMateColorScheme loads the .tmTheme file at path.
+func LoadTextMateColorScheme(path string) (*TextMateColorScheme, error) {
+	tm, err := theme.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return &TextMateColorScheme{tm}, nil
+}
+
+func (cs *TextMateColorScheme) Name() string {
+	return cs.Theme.Name
+}
+
+// Returns the setting of the theme Spice uses for scope. The first
+// setting is the theme's global settings, which isn't a rule.
+func (cs *TextMateColorScheme) MatchRule(scope string) (render.Rule, bool) {
+	if len(cs.Settings) == 0 {
+		return render.Rule{}, false
+	}
+	if s := cs.ClosestMatchingSetting(scope); s != &cs.Settings[0] {
+		return render.Rule{Name: s.Name, Selector: s.Scope}, true
+	}
+	return render.Rule{}, false
+}
+
+// LoadSublimeColorScheme loads the .sublime-color-scheme file at path,
+// which is watched and reloaded on changes.
+func LoadSublimeColorScheme(path string) (*SublimeColorScheme, error) {
+	cs := &SublimeColorScheme{path: path}
+	if err := packages.LoadJSON(path, cs); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+func (cs *SublimeColorScheme) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Name      string
+		Variables map[string]string
+		Globals   map[string]string
+		Rules     []struct {
+			Name                string
+			Scope               string
+			Foreground          string
+			Background          string
+			SelectionForeground string  `json:"selection_foreground"`
+			FontStyle           *string `json:"font_style"`
+			ForegroundAdjust    string  `json:"foreground_adjust"`
+		}
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	colour := func(s string) *render.Colour {
+		if s == "" {
+			return nil
+		}
+		c, err := render.ParseColour(s, raw.Variables)
+		if err != nil {
+			log.Warn("Couldn't parse colour in color scheme %s: %s", cs.path, err)
+			return nil
+		}
+		return &c
+	}
+	var settings render.Settings
+	globals := map[string]*render.Colour{
+		"foreground":                  &settings.Foreground,
+		"background":                  &settings.Background,
+		"caret":                       &settings.Caret,
+		"line_highlight":              &settings.LineHighlight,
+		"bracket_contents_foreground": &settings.BracketContentsForeground,
+		"brackets_foreground":         &settings.BracketsForeground,
+		"brackets_background":         &settings.BracketsBackground,
+		"tags_foreground":             &settings.TagsForeground,
+		"find_highlight":              &settings.FindHighlight,
+		"find_highlight_foreground":   &settings.FindHighlightForeground,
+		"gutter":                      &settings.Gutter,
+		"gutter_foreground":           &settings.GutterForeground,
+		"selection":                   &settings.Selection,
+		"selection_foreground":        &settings.SelectionForeground,
+		"selection_border":            &settings.SelectionBorder,
+		"inactive_selection":          &settings.InactiveSelection,
+		"guide":                       &settings.Guide,
+		"active_guide":                &settings.ActiveGuide,
+		"stack_guide":                 &settings.StackGuide,
+		"highlight":                   &settings.Highlight,
+		"shadow":                      &settings.Shadow,
+	}
+	for k, v := range raw.Globals {
+		if p := globals[k]; p != nil {
+			if c := colour(v); c != nil {
+				*p = *c
+			}
+		}
+	}
+
+	rules := make([]colorRule, 0, len(raw.Rules))
+	for _, r := range raw.Rules {
+		cr := colorRule{
+			name:                r.Name,
+			selector:            r.Scope,
+			foreground:          colour(r.Foreground),
+			background:          colour(r.Background),
+			selectionForeground: colour(r.SelectionForeground),
+			vars:                raw.Variables,
+		}
+		if r.FontStyle != nil {
+			fs := parseFontStyle(*r.FontStyle)
+			cr.fontStyle = &fs
+		}
+		if r.ForegroundAdjust != "" {
+			if _, err := render.AdjustColour(render.Colour{}, r.ForegroundAdjust, raw.Variables); err != nil {
+				log.Warn("Couldn't parse foreground_adjust in color scheme %s: %s", cs.path, err)
+			} else {
+				cr.foregroundAdjust = r.ForegroundAdjust
+			}
+		}
+		rules = append(rules, cr)
+	}
+
+	cs.lock.Lock()
+	cs.name = raw.Name
+	cs.settings = settings
+	cs.rules = rules
+	cs.version++
+	cs.lock.Unlock()
+	return nil
+}
+
+func (cs *SublimeColorScheme) Version() int {
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+	return cs.version
+}
+
+// Returns the FontStyle of a space separated list of font styles.
+func parseFontStyle(s string) (ret render.FontStyle) {
+	for _, f := range strings.Fields(s) {
+		switch f {
+		case "bold":
+			ret |= render.Bold
+		case "italic":
+			ret |= render.Italic
+		case "underline", "stippled_underline", "squiggly_underline":
+			ret |= render.Underline
+		}
+	}
+	return
+}
+
+func (cs *SublimeColorScheme) Spice(vr *render.ViewRegions) (ret render.Flavour) {
+	pe := util.Prof.Enter("SublimeColorScheme.Spice")
+	defer pe.Exit()
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+
+	var (
+		fg, bg, sfg         *render.Colour
+		fs                  *render.FontStyle
+		adjust              *colorRule
+		fgs, bgs, sfgs, fss int
+		adjs                int
+	)
+	for i := range cs.rules {
+		r := &cs.rules[i]
+		s := scoreSelector(r.selector, vr.Scope)
+		if s == 0 {
+			continue
+		}
+		if r.foreground != nil && s >= fgs {
+			fg, fgs = r.foreground, s
+		}
+		if r.background != nil && s >= bgs {
+			bg, bgs = r.background, s
+		}
+		if r.selectionForeground != nil && s >= sfgs {
+			sfg, sfgs = r.selectionForeground, s
+		}
+		if r.fontStyle != nil && s >= fss {
+			fs, fss = r.fontStyle, s
+		}
+		if r.foregroundAdjust != "" && s >= adjs {
+			adjust, adjs = r, s
+		}
+	}
+
+	ret.Foreground = cs.settings.Foreground
+	if fg != nil {
+		ret.Foreground = *fg
+	}
+	// foreground_adjust changes the foreground of a less specific rule
+	if adjust != nil && adjs >= fgs {
+		ret.Foreground, _ = render.AdjustColour(ret.Foreground, adjust.foregroundAdjust, adjust.vars)
+	}
+	ret.Background = cs.settings.Background
+	if bg != nil {
+		ret.Background = *bg
+	}
+	if fs != nil {
+		ret.Font.Style = *fs
+	}
+	if vr.Flags&render.SELECTION != 0 {
+		ret.Background = cs.settings.Selection
+		if sfg != nil {
+			ret.Foreground = *sfg
+		} else if cs.settings.SelectionForeground.A != 0 {
+			ret.Foreground = cs.settings.SelectionForeground
+		}
+	}
+	return
+}
+
+func (cs *SublimeColorScheme) GlobalSettings() render.Settings {
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+	return cs.settings
+}
+
+// Returns the rule with the best matching scope selector,
+// regardless of which properties it sets.
+func (cs *SublimeColorScheme) MatchRule(scope string) (render.Rule, bool) {
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+	best, score := -1, 0
+	for i, r := range cs.rules {
+		if s := scoreSelector(r.selector, scope); s > 0 && s >= score {
+			best, score = i, s
+		}
+	}
+	if best == -1 {
+		return render.Rule{}, false
+	}
+	return render.Rule{Name: cs.rules[best].name, Selector: cs.rules[best].selector}, true
+}
+
+// Returns the name given in the color scheme,
+// or the file name without extension if there is none.
+func (cs *SublimeColorScheme) Name() string {
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+	if cs.name != "" {
+		return cs.name
+	}
+	return strings.TrimSuffix(filepath.Base(cs.path), filepath.Ext(cs.path))
+}
+
+// Returns how well selector matches scope, 0 meaning no match at all.
+//
+// The selector consists of comma separated alternatives, of which the best
+// matching one is used. Each alternative is a space separated list of scopes
+// which must appear in that order in scope, optionally followed by
+// exclusions prefixed with "-". A selector scope matches a scope if it's the
+// same or a prefix of it ending at a ".". Matches deeper in scope score
+// higher, followed by matches of more parts of the scope.
+func scoreSelector(selector, scope string) (best int) {
+	names := strings.Fields(scope)
+	for _, alt := range strings.Split(selector, ",") {
+		var (
+			include  []string
+			excludes [][]string
+		)
+		cur := &include
+		for _, f := range strings.Fields(alt) {
+			if strings.HasPrefix(f, "-") {
+				excludes = append(excludes, nil)
+				cur = &excludes[len(excludes)-1]
+				if f = f[1:]; f == "" {
+					continue
+				}
+			}
+			*cur = append(*cur, f)
+		}
+		if len(include) == 0 && len(excludes) == 0 {
+			continue
+		}
+		s := 1
+		if len(include) > 0 {
+			s = scorePath(include, names)
+		}
+		for _, ex := range excludes {
+			if scorePath(ex, names) > 0 {
+				s = 0
+			}
+		}
+		if s > best {
+			best = s
+		}
+	}
+	return
+}
+
+// Returns the score of the selector path matching names, or 0 if it doesn't.
+// The path is matched from the end, so that its last scope matches as deep
+// into names as possible.
+func scorePath(path, names []string) int {
+	if len(path) == 0 {
+		return 0
+	}
+	n, last := len(names)-1, -1
+	for i := len(path) - 1; i >= 0; i-- {
+		p := path[i]
+		for n >= 0 && names[n] != p && !strings.HasPrefix(names[n], p+".") {
+			n--
+		}
+		if n < 0 {
+			return 0
+		}
+		if last == -1 {
+			last = n
+		}
+		n--
+	}
+	dots := strings.Count(path[len(path)-1], ".") + 1
+	return (last+1)<<16 | dots<<8 | len(path)
+}
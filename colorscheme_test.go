@@ -7,25 +7,16 @@ package backend
 import (
 	"testing"
 
-	"github.com/limetext/sublime/textmate/theme"
+	"github.com/limetext/backend/render"
 	"github.com/limetext/text"
 )
 
-type dummyColorScheme struct {
-	*theme.Theme
-}
-
-func newDummyColorScheme(tb testing.TB, path string) *dummyColorScheme {
-	if tm, err := theme.Load(path); err != nil {
+func newDummyColorScheme(tb testing.TB, path string) *TextMateColorScheme {
+	cs, err := LoadTextMateColorScheme(path)
+	if err != nil {
 		tb.Fatalf("Error loading theme %s: %s", path, err)
-		return nil
-	} else {
-		return &dummyColorScheme{tm}
 	}
-}
-
-func (c *dummyColorScheme) Name() string {
-	return c.Theme.Name
+	return cs
 }
 
 func addSetColorScheme(tb testing.TB, settings *text.Settings, path string) {
@@ -33,3 +24,149 @@ func addSetColorScheme(tb testing.TB, settings *text.Settings, path string) {
 	GetEditor().AddColorScheme(path, cs)
 	settings.Set("colour_scheme", path)
 }
+
+func TestScoreSelector(t *testing.T) {
+	const scope = "source.go meta.function string.quoted.double"
+	tests := []struct {
+		selector string
+		exp      bool
+	}{
+		{"source", true},
+		{"source.go", true},
+		{"source.python", false},
+		{"sour", false},
+		{"source string", true},
+		{"string source", false},
+		{"string - meta", false},
+		{"string -comment", true},
+		{"comment, string", true},
+		{"", false},
+	}
+	for i, test := range tests {
+		if s := scoreSelector(test.selector, scope); (s > 0) != test.exp {
+			t.Errorf("Test %d: Expected %q matching to be %v, but got score %d", i, test.selector, test.exp, s)
+		}
+	}
+
+	ordered := []string{"source", "source.go", "meta", "string", "meta string", "string.quoted"}
+	for i := 1; i < len(ordered); i++ {
+		if a, b := scoreSelector(ordered[i-1], scope), scoreSelector(ordered[i], scope); a >= b {
+			t.Errorf("Expected %q to score higher than %q, but got %d and %d", ordered[i], ordered[i-1], b, a)
+		}
+	}
+}
+
+func TestSublimeColorScheme(t *testing.T) {
+	const path = "testdata/Test.sublime-color-scheme"
+	ed := GetEditor()
+	ed.loadColorScheme(path)
+	cs, ok := ed.GetColorScheme(path).(*SublimeColorScheme)
+	if !ok {
+		t.Fatalf("Expected %s to be loaded as a SublimeColorScheme", path)
+	}
+	if n := cs.Name(); n != "Test" {
+		t.Errorf("Expected the name to be Test, but got %s", n)
+	}
+
+	var (
+		black  = render.Colour{0, 0, 0, 255}
+		white  = render.Colour{255, 255, 255, 255}
+		red    = render.Colour{255, 0, 0, 255}
+		yellow = render.Colour{255, 255, 0, 255}
+		grey   = render.Colour{128, 128, 128, 255}
+	)
+	gs := cs.GlobalSettings()
+	if gs.Background != black || gs.Foreground != white {
+		t.Errorf("Expected the global background and foreground to be %v and %v, but got %v and %v", black, white, gs.Background, gs.Foreground)
+	}
+	if exp := (render.Colour{0xee, 0xee, 0xee, 255}); gs.SelectionForeground != exp {
+		t.Errorf("Expected the selection foreground to be %v, but got %v", exp, gs.SelectionForeground)
+	}
+	if gs.Caret != (render.Colour{}) {
+		t.Errorf("Expected the invalid caret colour to be left out, but got %v", gs.Caret)
+	}
+
+	tests := []struct {
+		scope string
+		flags render.ViewRegionFlags
+		exp   render.Flavour
+		rule  string
+	}{
+		{"source.go", 0, render.Flavour{Foreground: white, Background: black}, ""},
+		{"source.go string.quoted", 0, render.Flavour{Foreground: red, Background: black}, "String"},
+		{"source.go string.quoted constant.character.escape.go", 0,
+			render.Flavour{Foreground: yellow, Background: black, Font: render.Font{Style: render.Bold | render.Italic}}, "Escape"},
+		{"source.go string.quoted constant.character.escape.go", render.SELECTION,
+			render.Flavour{Foreground: black, Background: render.Colour{0x33, 0x33, 0x33, 255}, Font: render.Font{Style: render.Bold | render.Italic}}, "Escape"},
+		{"source.go string.quoted", render.SELECTION, render.Flavour{Foreground: render.Colour{0xee, 0xee, 0xee, 255}, Background: render.Colour{0x33, 0x33, 0x33, 255}}, "String"},
+		{"text.html source.embedded.js", 0, render.Flavour{Foreground: grey, Background: render.Colour{255, 0, 0, 128}}, "Embedded"},
+		{"text.html source.embedded.js string", 0, render.Flavour{Foreground: red, Background: black}, "String"},
+		{"source.go punctuation.definition.comment.go", 0, render.Flavour{Foreground: grey, Background: black, Font: render.Font{Style: render.Italic | render.Underline}}, "Comment"},
+	}
+	for i, test := range tests {
+		if f := cs.Spice(&render.ViewRegions{Scope: test.scope, Flags: test.flags}); f != test.exp {
+			t.Errorf("Test %d: Expected %s to be spiced as %v, but got %v", i, test.scope, test.exp, f)
+		}
+		r, ok := cs.MatchRule(test.scope)
+		if ok != (test.rule != "") || r.Name != test.rule {
+			t.Errorf("Test %d: Expected %s to match rule %q, but got %q", i, test.scope, test.rule, r.Name)
+		}
+	}
+}
+
+func TestSublimeColorSchemeReload(t *testing.T) {
+	cs := &SublimeColorScheme{}
+	load := func(fg string) {
+		if err := cs.UnmarshalJSON([]byte(`{"rules": [{"scope": "string", "foreground": "` + fg + `"}]}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vr := &render.ViewRegions{Scope: "source string"}
+
+	load("red")
+	if f := render.Spice(cs, vr); f.Foreground != (render.Colour{255, 0, 0, 255}) {
+		t.Errorf("Expected a red foreground, but got %v", f.Foreground)
+	}
+	load("blue")
+	if f := render.Spice(cs, vr); f.Foreground != (render.Colour{0, 0, 255, 255}) {
+		t.Errorf("Expected a blue foreground after reloading, but got %v", f.Foreground)
+	}
+}
+
+func TestLoadColorScheme(t *testing.T) {
+	const (
+		path    = "testdata/../testdata/Test.sublime-color-scheme"
+		missing = "testdata/missing.sublime-color-scheme"
+	)
+	ed := GetEditor()
+	w := ed.NewWindow()
+	defer w.Close()
+
+	v := w.NewFile()
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+
+	if cs, _ := ed.colorScheme(path); cs != nil {
+		t.Fatalf("Expected %s not to be loaded yet", path)
+	}
+	v.Settings().Set("color_scheme", path)
+	if cs, err := ed.colorScheme(path); cs == nil || err != nil {
+		t.Errorf("Expected %s to be loaded when set, but got %v, %v", path, cs, err)
+	}
+
+	v.Settings().Set("color_scheme", missing)
+	_, err := ed.colorScheme(missing)
+	if err == nil {
+		t.Fatalf("Expected %s to fail to load", missing)
+	}
+	// The failure is kept rather than loading again
+	ed.loadColorScheme(missing)
+	if _, err2 := ed.colorScheme(missing); err2 != err {
+		t.Errorf("Expected the error %v to be kept, but got %v", err, err2)
+	}
+	if cs := ed.GetColorScheme(missing); cs != defaultScheme() {
+		t.Errorf("Expected the default color scheme, but got %v", cs)
+	}
+}
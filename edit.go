@@ -25,6 +25,10 @@ type (
 		args       Args
 		v          *View
 		bypassUndo bool
+		// Whether the View was read-only when the Edit began, in
+		// which case the Edit doesn't change its buffer, and whether
+		// the user was told so
+		readOnly, refused bool
 	}
 )
 
@@ -32,6 +36,7 @@ func newEdit(v *View) *Edit {
 	ret := &Edit{
 		v:          v,
 		savedCount: v.ChangeCount(),
+		readOnly:   v.IsReadOnly(),
 	}
 	for _, r := range v.Sel().Regions() {
 		ret.savedSel.Add(r)
@@ -10,13 +10,18 @@ import (
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/limetext/backend/clipboard"
 	"github.com/limetext/backend/keys"
 	"github.com/limetext/backend/log"
 	"github.com/limetext/backend/packages"
+	"github.com/limetext/backend/render"
+	"github.com/limetext/backend/vfs"
 	"github.com/limetext/backend/watch"
+	"github.com/limetext/rubex"
 	"github.com/limetext/text"
 	"github.com/limetext/util"
 )
@@ -47,8 +52,16 @@ type Editor struct {
 	userPath         string
 	pkgsPaths        []string
 	colorSchemes     map[string]ColorScheme
-	syntaxes         map[string]Syntax
-	filetypes        map[string]string
+	// The errors of the color schemes which failed to load
+	schemeErrors map[string]error
+	schemeLock   sync.RWMutex
+	syntaxes     map[string]Syntax
+	filetypes    map[string]string
+	firstLines   map[string]*rubex.Regexp
+	// Restores the session, once the user path it's in is known
+	session sync.Once
+	// Functions run by the inputthread, see post
+	calls chan func()
 }
 
 var (
@@ -72,6 +85,7 @@ func GetEditor() *Editor {
 				scratch: true,
 			},
 			keyInput:         make(chan keys.KeyPress, 32),
+			calls:            make(chan func(), 32),
 			clipboard:        clipboard.NewSystemClipboard(),
 			defaultSettings:  new(text.HasSettings),
 			platformSettings: new(text.HasSettings),
@@ -80,13 +94,16 @@ func GetEditor() *Editor {
 			userKB:           new(keys.HasKeyBindings),
 			pkgsPaths:        make([]string, 0),
 			colorSchemes:     make(map[string]ColorScheme),
+			schemeErrors:     make(map[string]error),
 			syntaxes:         make(map[string]Syntax),
 			filetypes:        make(map[string]string),
+			firstLines:       make(map[string]*rubex.Regexp),
 		}
 		var err error
 		if ed.Watcher, err = watch.NewWatcher(); err != nil {
 			log.Error("Couldn't create watcher: %s", err)
 		}
+		vfs.Register(vfs.LOCAL, &vfs.Local{Watcher: ed.Watcher})
 
 		ed.console.Settings().Set("is_widget", true)
 		// Initializing settings hierarchy
@@ -139,10 +156,19 @@ func (e *Editor) SetFrontend(f Frontend) {
 	e.frontend = f
 }
 
+// Init initializes the editor, and restores the session saved when it last
+// exited once the user path is set, see HotExit.
 func (e *Editor) Init() {
 	log.Info("Initializing")
 	OnInit.call()
 	OnPackagesPathAdd.Add(packages.Scan)
+	if e.UserPath() != "" {
+		e.session.Do(e.initSession)
+	} else {
+		OnUserPathAdd.Add(func(string) {
+			e.session.Do(e.initSession)
+		})
+	}
 }
 
 func (e *Editor) loadDefaultKeyBindings(dir string) {
@@ -205,7 +231,11 @@ func (e *Editor) Windows() []*Window {
 }
 
 func (e *Editor) SetActiveWindow(w *Window) {
+	old := e.activeWindow
 	e.activeWindow = w
+	if old != nil && old != w {
+		OnWindowDeactivated.Call(old)
+	}
 }
 
 func (e *Editor) ActiveWindow() *Window {
@@ -234,11 +264,12 @@ func (e *Editor) remove(w *Window) {
 				copy(e.windows[i:], e.windows[i+1:])
 			}
 			e.windows = e.windows[:end]
+			// The removed window isn't deactivated, as it's closed
 			if e.ActiveWindow() == w {
 				if end != 0 {
-					e.SetActiveWindow(e.windows[end-1])
+					e.activeWindow = e.windows[end-1]
 				} else {
-					e.SetActiveWindow(nil)
+					e.activeWindow = nil
 				}
 			}
 			return
@@ -277,6 +308,13 @@ func (e *Editor) HandleInput(kp keys.KeyPress) {
 	e.keyInput <- kp
 }
 
+// Runs f on the goroutine handling the input, in between key presses, so
+// that it doesn't run concurrently with the commands they run, e.g from a
+// timer.
+func (e *Editor) post(f func()) {
+	e.calls <- f
+}
+
 func (e *Editor) inputthread() {
 	pc := 0
 	var lastBindings keys.KeyBindings
@@ -335,8 +373,21 @@ func (e *Editor) inputthread() {
 			p2.Exit()
 		}
 	}
-	for kp := range e.keyInput {
-		doinput(kp)
+	docall := func(f func()) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Error("Panic in inputthread: %v\n%s", r, string(debug.Stack()))
+			}
+		}()
+		f()
+	}
+	for {
+		select {
+		case kp := <-e.keyInput:
+			doinput(kp)
+		case f := <-e.calls:
+			docall(f)
+		}
 	}
 }
 
@@ -402,6 +453,18 @@ func (e *Editor) SetClipboard(s string) {
 	e.clipboard.Set(s, false)
 }
 
+// Watch calls the callbacks of cb when the file name changes, through the
+// file system of its URI, see the vfs package. Local files are watched with
+// the editor's Watcher.
+func (e *Editor) Watch(name string, cb interface{}) error {
+	return vfs.Watch(name, cb)
+}
+
+// UnWatch stops calling the callbacks of cb when the file name changes.
+func (e *Editor) UnWatch(name string, cb interface{}) error {
+	return vfs.UnWatch(name, cb)
+}
+
 func (e *Editor) handleLog(s string) {
 	c := e.Console()
 	f := fmt.Sprintf("%08d %d %s", c.Size(), len(s), s)
@@ -439,18 +502,75 @@ func (e *Editor) SetUserPath(p string) {
 }
 
 func (e *Editor) AddColorScheme(path string, cs ColorScheme) {
+	e.schemeLock.Lock()
+	old := e.colorSchemes[path]
 	e.colorSchemes[path] = cs
+	delete(e.schemeErrors, path)
+	e.schemeLock.Unlock()
+	if old != nil {
+		render.InvalidateSpice(old)
+	}
 }
 
-func (e *Editor) GetColorScheme(path string) ColorScheme {
+// Returns the color scheme added with path, or nil and the
+// error loading it if it failed to load.
+func (e *Editor) colorScheme(path string) (ColorScheme, error) {
+	e.schemeLock.RLock()
+	defer e.schemeLock.RUnlock()
 	if scheme := e.colorSchemes[path]; scheme != nil {
-		return scheme
+		return scheme, nil
 	} else if abs, err := filepath.Abs(path); err == nil {
 		if scheme = e.colorSchemes[abs]; scheme != nil {
-			return scheme
+			return scheme, nil
 		}
 	}
-	log.Error("No color scheme %s in editor falling back to default color scheme", path)
+	return nil, e.schemeErrors[path]
+}
+
+// Loads the .sublime-color-scheme or .tmTheme color scheme at path,
+// unless it has already been added or failed to load. It's called when
+// the "color_scheme" setting changes rather than when the color scheme is
+// used, so that it's loaded once. The error of a color scheme failing to
+// load is kept, until it's added with AddColorScheme.
+func (e *Editor) loadColorScheme(path string) {
+	if path == "" {
+		return
+	} else if scheme, err := e.colorScheme(path); scheme != nil || err != nil {
+		return
+	}
+	var (
+		scheme ColorScheme
+		err    error
+	)
+	switch {
+	case strings.HasSuffix(path, ".sublime-color-scheme"):
+		scheme, err = LoadSublimeColorScheme(path)
+	case strings.HasSuffix(path, ".tmTheme"):
+		scheme, err = LoadTextMateColorScheme(path)
+	default:
+		// Left to the packages to add
+		return
+	}
+	if err != nil {
+		log.Error("Couldn't load color scheme %s: %s", path, err)
+		e.schemeLock.Lock()
+		e.schemeErrors[path] = err
+		e.schemeLock.Unlock()
+		return
+	}
+	e.AddColorScheme(path, scheme)
+}
+
+// Returns the color scheme added with path, or the default color
+// scheme if there's none. See loadColorScheme for how color schemes
+// are loaded.
+func (e *Editor) GetColorScheme(path string) ColorScheme {
+	scheme, err := e.colorScheme(path)
+	if scheme != nil {
+		return scheme
+	} else if err == nil {
+		log.Error("No color scheme %s in editor falling back to default color scheme", path)
+	}
 	return defaultScheme()
 }
 
@@ -462,6 +582,13 @@ func (e *Editor) AddSyntax(path string, s Syntax) {
 	for _, t := range s.FileTypes() {
 		e.filetypes[t] = path
 	}
+	if flm, ok := s.(FirstLineMatcher); ok && flm.FirstLineMatch() != "" {
+		if re, err := rubex.Compile(flm.FirstLineMatch()); err != nil {
+			log.Warn("Couldn't compile first line match of %s: %s", path, err)
+		} else {
+			e.firstLines[path] = re
+		}
+	}
 }
 
 func (e *Editor) GetSyntax(path string) Syntax {
@@ -472,6 +599,44 @@ func (e *Editor) fileTypeSyntax(ext string) string {
 	return e.filetypes[ext]
 }
 
+// Returns the first syntax whose first line match matches line.
+func (e *Editor) firstLineSyntax(line string) string {
+	paths := make([]string, 0, len(e.firstLines))
+	for p := range e.firstLines {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if e.firstLines[p].MatchString(line) {
+			return p
+		}
+	}
+	return ""
+}
+
+// Returns the syntax identified by a mode name as used in
+// modelines and shebangs. The mode is compared with the file types,
+// the names and the file names of the syntaxes, ignoring case.
+func (e *Editor) modeSyntax(mode string) string {
+	mode = strings.ToLower(mode)
+	if syn := e.fileTypeSyntax(mode); syn != "" {
+		return syn
+	}
+	paths := make([]string, 0, len(e.syntaxes))
+	for p := range e.syntaxes {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		base := path.Base(p)
+		base = strings.TrimSuffix(base, path.Ext(base))
+		if strings.ToLower(e.syntaxes[p].Name()) == mode || strings.ToLower(base) == mode {
+			return p
+		}
+	}
+	return ""
+}
+
 // TODO: should generate sth like sublime text syntaxes menu
 // the name in the menu should come from defined name inside syntax file or
 // the syntax file name
@@ -0,0 +1,689 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/limetext/backend/log"
+	"github.com/limetext/text"
+)
+
+// The names of the built-in encodings, as used by the
+// "default_encoding" and "fallback_encoding" settings
+const (
+	ENCODING_UTF8         = "UTF-8"
+	ENCODING_UTF8_BOM     = "UTF-8 with BOM"
+	ENCODING_UTF16_LE     = "UTF-16 LE"
+	ENCODING_UTF16_LE_BOM = "UTF-16 LE with BOM"
+	ENCODING_UTF16_BE     = "UTF-16 BE"
+	ENCODING_UTF16_BE_BOM = "UTF-16 BE with BOM"
+	ENCODING_ISO_8859_1   = "Western (ISO 8859-1)"
+	ENCODING_WINDOWS_1252 = "Western (Windows 1252)"
+	ENCODING_SHIFT_JIS    = "Japanese (Shift-JIS)"
+)
+
+const (
+	// How many bytes of a file DetectEncoding looks at for UTF-16
+	encodingSniffLength = 4096
+	// The share of NUL bytes, as a percentage of the characters,
+	// above which text is detected as UTF-16
+	encodingUTF16MinNULPercent = 20
+)
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+type (
+	// An Encoding converts between the bytes of a file and the text of a
+	// Buffer. Encodings other than the built-in ones, e.g EUC-KR, can
+	// be added with RegisterEncoding.
+	Encoding interface {
+		// The name of the encoding, e.g "UTF-8"
+		Name() string
+		// Decodes data into text, dropping any byte order mark.
+		// Bytes which can't be decoded are replaced with
+		// utf8.RuneError and reported in a *DecodeError.
+		Decode(data []byte) (string, error)
+		// Encodes text, adding a byte order mark if the encoding has one.
+		// Returns an *EncodeError if some character can't be encoded.
+		Encode(text string) ([]byte, error)
+	}
+
+	// A DecodeError reports bytes which aren't valid in an encoding.
+	DecodeError struct {
+		Encoding string
+		// The offset of the first undecodable byte
+		Offset int
+		// The number of undecodable bytes
+		Count int
+	}
+
+	// An EncodeError reports a character which can't be encoded.
+	EncodeError struct {
+		Encoding string
+		// The offset, in characters, of the first character
+		// which can't be encoded
+		Offset int
+		Rune   rune
+	}
+
+	utf8Encoding struct {
+		bom bool
+	}
+
+	utf16Encoding struct {
+		bigEndian, bom bool
+	}
+
+	// A single byte encoding
+	charmapEncoding struct {
+		name string
+		// The characters of the bytes from 0x80 on,
+		// utf8.RuneError for the undefined ones
+		high [128]rune
+		once sync.Once
+		enc  map[rune]byte
+	}
+
+	// Shift-JIS, with the characters of JIS X 0208
+	shiftJISEncoding struct {
+		once sync.Once
+		enc  map[rune][2]byte
+	}
+)
+
+var encodings = struct {
+	sync.Mutex
+	m map[string]Encoding
+}{m: make(map[string]Encoding)}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%d byte(s) couldn't be decoded as %s, the first at offset %d", e.Count, e.Encoding, e.Offset)
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("%q at offset %d can't be encoded as %s", e.Rune, e.Offset, e.Encoding)
+}
+
+func (e *utf8Encoding) Name() string {
+	if e.bom {
+		return ENCODING_UTF8_BOM
+	}
+	return ENCODING_UTF8
+}
+
+func (e *utf8Encoding) Decode(data []byte) (string, error) {
+	data = bytes.TrimPrefix(data, bomUTF8)
+	if utf8.Valid(data) {
+		return string(data), nil
+	}
+	var (
+		buf bytes.Buffer
+		de  *DecodeError
+	)
+	for i := 0; i < len(data); {
+		r, n := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && n == 1 {
+			if de == nil {
+				de = &DecodeError{Encoding: e.Name(), Offset: i}
+			}
+			de.Count++
+		}
+		buf.WriteRune(r)
+		i += n
+	}
+	if de != nil {
+		return buf.String(), de
+	}
+	return buf.String(), nil
+}
+
+func (e *utf8Encoding) Encode(text string) ([]byte, error) {
+	if !e.bom {
+		return []byte(text), nil
+	}
+	return append(append([]byte{}, bomUTF8...), text...), nil
+}
+
+func (e *utf16Encoding) Name() string {
+	switch {
+	case e.bigEndian && e.bom:
+		return ENCODING_UTF16_BE_BOM
+	case e.bigEndian:
+		return ENCODING_UTF16_BE
+	case e.bom:
+		return ENCODING_UTF16_LE_BOM
+	}
+	return ENCODING_UTF16_LE
+}
+
+func (e *utf16Encoding) Decode(data []byte) (string, error) {
+	if e.bigEndian {
+		data = bytes.TrimPrefix(data, bomUTF16BE)
+	} else {
+		data = bytes.TrimPrefix(data, bomUTF16LE)
+	}
+	var (
+		buf bytes.Buffer
+		de  *DecodeError
+	)
+	bad := func(offset, count int) {
+		if de == nil {
+			de = &DecodeError{Encoding: e.Name(), Offset: offset}
+		}
+		de.Count += count
+		buf.WriteRune(utf8.RuneError)
+	}
+	unit := func(i int) rune {
+		if e.bigEndian {
+			return rune(data[i])<<8 | rune(data[i+1])
+		}
+		return rune(data[i+1])<<8 | rune(data[i])
+	}
+	i := 0
+	for ; i+1 < len(data); i += 2 {
+		r := unit(i)
+		if !utf16.IsSurrogate(r) {
+			buf.WriteRune(r)
+		} else if i+3 < len(data) {
+			if r = utf16.DecodeRune(r, unit(i+2)); r != utf8.RuneError {
+				buf.WriteRune(r)
+				i += 2
+			} else {
+				bad(i, 2)
+			}
+		} else {
+			bad(i, 2)
+		}
+	}
+	if i < len(data) {
+		bad(i, 1)
+	}
+	if de != nil {
+		return buf.String(), de
+	}
+	return buf.String(), nil
+}
+
+func (e *utf16Encoding) Encode(text string) ([]byte, error) {
+	units := utf16.Encode([]rune(text))
+	ret := make([]byte, 0, 2*len(units)+2)
+	if e.bom {
+		units = append([]uint16{0xfeff}, units...)
+	}
+	for _, u := range units {
+		if e.bigEndian {
+			ret = append(ret, byte(u>>8), byte(u))
+		} else {
+			ret = append(ret, byte(u), byte(u>>8))
+		}
+	}
+	return ret, nil
+}
+
+func (e *charmapEncoding) Name() string {
+	return e.name
+}
+
+func (e *charmapEncoding) Decode(data []byte) (string, error) {
+	var de *DecodeError
+	rs := make([]rune, len(data))
+	for i, b := range data {
+		if b < 0x80 {
+			rs[i] = rune(b)
+			continue
+		}
+		rs[i] = e.high[b-0x80]
+		if rs[i] == utf8.RuneError {
+			if de == nil {
+				de = &DecodeError{Encoding: e.name, Offset: i}
+			}
+			de.Count++
+		}
+	}
+	if de != nil {
+		return string(rs), de
+	}
+	return string(rs), nil
+}
+
+func (e *charmapEncoding) Encode(text string) ([]byte, error) {
+	e.once.Do(func() {
+		e.enc = make(map[rune]byte, len(e.high))
+		for i, r := range e.high {
+			if r != utf8.RuneError {
+				e.enc[r] = byte(0x80 + i)
+			}
+		}
+	})
+	ret := make([]byte, 0, len(text))
+	i := 0
+	for _, r := range text {
+		if r < 0x80 {
+			ret = append(ret, byte(r))
+		} else if b, ok := e.enc[r]; ok {
+			ret = append(ret, b)
+		} else {
+			return nil, &EncodeError{Encoding: e.name, Offset: i, Rune: r}
+		}
+		i++
+	}
+	return ret, nil
+}
+
+func newCharmapEncoding(name string, high map[byte]rune) *charmapEncoding {
+	e := &charmapEncoding{name: name}
+	for i := range e.high {
+		e.high[i] = rune(0x80 + i)
+	}
+	for b, r := range high {
+		e.high[b-0x80] = r
+	}
+	return e
+}
+
+func (e *shiftJISEncoding) Name() string {
+	return ENCODING_SHIFT_JIS
+}
+
+func (e *shiftJISEncoding) Decode(data []byte) (string, error) {
+	var (
+		buf bytes.Buffer
+		de  *DecodeError
+	)
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		switch {
+		case b < 0x80:
+			buf.WriteByte(b)
+			continue
+		case b >= 0xa1 && b <= 0xdf:
+			// Half width katakana
+			buf.WriteRune(0xff61 + rune(b-0xa1))
+			continue
+		case i+1 < len(data):
+			if j := shiftJISIndex(b, data[i+1]); j >= 0 && j < len(shiftJISTable) && shiftJISTable[j] != 0 {
+				buf.WriteRune(rune(shiftJISTable[j]))
+				i++
+				continue
+			}
+		}
+		// The following byte is decoded on its own, as it
+		// might well be a character rather than a trail byte
+		if de == nil {
+			de = &DecodeError{Encoding: e.Name(), Offset: i}
+		}
+		de.Count++
+		buf.WriteRune(utf8.RuneError)
+	}
+	if de != nil {
+		return buf.String(), de
+	}
+	return buf.String(), nil
+}
+
+func (e *shiftJISEncoding) Encode(text string) ([]byte, error) {
+	e.once.Do(func() {
+		e.enc = make(map[rune][2]byte, len(shiftJISTable))
+		for j, r := range shiftJISTable {
+			if r == 0 {
+				continue
+			}
+			l, t := byte(j/188), byte(j%188)
+			if l < 31 {
+				l += 0x81
+			} else {
+				l += 0xe0 - 31
+			}
+			if t < 63 {
+				t += 0x40
+			} else {
+				t += 0x80 - 63
+			}
+			e.enc[rune(r)] = [2]byte{l, t}
+		}
+	})
+	ret := make([]byte, 0, len(text))
+	i := 0
+	for _, r := range text {
+		if r < 0x80 {
+			ret = append(ret, byte(r))
+		} else if r >= 0xff61 && r <= 0xff9f {
+			ret = append(ret, byte(r-0xff61)+0xa1)
+		} else if b, ok := e.enc[r]; ok {
+			ret = append(ret, b[0], b[1])
+		} else {
+			return nil, &EncodeError{Encoding: e.Name(), Offset: i, Rune: r}
+		}
+		i++
+	}
+	return ret, nil
+}
+
+// Returns the index in shiftJISTable of the double byte code made of
+// lead and trail, or -1 if they can't be the bytes of such a code.
+func shiftJISIndex(lead, trail byte) int {
+	var l, t int
+	switch {
+	case lead >= 0x81 && lead <= 0x9f:
+		l = int(lead - 0x81)
+	case lead >= 0xe0 && lead <= 0xef:
+		l = int(lead-0xe0) + 31
+	default:
+		return -1
+	}
+	switch {
+	case trail >= 0x40 && trail <= 0x7e:
+		t = int(trail - 0x40)
+	case trail >= 0x80 && trail <= 0xfc:
+		t = int(trail-0x80) + 63
+	default:
+		return -1
+	}
+	return l*188 + t
+}
+
+// RegisterEncoding makes an Encoding available under its name,
+// replacing any encoding previously registered with the same name.
+func RegisterEncoding(e Encoding) {
+	encodings.Lock()
+	defer encodings.Unlock()
+	encodings.m[strings.ToLower(e.Name())] = e
+}
+
+// GetEncoding returns the Encoding with the given name, which
+// is case insensitive, or nil if there's no such encoding.
+func GetEncoding(name string) Encoding {
+	encodings.Lock()
+	defer encodings.Unlock()
+	return encodings.m[strings.ToLower(name)]
+}
+
+// Encodings returns the sorted names of the registered encodings.
+func Encodings() (ret []string) {
+	encodings.Lock()
+	defer encodings.Unlock()
+	for _, e := range encodings.m {
+		ret = append(ret, e.Name())
+	}
+	sort.Strings(ret)
+	return
+}
+
+// DetectEncoding guesses the encoding of data. A byte order mark decides
+// the encoding, then text which looks like UTF-16 is detected as such, and
+// valid UTF-8 as UTF-8. Anything else is assumed to be in the def encoding
+// unless that's UTF-8 too, in which case it's in the fallback encoding.
+// Unknown def or fallback encodings are ignored.
+func DetectEncoding(data []byte, def, fallback string) Encoding {
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		return GetEncoding(ENCODING_UTF8_BOM)
+	case bytes.HasPrefix(data, bomUTF16LE):
+		return GetEncoding(ENCODING_UTF16_LE_BOM)
+	case bytes.HasPrefix(data, bomUTF16BE):
+		return GetEncoding(ENCODING_UTF16_BE_BOM)
+	}
+	if enc := sniffUTF16(data); enc != "" {
+		return GetEncoding(enc)
+	}
+	if utf8.Valid(data) {
+		return GetEncoding(ENCODING_UTF8)
+	}
+	for _, name := range []string{def, fallback} {
+		if e := GetEncoding(name); e != nil {
+			if _, ok := e.(*utf8Encoding); !ok {
+				return e
+			}
+		}
+	}
+	return GetEncoding(ENCODING_WINDOWS_1252)
+}
+
+// Returns the name of the UTF-16 encoding data seems to be in, if any.
+// Mostly ASCII text in UTF-16 has NUL bytes every other byte: at the odd
+// offsets in little endian, and at the even ones in big endian.
+func sniffUTF16(data []byte) string {
+	if len(data) > encodingSniffLength {
+		data = data[:encodingSniffLength]
+	}
+	if len(data) < 2 || len(data)%2 != 0 {
+		return ""
+	}
+	var nuls [2]int
+	for i, b := range data {
+		if b == 0 {
+			nuls[i%2]++
+		}
+	}
+	min := len(data) / 2 * encodingUTF16MinNULPercent / 100
+	switch {
+	case nuls[0] == 0 && nuls[1] > min:
+		return ENCODING_UTF16_LE
+	case nuls[1] == 0 && nuls[0] > min:
+		return ENCODING_UTF16_BE
+	}
+	return ""
+}
+
+// Returns the name of the encoding of this View. That's the one it was
+// loaded with, or set with SetEncoding, and otherwise "default_encoding".
+func (v *View) Encoding() string {
+	v.lock.Lock()
+	enc := v.encoding
+	v.lock.Unlock()
+	if enc == "" {
+		enc = v.Settings().String("default_encoding", ENCODING_UTF8)
+	}
+	return enc
+}
+
+// Sets the encoding the View is saved with.
+func (v *View) SetEncoding(name string) error {
+	e := GetEncoding(name)
+	if e == nil {
+		return fmt.Errorf("unknown encoding %s", name)
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.encoding = e.Name()
+	return nil
+}
+
+// Returns the encoding data is detected to be in, according
+// to the "default_encoding" and "fallback_encoding" settings.
+func (v *View) detectEncoding(data []byte) Encoding {
+	s := v.Settings()
+	return DetectEncoding(data, s.String("default_encoding", ENCODING_UTF8), s.String("fallback_encoding", ENCODING_WINDOWS_1252))
+}
+
+// Reads and decodes filename, with the named encoding or, if that's
+// empty, the detected one. See decode.
+func (v *View) readFile(filename, enc string) (string, error) {
+	d, c, err := readCodecFile(filename)
+	if err != nil {
+		return "", err
+	}
+	v.setFileCodec(c)
+	return v.decode(filename, d, enc)
+}
+
+// Decodes the data of filename, with the named encoding or, if that's
+// empty, the detected one. The encoding and line endings are recorded
+// as the View's, and the line endings of the returned text are "\n".
+// Bytes which can't be decoded are reported to the user rather than
+// silently replaced.
+func (v *View) decode(filename string, data []byte, enc string) (string, error) {
+	var e Encoding
+	if enc == "" {
+		e = v.detectEncoding(data)
+	} else if e = GetEncoding(enc); e == nil {
+		return "", fmt.Errorf("unknown encoding %s", enc)
+	}
+	str, err := e.Decode(data)
+	if err != nil {
+		reportDecodeError(filename, err)
+	}
+	v.lock.Lock()
+	v.encoding = e.Name()
+	v.lock.Unlock()
+	return v.loadLineEndings(str), nil
+}
+
+func reportDecodeError(filename string, err error) {
+	msg := fmt.Sprintf("%s: %s", filename, err)
+	log.Warn(msg)
+	if fe := GetEditor().Frontend(); fe != nil {
+		fe.ErrorMessage(msg)
+	}
+}
+
+// Writes the content of the View to w with its line endings, encoded
+// with its encoding. The content is encoded and written a chunk at a time
+// rather than all at once, so that large files don't need to be copied.
+func (v *View) writeTo(w io.Writer) error {
+	e := GetEncoding(v.Encoding())
+	if e == nil {
+		return fmt.Errorf("unknown encoding %s", v.Encoding())
+	}
+	// What e adds to everything it encodes, i.e its byte order mark,
+	// is only written once
+	bom, err := e.Encode("")
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(bom); err != nil {
+		return err
+	}
+	le := lineEndingString(v.LineEnding())
+	for a, size := 0, v.Size(); a < size; a += saveChunkSize {
+		r := text.Region{A: a, B: a + saveChunkSize}
+		if r.B > size {
+			r.B = size
+		}
+		s := v.Substr(r)
+		if le != "\n" {
+			s = strings.Replace(s, "\n", le, -1)
+		}
+		d, err := e.Encode(s)
+		if ee, ok := err.(*EncodeError); ok {
+			// Make the offset one in the buffer
+			ee.Offset += a - (len(le)-1)*strings.Count(string([]rune(s)[:ee.Offset]), le)
+			return ee
+		} else if err != nil {
+			return err
+		}
+		if _, err := w.Write(bytes.TrimPrefix(d, bom)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the error writeTo would return if the content of the View can't
+// be encoded with its encoding, without writing it. Unicode encodings can
+// encode anything, so there's nothing to check for them.
+func (v *View) checkEncoding() error {
+	switch GetEncoding(v.Encoding()).(type) {
+	case *utf8Encoding, *utf16Encoding:
+		return nil
+	}
+	return v.writeTo(ioutil.Discard)
+}
+
+type (
+	// The SetEncodingCommand changes the encoding the view
+	// is saved with, without changing its content.
+	SetEncodingCommand struct {
+		BypassUndoCommand
+		Encoding string
+	}
+
+	// The ReopenWithEncodingCommand reloads the file of the view,
+	// decoding it with the given encoding.
+	ReopenWithEncodingCommand struct {
+		DefaultCommand
+		Encoding string
+	}
+)
+
+func (c *SetEncodingCommand) Run(v *View, e *Edit) error {
+	return v.SetEncoding(c.Encoding)
+}
+
+func (c *ReopenWithEncodingCommand) IsEnabled() bool {
+	return GetEncoding(c.Encoding) != nil
+}
+
+func (c *ReopenWithEncodingCommand) Run(v *View, e *Edit) error {
+	fn := v.FileName()
+	if fn == "" {
+		return fmt.Errorf("the view has no file to reopen")
+	}
+	if v.IsDirty() {
+		if fe := GetEditor().Frontend(); fe != nil && !fe.OkCancelDialog("Discard the unsaved changes and reopen the file?", "Reopen") {
+			return nil
+		}
+	}
+	d, err := v.readFile(fn, c.Encoding)
+	if err != nil {
+		return err
+	}
+	// Reopening isn't editing, read-only views can be reopened too, so
+	// the reload is done in an Edit of its own rather than in e, which
+	// refuses to change them. Ending it inside of e adds it to e, so
+	// it's still undone with e.
+	re := v.beginReloadEdit()
+	v.Replace(re, text.Region{A: 0, B: v.Size()}, d)
+	v.EndEdit(re)
+	v.setSaved()
+	return nil
+}
+
+func init() {
+	for _, e := range []Encoding{
+		&utf8Encoding{},
+		&utf8Encoding{bom: true},
+		&utf16Encoding{},
+		&utf16Encoding{bom: true},
+		&utf16Encoding{bigEndian: true},
+		&utf16Encoding{bigEndian: true, bom: true},
+		newCharmapEncoding(ENCODING_ISO_8859_1, nil),
+		newCharmapEncoding(ENCODING_WINDOWS_1252, map[byte]rune{
+			0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„',
+			0x85: '…', 0x86: '†', 0x87: '‡', 0x88: 'ˆ',
+			0x89: '‰', 0x8a: 'Š', 0x8b: '‹', 0x8c: 'Œ',
+			0x8e: 'Ž', 0x91: '‘', 0x92: '’', 0x93: '“',
+			0x94: '”', 0x95: '•', 0x96: '–', 0x97: '—',
+			0x98: '˜', 0x99: '™', 0x9a: 'š', 0x9b: '›',
+			0x9c: 'œ', 0x9e: 'ž', 0x9f: 'Ÿ',
+			// Undefined
+			0x81: utf8.RuneError, 0x8d: utf8.RuneError, 0x8f: utf8.RuneError,
+			0x90: utf8.RuneError, 0x9d: utf8.RuneError,
+		}),
+		&shiftJISEncoding{},
+	} {
+		RegisterEncoding(e)
+	}
+
+	ch := GetEditor().CommandHandler()
+	for _, cmd := range []interface{}{&SetEncodingCommand{}, &ReopenWithEncodingCommand{}} {
+		if err := ch.RegisterWithDefault(cmd); err != nil {
+			log.Error("Failed to register command: %s", err)
+		}
+	}
+}
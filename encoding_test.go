@@ -0,0 +1,175 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/limetext/text"
+)
+
+func TestEncodingRoundTrip(t *testing.T) {
+	tests := []struct {
+		enc  string
+		text string
+		data []byte
+	}{
+		{ENCODING_UTF8, "aé€", []byte("aé€")},
+		{ENCODING_UTF8_BOM, "aé", []byte("\xef\xbb\xbfaé")},
+		{ENCODING_UTF16_LE, "a€", []byte{'a', 0, 0xac, 0x20}},
+		{ENCODING_UTF16_LE_BOM, "a", []byte{0xff, 0xfe, 'a', 0}},
+		{ENCODING_UTF16_BE, "a😀", []byte{0, 'a', 0xd8, 0x3d, 0xde, 0x00}},
+		{ENCODING_UTF16_BE_BOM, "a", []byte{0xfe, 0xff, 0, 'a'}},
+		{ENCODING_ISO_8859_1, "aé\u0080", []byte{'a', 0xe9, 0x80}},
+		{ENCODING_WINDOWS_1252, "a€é", []byte{'a', 0x80, 0xe9}},
+		{ENCODING_SHIFT_JIS, "a日本ｱ\\~", []byte{'a', 0x93, 0xfa, 0x96, 0x7b, 0xb1, '\\', '~'}},
+	}
+	for i, test := range tests {
+		e := GetEncoding(test.enc)
+		if e == nil {
+			t.Errorf("Test %d: Expected encoding %s to be registered", i, test.enc)
+			continue
+		}
+		if s, err := e.Decode(test.data); err != nil || s != test.text {
+			t.Errorf("Test %d: Expected %q decoded to be %q, but got %q (%v)", i, test.data, test.text, s, err)
+		}
+		if d, err := e.Encode(test.text); err != nil || !bytes.Equal(d, test.data) {
+			t.Errorf("Test %d: Expected %q encoded to be %q, but got %q (%v)", i, test.text, test.data, d, err)
+		}
+	}
+}
+
+func TestEncodingErrors(t *testing.T) {
+	tests := []struct {
+		enc           string
+		data          []byte
+		text          string
+		offset, count int
+	}{
+		{ENCODING_UTF8, []byte("a\xffb\xfe"), "a�b�", 1, 2},
+		{ENCODING_UTF16_LE, []byte{'a', 0, 'b'}, "a�", 2, 1},
+		{ENCODING_UTF16_LE, []byte{0x3d, 0xd8, 'a', 0}, "�a", 0, 2},
+		{ENCODING_WINDOWS_1252, []byte{'a', 0x81, 0x80, 0x9d}, "a�€�", 1, 2},
+		{ENCODING_SHIFT_JIS, []byte{0x93, '\n', 0x93, 0xfa, 0xf0, 0x40, 0x93}, "�\n日�@�", 0, 3},
+	}
+	for i, test := range tests {
+		s, err := GetEncoding(test.enc).Decode(test.data)
+		de, ok := err.(*DecodeError)
+		if !ok {
+			t.Errorf("Test %d: Expected a DecodeError, but got %v", i, err)
+			continue
+		}
+		if s != test.text || de.Offset != test.offset || de.Count != test.count {
+			t.Errorf("Test %d: Expected %q, %d, %d, but got %q, %d, %d", i, test.text, test.offset, test.count, s, de.Offset, de.Count)
+		}
+	}
+
+	_, err := GetEncoding(ENCODING_ISO_8859_1).Encode("ab€")
+	if ee, ok := err.(*EncodeError); !ok || ee.Offset != 2 || ee.Rune != '€' {
+		t.Errorf("Expected an EncodeError for '€' at 2, but got %v", err)
+	}
+	_, err = GetEncoding(ENCODING_WINDOWS_1252).Encode("a\u0081")
+	if ee, ok := err.(*EncodeError); !ok || ee.Offset != 1 || ee.Rune != '\u0081' {
+		t.Errorf("Expected an EncodeError for '\u0081' at 1, but got %v", err)
+	}
+	_, err = GetEncoding(ENCODING_SHIFT_JIS).Encode("日€")
+	if ee, ok := err.(*EncodeError); !ok || ee.Offset != 1 || ee.Rune != '€' {
+		t.Errorf("Expected an EncodeError for '€' at 1, but got %v", err)
+	}
+}
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		data          []byte
+		def, fallback string
+		exp           string
+	}{
+		{[]byte("abc"), ENCODING_UTF8, ENCODING_WINDOWS_1252, ENCODING_UTF8},
+		{[]byte("\xef\xbb\xbfabc"), ENCODING_UTF8, ENCODING_WINDOWS_1252, ENCODING_UTF8_BOM},
+		{[]byte{0xff, 0xfe, 'a', 0}, ENCODING_UTF8, ENCODING_WINDOWS_1252, ENCODING_UTF16_LE_BOM},
+		{[]byte{0xfe, 0xff, 0, 'a'}, ENCODING_UTF8, ENCODING_WINDOWS_1252, ENCODING_UTF16_BE_BOM},
+		{[]byte{'a', 0, 'b', 0}, ENCODING_UTF8, ENCODING_WINDOWS_1252, ENCODING_UTF16_LE},
+		{[]byte{0, 'a', 0, 'b'}, ENCODING_UTF8, ENCODING_WINDOWS_1252, ENCODING_UTF16_BE},
+		{[]byte("caf\xe9"), ENCODING_UTF8, ENCODING_WINDOWS_1252, ENCODING_WINDOWS_1252},
+		{[]byte("caf\xe9"), ENCODING_ISO_8859_1, ENCODING_WINDOWS_1252, ENCODING_ISO_8859_1},
+		{[]byte("caf\xe9"), "nonexistent", ENCODING_ISO_8859_1, ENCODING_ISO_8859_1},
+	}
+	for i, test := range tests {
+		if e := DetectEncoding(test.data, test.def, test.fallback); e.Name() != test.exp {
+			t.Errorf("Test %d: Expected %s, but got %s", i, test.exp, e.Name())
+		}
+	}
+}
+
+func TestViewEncoding(t *testing.T) {
+	dir, rm := tempDir(t)
+	defer rm()
+	fn := filepath.Join(dir, "latin1.txt")
+	if err := ioutil.WriteFile(fn, []byte("caf\xe9"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ed := GetEditor()
+	w := ed.NewWindow()
+	defer w.Close()
+	v := w.OpenFile(fn, 0)
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+
+	if enc := v.Encoding(); enc != ENCODING_WINDOWS_1252 {
+		t.Errorf("Expected the encoding to be detected as %s, but got %s", ENCODING_WINDOWS_1252, enc)
+	}
+	if s := v.Substr(text.Region{A: 0, B: v.Size()}); s != "café" {
+		t.Errorf("Expected the content to be %q, but got %q", "café", s)
+	}
+
+	e := v.BeginEdit()
+	v.Insert(e, v.Size(), "!")
+	v.EndEdit(e)
+	if err := v.Save(); err != nil {
+		t.Fatalf("Couldn't save: %s", err)
+	}
+	if d, _ := ioutil.ReadFile(fn); string(d) != "caf\xe9!" {
+		t.Errorf("Expected the file to be saved as %s, but got %q", ENCODING_WINDOWS_1252, d)
+	}
+
+	if err := ed.CommandHandler().RunTextCommand(v, "set_encoding", Args{"encoding": ENCODING_UTF16_LE_BOM}); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Save(); err != nil {
+		t.Fatalf("Couldn't save: %s", err)
+	}
+	if d, _ := ioutil.ReadFile(fn); !bytes.Equal(d, []byte{0xff, 0xfe, 'c', 0, 'a', 0, 'f', 0, 0xe9, 0, '!', 0}) {
+		t.Errorf("Expected the file to be saved as %s, but got %q", ENCODING_UTF16_LE_BOM, d)
+	}
+
+	if err := ed.CommandHandler().RunTextCommand(v, "reopen_with_encoding", Args{"encoding": ENCODING_ISO_8859_1}); err != nil {
+		t.Fatal(err)
+	}
+	if s := v.Substr(text.Region{A: 0, B: v.Size()}); s != "ÿþc\x00a\x00f\x00é\x00!\x00" {
+		t.Errorf("Expected the content reopened as %s, but got %q", ENCODING_ISO_8859_1, s)
+	}
+	if v.Encoding() != ENCODING_ISO_8859_1 || v.IsDirty() {
+		t.Errorf("Expected a clean view in %s, but got %s, dirty: %v", ENCODING_ISO_8859_1, v.Encoding(), v.IsDirty())
+	}
+
+	v.SetEncoding(ENCODING_ISO_8859_1)
+	e = v.BeginEdit()
+	v.Insert(e, 0, "€")
+	v.EndEdit(e)
+	before, _ := ioutil.ReadFile(fn)
+	v.Settings().Set("atomic_save", false)
+	if _, ok := v.Save().(*EncodeError); !ok {
+		t.Error("Expected saving a character the encoding doesn't have to fail")
+	}
+	if d, _ := ioutil.ReadFile(fn); !bytes.Equal(d, before) {
+		t.Errorf("Expected the file not to be written, but got %q", d)
+	}
+}
@@ -170,8 +170,9 @@ var (
 	OnSelectionModified ViewEvent //< Called when a view's Selection/cursor has changed.
 	OnStatusChanged     ViewEvent //< Called when a view's status has changed.
 
-	OnNewWindow      WindowEvent //< Called when a new window has been created.
-	OnProjectChanged WindowEvent
+	OnNewWindow         WindowEvent //< Called when a new window has been created.
+	OnWindowDeactivated WindowEvent //< Called when another window becomes the active one.
+	OnProjectChanged    WindowEvent
 
 	OnQueryContext QueryContextEvent //< Called when context is being queried.
 
@@ -200,8 +201,9 @@ var (
 		&OnSelectionModified: "OnSelectionModified",
 	}
 	wevNames = map[*WindowEvent]string{
-		&OnNewWindow:      "OnNewWindow",
-		&OnProjectChanged: "OnProjectChanged",
+		&OnNewWindow:         "OnNewWindow",
+		&OnWindowDeactivated: "OnWindowDeactivated",
+		&OnProjectChanged:    "OnProjectChanged",
 	}
 	pkgPathevNames = map[*PathEvent]string{
 		&OnPackagesPathAdd:    "OnPackagesPathAdd",
@@ -0,0 +1,149 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/limetext/backend/log"
+	"github.com/limetext/backend/render"
+	"github.com/limetext/backend/vfs"
+	"github.com/limetext/text"
+)
+
+// Exports the view as a html file, highlighted with the view's
+// syntax and colour scheme.
+type ExportHtmlCommand struct {
+	DefaultCommand
+	// The file to write to. If empty the view's file name with
+	// ".html" appended is used, or the user is prompted for one.
+	Path string
+	// Whether to include line numbers and gutter icons
+	LineNumbers bool
+	GutterIcons bool
+}
+
+// ExportHTML writes the contents of this View to w as a standalone html
+// document, highlighted with the View's syntax and colour scheme.
+func (v *View) ExportHTML(w io.Writer, lineNumbers, gutterIcons bool) error {
+	all := text.Region{A: 0, B: v.Size()}
+	s := v.Settings()
+	r := &render.HTMLRenderer{
+		W:           w,
+		Buffer:      v.buffer,
+		Scheme:      GetEditor().GetColorScheme(s.String("color_scheme", "")),
+		Title:       filepath.Base(v.FileName()),
+		Font:        render.Font{Name: s.String("font_face", "")},
+		TabSize:     s.Int("tab_size", 4),
+		LineNumbers: lineNumbers,
+	}
+	if v.FileName() == "" {
+		r.Title = v.Name()
+	}
+	if gutterIcons {
+		r.Gutter = v.Gutter(all)
+	}
+	r.Render(v.transform(all, render.TransformFlags))
+	return r.Err()
+}
+
+// ExportANSI writes the contents of this View to w with ANSI escape
+// sequences, highlighted with the View's syntax and colour scheme.
+func (v *View) ExportANSI(w io.Writer, colours render.ANSIColours) error {
+	r := &render.ANSIRenderer{
+		W:       w,
+		Buffer:  v.buffer,
+		Scheme:  GetEditor().GetColorScheme(v.Settings().String("color_scheme", "")),
+		Colours: colours,
+	}
+	r.Render(v.transform(text.Region{A: 0, B: v.Size()}, render.TransformFlags))
+	return r.Err()
+}
+
+const (
+	// How long Cat waits for a file to be parsed.
+	catParseTimeout = 10 * time.Second
+	// How often waitForParse checks whether the parse has finished.
+	parsePollInterval = 10 * time.Millisecond
+)
+
+// Cat writes filename to w highlighted with ANSI escape sequences, like
+// a syntax highlighting cat(1). The file is opened in a new window, with
+// the syntax detected for it and the "color_scheme" setting, and closed
+// once it has been written.
+func Cat(w io.Writer, filename string, colours render.ANSIColours) error {
+	if _, err := vfs.Stat(filename); err != nil {
+		return err
+	}
+	wnd := GetEditor().NewWindow()
+	defer wnd.Close()
+	v := wnd.OpenFile(filename, 0)
+	if v == nil {
+		return fmt.Errorf("%s is a binary file", filename)
+	}
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+
+	v.lock.Lock()
+	v.reparse(true)
+	v.lock.Unlock()
+	if !v.waitForParse(catParseTimeout) {
+		return fmt.Errorf("Timed out parsing %s", filename)
+	}
+	return v.ExportANSI(w, colours)
+}
+
+// Waits for the buffer as it is now to have been parsed.
+// Returns false if that didn't happen before the timeout.
+// "lime.syntax.updated" is polled, as adding an on change
+// callback would race with the parse worker setting it.
+func (v *View) waitForParse(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for v.Settings().Int("lime.syntax.updated", -1) != v.ChangeCount() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(parsePollInterval)
+	}
+	return true
+}
+
+func (c *ExportHtmlCommand) Run(v *View, e *Edit) error {
+	p := c.Path
+	if p == "" {
+		if fn := v.FileName(); fn != "" {
+			p = fn + ".html"
+		} else {
+			dir, _ := os.Getwd()
+			fs := GetEditor().Frontend().Prompt("Export HTML", dir, PROMPT_SAVE_AS)
+			if len(fs) == 0 {
+				return nil
+			}
+			p = fs[0]
+		}
+	}
+
+	f, err := vfs.Write(p)
+	if err != nil {
+		return err
+	}
+	if err := v.ExportHTML(f, c.LineNumbers, c.GutterIcons); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func init() {
+	if err := GetEditor().CommandHandler().RegisterWithDefault(&ExportHtmlCommand{}); err != nil {
+		log.Error("Failed to register command: %s", err)
+	}
+}
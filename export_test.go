@@ -0,0 +1,90 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/limetext/backend/render"
+	"github.com/limetext/backend/vfs"
+	"github.com/limetext/text"
+)
+
+func TestExportHtmlCommand(t *testing.T) {
+	dir, rm := tempDir(t)
+	defer rm()
+
+	ed := GetEditor()
+	w := ed.NewWindow()
+	defer w.Close()
+
+	v := w.NewFile()
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+
+	e := v.BeginEdit()
+	v.Insert(e, 0, "a & b\nc")
+	v.EndEdit(e)
+	v.AddRegions("marks", []text.Region{{A: 6, B: 7}}, "", "mark.png", 0)
+
+	exp := `<span class="ln"><img class="icon" alt="">1 </span>a &amp; b
+<span class="ln"><img class="icon" src="mark.png" alt="marks">2 </span>c</pre>`
+	for i, p := range []string{filepath.Join(dir, "out.html"), "mem://export/out.html"} {
+		args := Args{"path": p, "line_numbers": true, "gutter_icons": true}
+		if err := ed.CommandHandler().RunTextCommand(v, "export_html", args); err != nil {
+			t.Errorf("Test %d: %s", i, err)
+			continue
+		}
+		d, err := vfs.ReadFile(p)
+		if err != nil {
+			t.Errorf("Test %d: %s", i, err)
+		} else if !strings.Contains(string(d), exp) {
+			t.Errorf("Test %d: Expected the exported html to contain\n%s\nbut got\n%s", i, exp, d)
+		}
+	}
+}
+
+func TestCat(t *testing.T) {
+	f, err := ioutil.TempFile("", "lime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	const data = "hello\nworld\n"
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	var out strings.Builder
+	if err := Cat(&out, f.Name(), render.ANSI_TRUE_COLOUR); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != data {
+		t.Errorf("Expected %q, but got %q", data, got)
+	}
+
+	if err := Cat(&out, f.Name()+"missing", render.ANSI_TRUE_COLOUR); err == nil {
+		t.Error("Expected an error catting a missing file")
+	}
+
+	const mem = "mem://cat/file.txt"
+	if err := vfs.WriteFile(mem, []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := Cat(&out, mem, render.ANSI_TRUE_COLOUR); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != data {
+		t.Errorf("Expected %q, but got %q", data, got)
+	}
+}
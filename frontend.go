@@ -4,7 +4,11 @@
 
 package backend
 
-import "github.com/limetext/text"
+import (
+	"io"
+
+	"github.com/limetext/text"
+)
 
 // The Frontend interface defines the API
 // for functionality that is frontend specific.
@@ -38,6 +42,28 @@ type Frontend interface {
 	Prompt(title, folder string, flags int) []string
 }
 
+// A ProgressFrontend is a Frontend which can show the progress of long
+// running operations, such as loading a large file. Frontends which
+// aren't are told about the progress with status messages.
+type ProgressFrontend interface {
+	Frontend
+
+	// Shows that done out of total units of the operation
+	// described by msg are done. Total is 0 if it isn't known.
+	Progress(msg string, done, total int)
+}
+
+// An ElevationFrontend is a Frontend which can save files the editor is
+// denied permission to write, e.g. by running a helper program with
+// elevated privileges.
+type ElevationFrontend interface {
+	Frontend
+
+	// Writes what's read from r, the encoded content of v,
+	// to the file name.
+	SaveWithElevation(v *View, name string, r io.Reader) error
+}
+
 const (
 	// Prompt save as dialog
 	PROMPT_SAVE_AS = 1 << iota
@@ -5,12 +5,34 @@
 package backend
 
 import (
+	"io/ioutil"
+	"os"
 	"sync"
+	"testing"
 
 	"github.com/limetext/backend/log"
 	"github.com/limetext/text"
 )
 
+// Sets fe as the frontend of the editor, returning
+// a function which sets the previous one back.
+func setFrontend(fe Frontend) func() {
+	ed := GetEditor()
+	old := ed.Frontend()
+	ed.SetFrontend(fe)
+	return func() { ed.SetFrontend(old) }
+}
+
+// Creates a temporary directory for the files of a
+// test, returning a function which removes it.
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "lime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
 type dummyFrontend struct {
 	m sync.Mutex
 	// Default return value for OkCancelDialog
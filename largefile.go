@@ -0,0 +1,198 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/limetext/backend/vfs"
+)
+
+const (
+	// The default of the "large_file_size" setting, in bytes
+	defaultLargeFileSize = 16 << 20
+	// How many bytes of a large file are loaded at a time
+	loadChunkSize = 1 << 20
+	// How many characters are encoded and written at a time when saving
+	saveChunkSize = 1 << 20
+)
+
+// Returned by loadFile when the user chose not to open a binary file
+var errBinaryFile = errors.New("binary file")
+
+// Returns whether the View was loaded in large file mode, because its file
+// was at least "large_file_size" bytes. Large files aren't syntax highlighted,
+// nor are their changes since they were saved tracked, and bracket matching
+// is off by default.
+func (v *View) IsLargeFile() bool {
+	return v.Settings().Bool("lime.large_file", false)
+}
+
+// Returns whether data, the beginning of a file, looks like a binary file
+// rather than text. Text doesn't contain NUL bytes, unless it's in UTF-16.
+func isBinary(data []byte, e Encoding) bool {
+	if _, ok := e.(*utf16Encoding); ok {
+		return false
+	}
+	return bytes.IndexByte(data, 0) != -1
+}
+
+// Loads filename into the empty View with e. The user is asked whether to
+// open files which look binary, and if not errBinaryFile is returned. With
+// no Frontend to ask, they're opened. Files
+// of at least "large_file_size" bytes are loaded in chunks, the progress of
+// which is shown by the Frontend. Files with a FileCodec are read through
+// it, their size being that of the file rather than of what it contains.
+func (v *View) loadFile(e *Edit, filename string) error {
+	fi, err := vfs.Stat(filename)
+	if err != nil {
+		return err
+	}
+	r, f, c, err := openFile(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	v.setFileCodec(c)
+	// Shorter files make Peek return an error, with all there is
+	head, _ := r.Peek(encodingSniffLength)
+	enc := v.detectEncoding(head)
+	if isBinary(head, enc) {
+		fe := GetEditor().Frontend()
+		if fe != nil && !fe.OkCancelDialog(fmt.Sprintf("%s looks like a binary file, open it anyway?", filename), "Open") {
+			return errBinaryFile
+		}
+	}
+
+	if fi.Size() < int64(v.Settings().Int("large_file_size", defaultLargeFileSize)) {
+		d, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		s, err := v.decode(filename, d, "")
+		if err != nil {
+			return err
+		}
+		v.Insert(e, 0, s)
+		return nil
+	}
+
+	v.Settings().Set("lime.large_file", true)
+	v.Settings().Set("match_brackets", false)
+	v.lock.Lock()
+	v.encoding = enc.Name()
+	v.lock.Unlock()
+	total := int(fi.Size())
+	if c != nil {
+		// How much there is to load isn't known
+		total = 0
+	}
+	return v.loadChunks(e, filename, r, enc, total)
+}
+
+// Decodes r with enc and inserts it at the end of the View a chunk at a time.
+func (v *View) loadChunks(e *Edit, filename string, r io.Reader, enc Encoding, total int) error {
+	var (
+		buf     = make([]byte, loadChunkSize)
+		rest    []byte
+		done    int
+		pending string
+		derr    *DecodeError
+		msg     = "Loading " + filename
+	)
+	for {
+		n, err := io.ReadFull(r, buf)
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return err
+		}
+		data := append(rest, buf[:n]...)
+		cut := len(data)
+		if !eof {
+			cut = chunkBoundary(enc, data)
+		}
+		s, err := enc.Decode(data[:cut])
+		if de, ok := err.(*DecodeError); ok {
+			if derr == nil {
+				derr = &DecodeError{Encoding: de.Encoding, Offset: done + de.Offset}
+			}
+			derr.Count += de.Count
+		}
+		rest = append([]byte(nil), data[cut:]...)
+		done += cut
+
+		// A "\r\n" split between chunks is one line ending
+		s, pending = pending+s, ""
+		if !eof && strings.HasSuffix(s, "\r") {
+			s, pending = s[:len(s)-1], "\r"
+		}
+		v.lock.Lock()
+		detected := v.lineEnding != ""
+		v.lock.Unlock()
+		if detected {
+			s = NormalizeLineEndings(s)
+		} else {
+			s = v.loadLineEndings(s)
+		}
+		v.Insert(e, v.Size(), s)
+		progress(msg, done, total)
+		if eof {
+			break
+		}
+	}
+	if derr != nil {
+		reportDecodeError(filename, derr)
+	}
+	return nil
+}
+
+// Returns how many bytes of data can be decoded with e without splitting
+// a character. That's after the last "\n", or for UTF-16 an even number of
+// bytes not ending in the first half of a surrogate pair.
+func chunkBoundary(e Encoding, data []byte) int {
+	if u, ok := e.(*utf16Encoding); ok {
+		n := len(data) &^ 1
+		if n >= 2 {
+			hi := data[n-1]
+			if u.bigEndian {
+				hi = data[n-2]
+			}
+			if hi&0xfc == 0xd8 {
+				n -= 2
+			}
+		}
+		return n
+	}
+	if i := bytes.LastIndexByte(data, '\n'); i != -1 {
+		return i + 1
+	}
+	// A very long line, for UTF-8 at least don't split a character
+	n := len(data)
+	for i := n - 1; i >= 0 && i >= n-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				n = i
+			}
+			break
+		}
+	}
+	return n
+}
+
+// Shows the progress of an operation on the Frontend.
+func progress(msg string, done, total int) {
+	fe := GetEditor().Frontend()
+	if pf, ok := fe.(ProgressFrontend); ok {
+		pf.Progress(msg, done, total)
+	} else if fe != nil && total > 0 {
+		fe.StatusMessage(fmt.Sprintf("%s: %d%%", msg, 100*done/total))
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/limetext/text"
+)
+
+type progressFrontend struct {
+	dummyFrontend
+	done, total int
+}
+
+func (fe *progressFrontend) Progress(msg string, done, total int) {
+	fe.m.Lock()
+	defer fe.m.Unlock()
+	fe.done, fe.total = done, total
+}
+
+func TestChunkBoundary(t *testing.T) {
+	tests := []struct {
+		enc  string
+		data []byte
+		exp  int
+	}{
+		{ENCODING_UTF8, []byte("ab\ncd"), 3},
+		{ENCODING_UTF8, []byte("ab\xe2\x82"), 2},
+		{ENCODING_UTF8, []byte("ab\xe2\x82\xac"), 5},
+		{ENCODING_WINDOWS_1252, []byte("a\nb"), 2},
+		{ENCODING_UTF16_LE, []byte{'a', 0, '\n', 0, 'b'}, 4},
+		{ENCODING_UTF16_LE, []byte{'a', 0, 0x3d, 0xd8}, 2},
+		{ENCODING_UTF16_BE, []byte{0, 'a', 0xd8, 0x3d}, 2},
+	}
+	for i, test := range tests {
+		if n := chunkBoundary(GetEncoding(test.enc), test.data); n != test.exp {
+			t.Errorf("Test %d: Expected %d, but got %d", i, test.exp, n)
+		}
+	}
+}
+
+func TestLargeFile(t *testing.T) {
+	dir, rm := tempDir(t)
+	defer rm()
+
+	// Long enough to be loaded in several chunks, with line
+	// endings and multibyte characters straddling them
+	line := strings.Repeat("é", 99) + "\r\n"
+	content := strings.Repeat(line, 3*loadChunkSize/len(line))
+	fn := filepath.Join(dir, "large.txt")
+	if err := ioutil.WriteFile(fn, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ed := GetEditor()
+	fe := &progressFrontend{}
+	defer setFrontend(fe)()
+
+	w := ed.NewWindow()
+	defer w.Close()
+	w.Settings().Set("large_file_size", loadChunkSize)
+	v := w.OpenFile(fn, 0)
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+
+	if !v.IsLargeFile() {
+		t.Fatal("Expected the view to be in large file mode")
+	}
+	if fe.done != len(content) || fe.total != len(content) {
+		t.Errorf("Expected the progress to end at %d of %d, but got %d of %d", len(content), len(content), fe.done, fe.total)
+	}
+	if exp := NormalizeLineEndings(content); v.Substr(text.Region{A: 0, B: v.Size()}) != exp {
+		t.Error("Expected the content of the view to be that of the file")
+	}
+	if v.LineEnding() != LINE_ENDING_WINDOWS || v.Encoding() != ENCODING_UTF8 {
+		t.Errorf("Expected %s and %s, but got %s and %s", LINE_ENDING_WINDOWS, ENCODING_UTF8, v.LineEnding(), v.Encoding())
+	}
+	if v.LineChanges() != nil {
+		t.Error("Expected the line changes of a large file not to be tracked")
+	}
+
+	e := v.BeginEdit()
+	v.Insert(e, 0, "x")
+	v.EndEdit(e)
+	if err := v.Save(); err != nil {
+		t.Fatalf("Couldn't save: %s", err)
+	}
+	if d, _ := ioutil.ReadFile(fn); string(d) != "x"+content {
+		t.Error("Expected the saved file to be the content of the view")
+	}
+}
+
+func TestOpenBinaryFile(t *testing.T) {
+	dir, rm := tempDir(t)
+	defer rm()
+	fn := filepath.Join(dir, "binary")
+	if err := ioutil.WriteFile(fn, []byte("\x7fELF\x00\x01\x02"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ed := GetEditor()
+	fe := &dummyFrontend{}
+	defer setFrontend(fe)()
+
+	w := ed.NewWindow()
+	defer w.Close()
+	if v := w.OpenFile(fn, 0); v != nil {
+		t.Error("Expected a binary file not to be opened")
+	}
+	if l := len(w.Views()); l != 0 {
+		t.Errorf("Expected no views, but got %d", l)
+	}
+
+	fe.SetDefaultAction(true)
+	v := w.OpenFile(fn, 0)
+	if v == nil {
+		t.Fatal("Expected the binary file to be opened when confirmed")
+	}
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+	if err := v.Save(); err != nil {
+		t.Fatalf("Couldn't save: %s", err)
+	}
+	if d, _ := ioutil.ReadFile(fn); !bytes.Equal(d, []byte("\x7fELF\x00\x01\x02")) {
+		t.Errorf("Expected the binary file to be saved unchanged, but got %q", d)
+	}
+
+	// There's no one to ask without a Frontend
+	ed.SetFrontend(nil)
+	v2 := w.OpenFile(fn, 0)
+	if v2 == nil {
+		t.Fatal("Expected the binary file to be opened without a frontend")
+	}
+	v2.SetScratch(true)
+	v2.Close()
+}
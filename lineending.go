@@ -0,0 +1,149 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/limetext/backend/log"
+)
+
+// The line ending styles of files. Buffers always use "\n",
+// files are saved with the line endings of their View.
+const (
+	LINE_ENDING_UNIX    = "Unix"    // "\n"
+	LINE_ENDING_WINDOWS = "Windows" // "\r\n"
+	LINE_ENDING_CR      = "CR"      // "\r", i.e Classic Mac
+)
+
+// Returns the line ending style called name, case insensitively,
+// or "" if there's none. "system" is the style of the platform.
+func lineEndingStyle(name string) string {
+	switch strings.ToLower(name) {
+	case "unix":
+		return LINE_ENDING_UNIX
+	case "windows":
+		return LINE_ENDING_WINDOWS
+	case "cr":
+		return LINE_ENDING_CR
+	case "system":
+		if runtime.GOOS == "windows" {
+			return LINE_ENDING_WINDOWS
+		}
+		return LINE_ENDING_UNIX
+	}
+	return ""
+}
+
+// Returns the characters ending lines in the given style.
+func lineEndingString(style string) string {
+	switch style {
+	case LINE_ENDING_WINDOWS:
+		return "\r\n"
+	case LINE_ENDING_CR:
+		return "\r"
+	}
+	return "\n"
+}
+
+// DetectLineEnding returns the most common line ending style of s, the
+// first one found winning ties, or "" if s is a single line.
+func DetectLineEnding(s string) string {
+	var (
+		counts = make(map[string]int)
+		first  string
+	)
+	for i := 0; i < len(s); i++ {
+		var style string
+		switch {
+		case s[i] == '\r' && i+1 < len(s) && s[i+1] == '\n':
+			style = LINE_ENDING_WINDOWS
+			i++
+		case s[i] == '\r':
+			style = LINE_ENDING_CR
+		case s[i] == '\n':
+			style = LINE_ENDING_UNIX
+		default:
+			continue
+		}
+		if first == "" {
+			first = style
+		}
+		counts[style]++
+	}
+	ret := first
+	for _, style := range []string{LINE_ENDING_UNIX, LINE_ENDING_WINDOWS, LINE_ENDING_CR} {
+		if counts[style] > counts[ret] {
+			ret = style
+		}
+	}
+	return ret
+}
+
+// NormalizeLineEndings returns s with all its line endings as "\n".
+func NormalizeLineEndings(s string) string {
+	if !strings.Contains(s, "\r") {
+		return s
+	}
+	return strings.Replace(strings.Replace(s, "\r\n", "\n", -1), "\r", "\n", -1)
+}
+
+// Returns the line ending style of this View, which is the one its file
+// had when loaded, or was set with SetLineEnding, and otherwise the
+// "default_line_ending" setting.
+func (v *View) LineEnding() string {
+	v.lock.Lock()
+	style := v.lineEnding
+	v.lock.Unlock()
+	if style == "" {
+		if style = lineEndingStyle(v.Settings().String("default_line_ending", "system")); style == "" {
+			style = lineEndingStyle("system")
+		}
+	}
+	return style
+}
+
+// Sets the line ending style the View is saved with, one of "unix",
+// "windows", "cr" or "system", case insensitively.
+func (v *View) SetLineEnding(name string) error {
+	style := lineEndingStyle(name)
+	if style == "" {
+		return fmt.Errorf("unknown line ending %s", name)
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.lineEnding = style
+	return nil
+}
+
+// Records the line ending style of the text of a file loaded into this
+// View, and returns the text with its line endings normalized.
+func (v *View) loadLineEndings(s string) string {
+	if style := DetectLineEnding(s); style != "" {
+		v.lock.Lock()
+		v.lineEnding = style
+		v.lock.Unlock()
+	}
+	return NormalizeLineEndings(s)
+}
+
+// The SetLineEndingCommand changes the line endings
+// the view is saved with to the given Type.
+type SetLineEndingCommand struct {
+	BypassUndoCommand
+	Type string
+}
+
+func (c *SetLineEndingCommand) Run(v *View, e *Edit) error {
+	return v.SetLineEnding(c.Type)
+}
+
+func init() {
+	if err := GetEditor().CommandHandler().RegisterWithDefault(&SetLineEndingCommand{}); err != nil {
+		log.Error("Failed to register command: %s", err)
+	}
+}
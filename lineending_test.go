@@ -0,0 +1,106 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/limetext/text"
+)
+
+func TestDetectLineEnding(t *testing.T) {
+	tests := []struct {
+		in, exp string
+	}{
+		{"", ""},
+		{"abc", ""},
+		{"a\nb\n", LINE_ENDING_UNIX},
+		{"a\r\nb\r\n", LINE_ENDING_WINDOWS},
+		{"a\rb\r", LINE_ENDING_CR},
+		{"a\r\nb\nc\r\n", LINE_ENDING_WINDOWS},
+		{"a\rb\n", LINE_ENDING_CR},
+		{"a\nb\r", LINE_ENDING_UNIX},
+	}
+	for i, test := range tests {
+		if le := DetectLineEnding(test.in); le != test.exp {
+			t.Errorf("Test %d: Expected %q, but got %q", i, test.exp, le)
+		}
+	}
+}
+
+func TestNormalizeLineEndings(t *testing.T) {
+	tests := []struct {
+		in, exp string
+	}{
+		{"a\nb", "a\nb"},
+		{"a\r\nb\r\n", "a\nb\n"},
+		{"a\rb\r\r\n", "a\nb\n\n"},
+	}
+	for i, test := range tests {
+		if s := NormalizeLineEndings(test.in); s != test.exp {
+			t.Errorf("Test %d: Expected %q, but got %q", i, test.exp, s)
+		}
+	}
+}
+
+func TestViewLineEnding(t *testing.T) {
+	dir, rm := tempDir(t)
+	defer rm()
+	fn := filepath.Join(dir, "windows.txt")
+	if err := ioutil.WriteFile(fn, []byte("a\r\nb\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ed := GetEditor()
+	w := ed.NewWindow()
+	defer w.Close()
+	v := w.OpenFile(fn, 0)
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+
+	if le := v.LineEnding(); le != LINE_ENDING_WINDOWS {
+		t.Errorf("Expected the line endings to be detected as %s, but got %s", LINE_ENDING_WINDOWS, le)
+	}
+	if s := v.Substr(text.Region{A: 0, B: v.Size()}); s != "a\nb\n" {
+		t.Errorf("Expected the line endings to be normalized, but got %q", s)
+	}
+	if r := v.Line(0); r != (text.Region{A: 0, B: 1}) {
+		t.Errorf("Expected the first line to be %v, but got %v", text.Region{A: 0, B: 1}, r)
+	}
+
+	if err := v.Save(); err != nil {
+		t.Fatalf("Couldn't save: %s", err)
+	}
+	if d, _ := ioutil.ReadFile(fn); string(d) != "a\r\nb\r\n" {
+		t.Errorf("Expected the line endings to be restored, but got %q", d)
+	}
+
+	if err := ed.CommandHandler().RunTextCommand(v, "set_line_ending", Args{"type": "cr"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Save(); err != nil {
+		t.Fatalf("Couldn't save: %s", err)
+	}
+	if d, _ := ioutil.ReadFile(fn); string(d) != "a\rb\r" {
+		t.Errorf("Expected the line endings to be converted, but got %q", d)
+	}
+	if err := v.SetLineEnding("unknown"); err == nil {
+		t.Error("Expected an error setting an unknown line ending")
+	}
+
+	nv := w.NewFile()
+	defer func() {
+		nv.SetScratch(true)
+		nv.Close()
+	}()
+	nv.Settings().Set("default_line_ending", "windows")
+	if le := nv.LineEnding(); le != LINE_ENDING_WINDOWS {
+		t.Errorf("Expected a new file to use the default line ending %s, but got %s", LINE_ENDING_WINDOWS, le)
+	}
+}
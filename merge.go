@@ -0,0 +1,237 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/limetext/backend/vfs"
+	"github.com/limetext/text"
+	"github.com/limetext/util"
+)
+
+// The markers conflicts are inserted with
+const (
+	MERGE_MARKER_LOCAL = "<<<<<<< buffer"
+	MERGE_MARKER_SEP   = "======="
+	MERGE_MARKER_DISK  = ">>>>>>> disk"
+)
+
+// Above this many lines, in each of the texts, the lines of a changed
+// part of them aren't matched, and the whole part conflicts if changed
+// on both sides.
+const maxMergeLines = 2048
+
+type (
+	// A MergeConflict is a part of a file changed differently
+	// in a View and on disk since it was last loaded or saved.
+	MergeConflict struct {
+		// The region of the merged text the conflict is in,
+		// including the conflict markers if there are any
+		Region text.Region
+		// The text of the part in the base, the View and on disk
+		Base, Local, Disk string
+	}
+
+	// A MergeResult is the result of a three-way merge.
+	MergeResult struct {
+		Text      string
+		Conflicts []MergeConflict
+	}
+
+	// A MergeFrontend is a Frontend which resolves the conflicts of a
+	// View with the file it was changed from on disk. Other frontends
+	// have conflicts inserted into the View with conflict markers.
+	MergeFrontend interface {
+		Frontend
+
+		// Called with the result of merging the View with disk, the
+		// conflicting parts of the Text being those of the View.
+		// Nothing of the merge has been applied to the View.
+		MergeConflicts(v *View, disk string, res MergeResult)
+	}
+)
+
+// Returns the index of the line of other matching each line of base, or -1,
+// according to the longest common subsequence of their lines.
+func matchLines(base, other []string) []int {
+	ret := make([]int, len(base))
+	for i := range ret {
+		ret[i] = -1
+	}
+	a := 0
+	for a < len(base) && a < len(other) && base[a] == other[a] {
+		ret[a] = a
+		a++
+	}
+	bb, bo := len(base), len(other)
+	for bb > a && bo > a && base[bb-1] == other[bo-1] {
+		bb--
+		bo--
+		ret[bb] = bo
+	}
+	s, c := base[a:bb], other[a:bo]
+	if len(s) > maxMergeLines || len(c) > maxMergeLines {
+		return ret
+	}
+	lcs := make([][]int, len(s)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(c)+1)
+	}
+	for i := len(s) - 1; i >= 0; i-- {
+		for j := len(c) - 1; j >= 0; j-- {
+			if s[i] == c[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	for i, j := 0, 0; i < len(s) && j < len(c); {
+		switch {
+		case s[i] == c[j]:
+			ret[a+i] = a + j
+			i++
+			j++
+		case lcs[i][j+1] >= lcs[i+1][j]:
+			j++
+		default:
+			i++
+		}
+	}
+	return ret
+}
+
+// Merge3 merges the changes from base to local and from base to disk,
+// line by line. Parts changed the same way on both sides, or on one side
+// only, are merged cleanly. Other parts conflict, and are merged as they
+// are in local, or with both versions between conflict markers if markers
+// is true.
+func Merge3(base, local, disk string, markers bool) (ret MergeResult) {
+	pe := util.Prof.Enter("Merge3")
+	defer pe.Exit()
+
+	bl, ll, dl := strings.SplitAfter(base, "\n"), strings.SplitAfter(local, "\n"), strings.SplitAfter(disk, "\n")
+	ml, md := matchLines(bl, ll), matchLines(bl, dl)
+	var (
+		buf  strings.Builder
+		size int
+		// Whether what's written so far ends a line
+		eol = true
+	)
+	write := func(s string) {
+		if s == "" {
+			return
+		}
+		buf.WriteString(s)
+		size += utf8.RuneCountInString(s)
+		eol = s[len(s)-1] == '\n'
+	}
+	// Writes a conflict marker on a line of its own
+	marker := func(m string) {
+		if !eol {
+			write("\n")
+		}
+		write(m + "\n")
+	}
+
+	i, l, d := 0, 0, 0
+	for i < len(bl) || l < len(ll) || d < len(dl) {
+		if i < len(bl) && ml[i] == l && md[i] == d {
+			write(bl[i])
+			i, l, d = i+1, l+1, d+1
+			continue
+		}
+		// The next line of base which is in both local and disk
+		k, kl, kd := i, len(ll), len(dl)
+		for ; k < len(bl); k++ {
+			if ml[k] >= l && md[k] >= d {
+				kl, kd = ml[k], md[k]
+				break
+			}
+		}
+		b, lo, di := strings.Join(bl[i:k], ""), strings.Join(ll[l:kl], ""), strings.Join(dl[d:kd], "")
+		switch {
+		case lo == b || lo == di:
+			write(di)
+		case di == b:
+			write(lo)
+		default:
+			c := MergeConflict{Base: b, Local: lo, Disk: di}
+			c.Region.A = size
+			if markers {
+				marker(MERGE_MARKER_LOCAL)
+				write(lo)
+				marker(MERGE_MARKER_SEP)
+				write(di)
+				marker(MERGE_MARKER_DISK)
+			} else {
+				write(lo)
+			}
+			c.Region.B = size
+			ret.Conflicts = append(ret.Conflicts, c)
+		}
+		i, l, d = k, kl, kd
+	}
+	ret.Text = buf.String()
+	return
+}
+
+// Replaces the content of the View with s in e, leaving alone
+// the common beginning and end of the two.
+func (v *View) replaceChanged(e *Edit, s string) {
+	cur := []rune(v.Substr(text.Region{A: 0, B: v.Size()}))
+	rs := []rune(s)
+	a := 0
+	for a < len(cur) && a < len(rs) && cur[a] == rs[a] {
+		a++
+	}
+	bc, bs := len(cur), len(rs)
+	for bc > a && bs > a && cur[bc-1] == rs[bs-1] {
+		bc--
+		bs--
+	}
+	if a == bc && a == bs {
+		return
+	}
+	v.Replace(e, text.Region{A: a, B: bc}, string(rs[a:bs]))
+}
+
+// Merges the changes made to the file of the View on disk, disk being its
+// new content, into the View as one Edit which can be undone. The base of
+// the merge is the content of the View when it was last loaded or saved.
+// Conflicts are inserted with conflict markers, unless the Frontend is a
+// MergeFrontend, in which case it's left to resolve them. Returns the
+// result of the merge.
+func (v *View) MergeDisk(disk string) MergeResult {
+	base := v.savedText()
+	local := v.Substr(text.Region{A: 0, B: v.Size()})
+
+	mf, _ := GetEditor().Frontend().(MergeFrontend)
+	res := Merge3(base, local, disk, mf == nil)
+	if len(res.Conflicts) != 0 && mf != nil {
+		mf.MergeConflicts(v, disk, res)
+		return res
+	}
+
+	// Read-only views follow their file too
+	e := v.beginReloadEdit()
+	v.replaceChanged(e, res.Text)
+	v.EndEdit(e)
+	// What's on disk is the base of the next merge
+	if res.Text == disk {
+		v.setSaved()
+	} else {
+		fi, _ := vfs.Stat(v.FileName())
+		v.lock.Lock()
+		v.saved, v.savedKept, v.savedFile = disk, true, fi
+		v.lineChanges = nil
+		v.lock.Unlock()
+	}
+	return res
+}
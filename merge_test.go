@@ -0,0 +1,122 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/limetext/text"
+)
+
+func TestMerge3(t *testing.T) {
+	tests := []struct {
+		base, local, disk string
+		exp               string
+		conflicts         []MergeConflict
+	}{
+		// Unchanged
+		{"a\nb\n", "a\nb\n", "a\nb\n", "a\nb\n", nil},
+		// Changed on one side only
+		{"a\nb\n", "a\nb\n", "a\nB\n", "a\nB\n", nil},
+		{"a\nb\n", "A\nb\n", "a\nb\n", "A\nb\n", nil},
+		// Changed on both sides, in different places
+		{"a\nb\nc\nd\n", "A\nb\nc\nd\n", "a\nb\nc\nD\n", "A\nb\nc\nD\n", nil},
+		{"a\nb\n", "x\na\nb\n", "a\nb\ny\n", "x\na\nb\ny\n", nil},
+		{"a\nb\nc\n", "a\nc\n", "a\nb\nc\nd\n", "a\nc\nd\n", nil},
+		// The same change on both sides
+		{"a\nb\n", "a\nB\n", "a\nB\n", "a\nB\n", nil},
+		// Conflicts
+		{
+			"a\nb\nc\n", "a\nL\nc\n", "a\nD\nc\n",
+			"a\n<<<<<<< buffer\nL\n=======\nD\n>>>>>>> disk\nc\n",
+			[]MergeConflict{{text.Region{A: 2, B: 42}, "b\n", "L\n", "D\n"}},
+		},
+		{
+			"a", "l", "d",
+			"<<<<<<< buffer\nl\n=======\nd\n>>>>>>> disk\n",
+			[]MergeConflict{{text.Region{A: 0, B: 40}, "a", "l", "d"}},
+		},
+	}
+	for i, test := range tests {
+		res := Merge3(test.base, test.local, test.disk, true)
+		if res.Text != test.exp {
+			t.Errorf("Test %d: Expected %q, but got %q", i, test.exp, res.Text)
+		}
+		if !reflect.DeepEqual(res.Conflicts, test.conflicts) {
+			t.Errorf("Test %d: Expected conflicts %+v, but got %+v", i, test.conflicts, res.Conflicts)
+		}
+	}
+
+	res := Merge3("a\nb\n", "a\nL\n", "a\nD\n", false)
+	if res.Text != "a\nL\n" || len(res.Conflicts) != 1 || res.Conflicts[0].Region != (text.Region{A: 2, B: 4}) {
+		t.Errorf("Expected the local version of the conflict without markers, but got %+v", res)
+	}
+}
+
+func TestViewFileChangedMerge(t *testing.T) {
+	dir, rm := tempDir(t)
+	defer rm()
+	fn := filepath.Join(dir, "merge.txt")
+	if err := ioutil.WriteFile(fn, []byte("a\nb\nc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ed := GetEditor()
+	defer setFrontend(&dummyFrontend{})()
+
+	w := ed.NewWindow()
+	defer w.Close()
+	v := w.OpenFile(fn, 0)
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+	// The changes are told to the view by the test rather than the watcher
+	ed.UnWatch(fn, v)
+
+	content := func() string {
+		return v.Substr(text.Region{A: 0, B: v.Size()})
+	}
+	change := func(s string) {
+		if err := ioutil.WriteFile(fn, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+		v.FileChanged(fn)
+	}
+
+	// A clean view just follows the file
+	change("a\nb\nc\nd\n")
+	if s := content(); s != "a\nb\nc\nd\n" || v.IsDirty() {
+		t.Errorf("Expected the clean view to be reloaded, but got %q, dirty: %v", s, v.IsDirty())
+	}
+
+	e := v.BeginEdit()
+	v.Insert(e, 0, "local\n")
+	v.EndEdit(e)
+	cc := v.ChangeCount()
+	change("a\nb\nc\ndisk\n")
+	if s := content(); s != "local\na\nb\nc\ndisk\n" || !v.IsDirty() {
+		t.Errorf("Expected the changes to be merged, but got %q, dirty: %v", s, v.IsDirty())
+	}
+	if v.ChangeCount() == cc {
+		t.Error("Expected the merge to change the buffer")
+	}
+	v.UndoStack().Undo(true)
+	if s := content(); s != "local\na\nb\nc\nd\n" {
+		t.Errorf("Expected the merge to be undone at once, but got %q", s)
+	}
+	v.UndoStack().Redo(true)
+
+	e = v.BeginEdit()
+	v.Replace(e, text.Region{A: 8, B: 9}, "B")
+	v.EndEdit(e)
+	change("a\nX\nc\ndisk\n")
+	if s, exp := content(), "local\na\n<<<<<<< buffer\nB\n=======\nX\n>>>>>>> disk\nc\ndisk\n"; s != exp {
+		t.Errorf("Expected conflict markers %q, but got %q", exp, s)
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"context"
+	"runtime"
+	"runtime/debug"
+	"sync"
+
+	"github.com/limetext/backend/log"
+)
+
+type (
+	// parsePool parses the buffers of all views with a bounded
+	// number of worker go-routines shared between the views.
+	//
+	// A view waiting to be parsed is queued at most once, and the views
+	// are picked by priority: the active view of the active window first,
+	// then the active views of other windows. Views hidden behind other
+	// views are only parsed when there's nothing more important to do.
+	// The priority of a view is determined when it's queued, and again
+	// when the active view or window changes, as the windows and their
+	// views are only to be looked at by the go-routine making the
+	// changes, not by the workers.
+	parsePool struct {
+		lock sync.Mutex
+		cond *sync.Cond
+		once sync.Once
+		// The number of worker go-routines
+		workers int
+		// Views waiting to be parsed in the order they were queued
+		queue []*View
+		// Whether the queued parse of a view was forced
+		forced map[*View]bool
+		// The priority of the queued views
+		priority map[*View]int
+		// Cancels the parse of the views currently being parsed
+		running map[*View]context.CancelFunc
+		// Views closed while they were being parsed, which
+		// are cleaned up once their parse has been abandoned
+		closed map[*View]bool
+	}
+)
+
+const (
+	parsePriorityActive = iota
+	parsePriorityVisible
+	parsePriorityHidden
+)
+
+var parsers = newParsePool(runtime.NumCPU())
+
+func newParsePool(workers int) *parsePool {
+	p := &parsePool{
+		workers:  workers,
+		forced:   make(map[*View]bool),
+		priority: make(map[*View]int),
+		running:  make(map[*View]context.CancelFunc),
+		closed:   make(map[*View]bool),
+	}
+	p.cond = sync.NewCond(&p.lock)
+	return p
+}
+
+// Queues a parse of v, cancelling the parse of v which is
+// in progress as it's going to be outdated anyway.
+func (p *parsePool) add(v *View, forced bool) {
+	p.once.Do(func() {
+		for i := 0; i < p.workers; i++ {
+			go p.work()
+		}
+	})
+
+	prio := v.parsePriority()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if cancel, ok := p.running[v]; ok {
+		cancel()
+	}
+	p.priority[v] = prio
+	if f, ok := p.forced[v]; ok {
+		p.forced[v] = f || forced
+		return
+	}
+	p.queue = append(p.queue, v)
+	p.forced[v] = forced
+	p.cond.Signal()
+}
+
+// Removes v from the pool, cancelling any parse of v in progress.
+// Returns false if v is still being parsed, in which case it'll
+// be cleaned up by the worker once the parse has been abandoned.
+func (p *parsePool) remove(v *View) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for i, v2 := range p.queue {
+		if v2 == v {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			break
+		}
+	}
+	delete(p.forced, v)
+	delete(p.priority, v)
+	if cancel, ok := p.running[v]; ok {
+		cancel()
+		p.closed[v] = true
+		return false
+	}
+	return true
+}
+
+// Recomputes the priorities of the queued views. Must be called
+// by the go-routine changing the windows and their views.
+func (p *parsePool) reprioritize() {
+	p.lock.Lock()
+	queue := append([]*View(nil), p.queue...)
+	p.lock.Unlock()
+	prios := make([]int, len(queue))
+	for i, v := range queue {
+		prios[i] = v.parsePriority()
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for i, v := range queue {
+		// Views dequeued since have their priority computed when queued again
+		if _, ok := p.priority[v]; ok {
+			p.priority[v] = prios[i]
+		}
+	}
+}
+
+// Returns and dequeues the queued view with the highest priority, or nil
+// if there's none which isn't already being parsed. Presumes p is locked.
+func (p *parsePool) next() *View {
+	idx, prio := -1, parsePriorityHidden+1
+	for i, v := range p.queue {
+		if _, ok := p.running[v]; ok {
+			continue
+		}
+		if vp := p.priority[v]; vp < prio {
+			idx, prio = i, vp
+		}
+	}
+	if idx == -1 {
+		return nil
+	}
+	v := p.queue[idx]
+	p.queue = append(p.queue[:idx], p.queue[idx+1:]...)
+	return v
+}
+
+func (p *parsePool) work() {
+	for {
+		p.lock.Lock()
+		v := p.next()
+		for v == nil {
+			p.cond.Wait()
+			v = p.next()
+		}
+		forced := p.forced[v]
+		delete(p.forced, v)
+		delete(p.priority, v)
+		ctx, cancel := context.WithCancel(context.Background())
+		p.running[v] = cancel
+		p.lock.Unlock()
+
+		p.parse(ctx, v, forced)
+		cancel()
+
+		p.lock.Lock()
+		delete(p.running, v)
+		closed := p.closed[v]
+		delete(p.closed, v)
+		// v might have been queued again while it was being parsed
+		p.cond.Broadcast()
+		p.lock.Unlock()
+
+		if closed {
+			v.cleanup()
+		}
+	}
+}
+
+// Parses v, recovering from any panic so that the worker
+// keeps on parsing the other views.
+func (p *parsePool) parse(ctx context.Context, v *View, forced bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("Panic parsing view %d: %v\n%s", v.Id(), r, string(debug.Stack()))
+		}
+	}()
+	v.parse(ctx, forced)
+}
+
+// Returns the priority of v's parse, lower is more important. Must
+// be called by the go-routine changing the windows and their views.
+func (v *View) parsePriority() int {
+	w := v.Window()
+	if w == nil || w.ActiveView() != v {
+		return parsePriorityHidden
+	}
+	if GetEditor().ActiveWindow() == w {
+		return parsePriorityActive
+	}
+	return parsePriorityVisible
+}
+
+func init() {
+	// Which views are visible changes with the active view and window
+	OnActivated.Add(func(*View) { parsers.reprioritize() })
+	OnWindowDeactivated.Add(func(*Window) { parsers.reprioritize() })
+}
@@ -0,0 +1,155 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/limetext/backend/parser"
+)
+
+// panicSyntax is a syntax whose parser always panics.
+type panicSyntax struct{}
+
+func (s *panicSyntax) Parser(data string) (parser.Parser, error) {
+	panic("can't parse")
+}
+
+func (s *panicSyntax) Name() string {
+	return "Panic"
+}
+
+func (s *panicSyntax) FileTypes() []string {
+	return nil
+}
+
+func TestParsePoolPriority(t *testing.T) {
+	ed := GetEditor()
+	w := ed.NewWindow()
+	defer w.Close()
+
+	hidden := w.NewFile()
+	active := w.NewFile()
+	defer func() {
+		for _, v := range []*View{hidden, active} {
+			v.SetScratch(true)
+			v.Close()
+		}
+	}()
+
+	p := newParsePool(1)
+	// Don't start any workers so the queue can be inspected
+	p.once.Do(func() {})
+
+	p.add(hidden, false)
+	p.add(active, false)
+	p.add(hidden, true)
+	if l := len(p.queue); l != 2 {
+		t.Fatalf("Expected 2 queued views, but got %d", l)
+	}
+	if !p.forced[hidden] {
+		t.Error("Expected the hidden view's parse to be forced")
+	}
+	if v := p.next(); v != active {
+		t.Errorf("Expected the active view to be parsed first, but got %s", v)
+	}
+	if v := p.next(); v != hidden {
+		t.Errorf("Expected the hidden view to be parsed second, but got %s", v)
+	}
+	if v := p.next(); v != nil {
+		t.Errorf("Expected no more views to parse, but got %s", v)
+	}
+}
+
+func TestParsePoolReprioritize(t *testing.T) {
+	ed := GetEditor()
+	w := ed.NewWindow()
+	defer w.Close()
+
+	hidden := w.NewFile()
+	active := w.NewFile()
+	defer func() {
+		for _, v := range []*View{hidden, active} {
+			v.SetScratch(true)
+			v.Close()
+		}
+	}()
+
+	p := newParsePool(1)
+	p.once.Do(func() {})
+
+	p.add(active, false)
+	p.add(hidden, false)
+	w.SetActiveView(hidden)
+	p.reprioritize()
+	if v := p.next(); v != hidden {
+		t.Errorf("Expected the view activated since it was queued to be parsed first, but got %s", v)
+	}
+}
+
+func TestParsePoolRunning(t *testing.T) {
+	w := GetEditor().NewWindow()
+	defer w.Close()
+
+	v := w.NewFile()
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+
+	p := newParsePool(1)
+	p.once.Do(func() {})
+
+	cancelled := false
+	p.running[v] = func() { cancelled = true }
+	p.add(v, false)
+	if !cancelled {
+		t.Error("Expected the parse in progress to be cancelled")
+	}
+	if got := p.next(); got != nil {
+		t.Errorf("Expected a view being parsed not to be picked, but got %s", got)
+	}
+	if p.remove(v) {
+		t.Error("Expected remove to return false for a view being parsed")
+	}
+	if !p.closed[v] || len(p.queue) != 0 {
+		t.Errorf("Expected the view to be marked closed and dequeued, but got %v %v", p.closed, p.queue)
+	}
+}
+
+func TestParsePanic(t *testing.T) {
+	const path = "testdata/Panic.syntax"
+	ed := GetEditor()
+	ed.AddSyntax(path, &panicSyntax{})
+	defer delete(ed.syntaxes, path)
+
+	w := ed.NewWindow()
+	defer w.Close()
+	v := w.NewFile()
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+	v.Settings().Set("syntax", path)
+
+	// Each edit panics again, which mustn't stop the workers
+	for i := 1; i <= 3; i++ {
+		e := v.BeginEdit()
+		v.Insert(e, 0, "a")
+		v.EndEdit(e)
+		for j := 0; ; j++ {
+			v.lock.Lock()
+			n := v.parsePanics
+			v.lock.Unlock()
+			if n >= i {
+				break
+			} else if j > 100 {
+				t.Fatalf("Expected %d panics, but got %d", i, n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
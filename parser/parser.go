@@ -17,6 +17,7 @@ package parser
 
 import (
 	"bytes"
+	"context"
 	"sort"
 	"sync"
 
@@ -32,6 +33,15 @@ type (
 		Parse() (*parser.Node, error)
 	}
 
+	// The ContextParser interface can be optionally implemented by a
+	// Parser which is able to abandon a parse midway once the context
+	// it's given is cancelled, e.g because the text being parsed has
+	// since been modified.
+	ContextParser interface {
+		Parser
+		ParseContext(ctx context.Context) (*parser.Node, error)
+	}
+
 	// The SyntaxHighlighter interface is responsible for
 	// identifying the extent and name of code scopes given
 	// a position in the code buffer this specific SyntaxHighlighter
@@ -71,6 +81,41 @@ type (
 		Flatten() render.ViewRegionMap
 	}
 
+	// The NodeHighlighter interface can be optionally implemented by a
+	// SyntaxHighlighter whose scopes come from a tree of parser.Nodes.
+	NodeHighlighter interface {
+		SyntaxHighlighter
+
+		// Returns the named nodes containing "point", outermost first.
+		// The last node is the innermost scope, i.e the node whose
+		// Range is returned by ScopeExtent. At the end of the data,
+		// which no node contains, only the root node is returned.
+		Nodes(point int) []*parser.Node
+	}
+
+	// An Embedding is a Region of the data which is in another language
+	// than the data surrounding it, e.g javascript in a html <script> tag,
+	// and the Parser responsible for the data in that Region.
+	//
+	// The embedded Parser parses only the data of the Region, so the Ranges
+	// of the nodes it returns are relative to the start of the Region.
+	Embedding struct {
+		Region text.Region
+		Parser Parser
+	}
+
+	embeddingParser struct {
+		host   Parser
+		embeds []Embedding
+	}
+
+	// offsetSource translates the Ranges of an embedded node
+	// back to the DataSource it was parsed from.
+	offsetSource struct {
+		parser.DataSource
+		offset int
+	}
+
 	nodeHighlighter struct {
 		rootNode      *parser.Node
 		lastScopeNode *parser.Node
@@ -83,13 +128,39 @@ type (
 // Creates a new default implementation of SyntaxHighlighter operating
 // on the AST created by  "p"'s Parse().
 func NewSyntaxHighlighter(p Parser) (SyntaxHighlighter, error) {
-	if rn, err := p.Parse(); err != nil {
+	return NewSyntaxHighlighterContext(context.Background(), p)
+}
+
+// Same as NewSyntaxHighlighter, but the parse is abandoned
+// with ctx's error when ctx is cancelled.
+func NewSyntaxHighlighterContext(ctx context.Context, p Parser) (SyntaxHighlighter, error) {
+	if rn, err := ParseContext(ctx, p); err != nil {
 		return nil, err
 	} else {
 		return &nodeHighlighter{rootNode: rn}, nil
 	}
 }
 
+// ParseContext parses with p, stopping the parse when ctx is cancelled
+// if p is a ContextParser. Other Parsers can't be interrupted, but their
+// result is discarded if ctx was cancelled while they were parsing.
+func ParseContext(ctx context.Context, p Parser) (*parser.Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if cp, ok := p.(ContextParser); ok {
+		return cp.ParseContext(ctx)
+	}
+	rn, err := p.Parse()
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rn, nil
+}
+
 // Given a text region, returns the innermost node covering that region.
 // Side-effects: Writes to nh.lastScopeBuf...
 func (nh *nodeHighlighter) findScope(search text.Region, node *parser.Node) *parser.Node {
@@ -159,6 +230,32 @@ func (nh *nodeHighlighter) ScopeName(point int) string {
 	return nh.lastScopeName
 }
 
+func (nh *nodeHighlighter) Nodes(point int) (ret []*parser.Node) {
+	if rn := nh.rootNode; rn != nil && point == rn.Range.B && point > rn.Range.A {
+		// Nothing follows the end of the data, which is
+		// therefore only in the outermost scope
+		if rn.Name != "" {
+			ret = append(ret, rn)
+		}
+		return
+	}
+	search := text.Region{A: point, B: point + 1}
+	for n := nh.rootNode; n != nil && n.Range.Covers(search); {
+		if n.Name != "" {
+			ret = append(ret, n)
+		}
+		var next *parser.Node
+		for _, c := range n.Children {
+			if c.Range.Covers(search) {
+				next = c
+				break
+			}
+		}
+		n = next
+	}
+	return
+}
+
 func (nh *nodeHighlighter) flatten(vrmap render.ViewRegionMap, scopename string, node *parser.Node) {
 	scopename += " " + node.Name
 	cur := node.Range
@@ -193,3 +290,101 @@ func (nh *nodeHighlighter) Flatten() (ret render.ViewRegionMap) {
 	nh.flatten(ret, "lime.syntax", nh.rootNode)
 	return
 }
+
+// NewEmbeddingParser returns a Parser which parses the data with host,
+// and merges the trees created by the embedded Parsers into the host's tree.
+//
+// Each embedded tree is placed under the innermost host node covering its
+// Region, replacing the host nodes inside of that Region. The scope names of
+// the host and the embedded language are therefore combined, e.g a string
+// inside a script tag will be "text.html.basic source.js string.quoted".
+//
+// An embedded Parser failing to parse leaves the host's nodes in its Region as they are.
+func NewEmbeddingParser(host Parser, embeds []Embedding) Parser {
+	return &embeddingParser{host: host, embeds: embeds}
+}
+
+func (p *embeddingParser) Parse() (*parser.Node, error) {
+	return p.ParseContext(context.Background())
+}
+
+func (p *embeddingParser) ParseContext(ctx context.Context) (*parser.Node, error) {
+	root, err := ParseContext(ctx, p.host)
+	if err != nil || root == nil {
+		return root, err
+	}
+	for _, e := range p.embeds {
+		child, err := ParseContext(ctx, e.Parser)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		} else if err != nil || child == nil {
+			continue
+		}
+		offset := e.Region.Begin()
+		setOffset(child, offset)
+		child.Adjust(0, offset)
+		embed(root, child)
+	}
+	return root, nil
+}
+
+func (s offsetSource) Data(start, end int) string {
+	return s.DataSource.Data(start-s.offset, end-s.offset)
+}
+
+func setOffset(n *parser.Node, offset int) {
+	if n.P != nil {
+		n.P = offsetSource{n.P, offset}
+	}
+	for _, c := range n.Children {
+		setOffset(c, offset)
+	}
+}
+
+// Inserts child under the innermost node of the tree
+// rooted at n which covers child's Range.
+func embed(n, child *parser.Node) {
+	for _, c := range n.Children {
+		if c.Range.Covers(child.Range) {
+			embed(c, child)
+			return
+		}
+	}
+	r := child.Range
+	children := make([]*parser.Node, 0, len(n.Children)+1)
+	for _, c := range n.Children {
+		if cut(c, r) {
+			children = append(children, c)
+		}
+	}
+	i := sort.Search(len(children), func(i int) bool {
+		return children[i].Range.Begin() >= r.End()
+	})
+	children = append(children, nil)
+	copy(children[i+1:], children[i:])
+	children[i] = child
+	n.Children = children
+}
+
+// Removes the part of n, and of its children, which is inside r.
+// Returns false if n is entirely inside of r.
+func cut(n *parser.Node, r text.Region) bool {
+	if n.Range.End() <= r.Begin() || n.Range.Begin() >= r.End() {
+		return true
+	} else if r.Covers(n.Range) {
+		return false
+	}
+	if n.Range.Begin() < r.Begin() {
+		n.Range = text.Region{A: n.Range.Begin(), B: r.Begin()}
+	} else {
+		n.Range = text.Region{A: r.End(), B: n.Range.End()}
+	}
+	children := n.Children[:0]
+	for _, c := range n.Children {
+		if cut(c, r) {
+			children = append(children, c)
+		}
+	}
+	n.Children = children
+	return true
+}
@@ -3,3 +3,104 @@
 // BSD-style license that can be found in the LICENSE file.
 
 package parser
+
+import (
+	"context"
+	"testing"
+
+	"github.com/limetext/text"
+	"github.com/quarnster/parser"
+)
+
+type dummyParser struct {
+	root *parser.Node
+}
+
+func (p *dummyParser) Parse() (*parser.Node, error) {
+	return p.root, nil
+}
+
+func TestEmbeddingParser(t *testing.T) {
+	// <p>x<script>f("s")</script>
+	host := &parser.Node{Name: "text.html", Range: text.Region{A: 0, B: 28}, Children: []*parser.Node{
+		{Name: "meta.tag", Range: text.Region{A: 0, B: 3}},
+		{Name: "source.js.embedded", Range: text.Region{A: 4, B: 28}, Children: []*parser.Node{
+			{Name: "meta.tag", Range: text.Region{A: 4, B: 12}},
+			{Name: "invalid.illegal", Range: text.Region{A: 15, B: 19}},
+			{Name: "meta.tag", Range: text.Region{A: 19, B: 28}},
+		}},
+	}}
+	js := &parser.Node{Name: "source.js", Range: text.Region{A: 0, B: 6}, Children: []*parser.Node{
+		{Name: "string.quoted", Range: text.Region{A: 2, B: 5}},
+	}}
+	p := NewEmbeddingParser(&dummyParser{host}, []Embedding{
+		{Region: text.Region{A: 12, B: 18}, Parser: &dummyParser{js}},
+	})
+	sh, err := NewSyntaxHighlighter(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		point int
+		exp   string
+	}{
+		{1, "text.html meta.tag"},
+		{8, "text.html source.js.embedded meta.tag"},
+		{12, "text.html source.js.embedded source.js"},
+		{15, "text.html source.js.embedded source.js string.quoted"},
+		{18, "text.html source.js.embedded invalid.illegal"},
+		{20, "text.html source.js.embedded meta.tag"},
+	}
+	for i, test := range tests {
+		if got := sh.ScopeName(test.point); got != test.exp {
+			t.Errorf("Test %d: Expected scope name %q at %d, but got %q", i, test.exp, test.point, got)
+		}
+	}
+	if got, exp := sh.ScopeExtent(15), (text.Region{A: 14, B: 17}); got != exp {
+		t.Errorf("Expected scope extent %s, but got %s", exp, got)
+	}
+}
+
+// cancelParser cancels the parse it's part of once it's parsed.
+type cancelParser struct {
+	dummyParser
+	cancel context.CancelFunc
+	parsed bool
+}
+
+func (p *cancelParser) Parse() (*parser.Node, error) {
+	p.parsed = true
+	if p.cancel != nil {
+		p.cancel()
+	}
+	return p.root, nil
+}
+
+func TestParseContextCancelled(t *testing.T) {
+	root := &parser.Node{Name: "text.plain", Range: text.Region{A: 0, B: 1}}
+	p := NewEmbeddingParser(&dummyParser{root}, nil)
+	if n, err := ParseContext(context.Background(), p); err != nil || n != root {
+		t.Errorf("Expected the parse to succeed, but got %v %v", n, err)
+	}
+
+	// The host cancels the parse, so the embedded parser isn't parsed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	host := &cancelParser{dummyParser: dummyParser{root}, cancel: cancel}
+	embedded := &cancelParser{dummyParser: dummyParser{root}}
+	p = NewEmbeddingParser(host, []Embedding{{Region: text.Region{A: 0, B: 1}, Parser: embedded}})
+	if n, err := ParseContext(ctx, p); err != context.Canceled || n != nil {
+		t.Errorf("Expected %v, but got %v %v", context.Canceled, n, err)
+	}
+	if !host.parsed || embedded.parsed {
+		t.Errorf("Expected the parse to stop after the host, but got %v and %v", host.parsed, embedded.parsed)
+	}
+
+	// Parsers which can't be interrupted have their result discarded
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := NewSyntaxHighlighterContext(ctx, &cancelParser{dummyParser: dummyParser{root}, cancel: cancel}); err != context.Canceled {
+		t.Errorf("Expected %v, but got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/limetext/backend/log"
+	"github.com/limetext/text"
+)
+
+// The default of the "format_timeout" setting, in milliseconds
+const defaultFormatTimeout = 5000
+
+type (
+	// The TrimTrailingWhiteSpaceCommand erases the spaces
+	// and tabs at the end of every line of the view.
+	TrimTrailingWhiteSpaceCommand struct {
+		DefaultCommand
+	}
+
+	// The EnsureNewlineAtEofCommand adds a newline to the end
+	// of the view, unless it's empty or ends with one already.
+	EnsureNewlineAtEofCommand struct {
+		DefaultCommand
+	}
+
+	// The ConvertIndentationCommand converts the indentation of
+	// every line of the view to spaces if "translate_tabs_to_spaces"
+	// is true, and to tabs otherwise, keeping its width.
+	ConvertIndentationCommand struct {
+		DefaultCommand
+	}
+
+	// The FormatBufferCommand replaces the content of the view with
+	// what an external formatter outputs when fed it on its stdin.
+	// Cmd is the formatter's name and arguments, and defaults to the
+	// "format_command" setting. The view is left alone if the formatter
+	// fails, or takes longer than "format_timeout" milliseconds.
+	FormatBufferCommand struct {
+		DefaultCommand
+		Cmd []string
+	}
+
+	// A command of the "pre_save_commands" setting
+	preSaveCommand struct {
+		name string
+		args Args
+	}
+)
+
+func (c *TrimTrailingWhiteSpaceCommand) Run(v *View, e *Edit) error {
+	lines := v.Lines(text.Region{A: 0, B: v.Size()})
+	// Erasing from the end keeps the regions of the other lines valid
+	for i := len(lines) - 1; i >= 0; i-- {
+		l := v.SubstrR(lines[i])
+		j := len(l)
+		for j > 0 && (l[j-1] == ' ' || l[j-1] == '\t') {
+			j--
+		}
+		if j != len(l) {
+			v.Erase(e, text.Region{A: lines[i].A + j, B: lines[i].B})
+		}
+	}
+	return nil
+}
+
+func (c *EnsureNewlineAtEofCommand) Run(v *View, e *Edit) error {
+	if s := v.Size(); s != 0 && v.Substr(text.Region{A: s - 1, B: s}) != "\n" {
+		v.Insert(e, s, "\n")
+	}
+	return nil
+}
+
+// Returns the indentation of the given width, in
+// spaces or in tabs and the spaces left over.
+func indentation(width, tabSize int, spaces bool) string {
+	if spaces {
+		return strings.Repeat(" ", width)
+	}
+	return strings.Repeat("\t", width/tabSize) + strings.Repeat(" ", width%tabSize)
+}
+
+func (c *ConvertIndentationCommand) Run(v *View, e *Edit) error {
+	spaces := v.Settings().Bool("translate_tabs_to_spaces", false)
+	tabSize := v.Settings().Int("tab_size", 4)
+	if tabSize <= 0 {
+		tabSize = 4
+	}
+	lines := v.Lines(text.Region{A: 0, B: v.Size()})
+	for i := len(lines) - 1; i >= 0; i-- {
+		l := v.SubstrR(lines[i])
+		j, width := 0, 0
+		for ; j < len(l) && (l[j] == ' ' || l[j] == '\t'); j++ {
+			if l[j] == '\t' {
+				width += tabSize - width%tabSize
+			} else {
+				width++
+			}
+		}
+		if ind := indentation(width, tabSize, spaces); ind != string(l[:j]) {
+			v.Replace(e, text.Region{A: lines[i].A, B: lines[i].A + j}, ind)
+		}
+	}
+	return nil
+}
+
+func (c *FormatBufferCommand) Init(args Args) error {
+	c.Cmd = nil
+	cmd, ok := args["cmd"].([]interface{})
+	if !ok {
+		return nil
+	}
+	for _, a := range cmd {
+		s, ok := a.(string)
+		if !ok {
+			return fmt.Errorf("the formatter's arguments must be strings, not %v", a)
+		}
+		c.Cmd = append(c.Cmd, s)
+	}
+	return nil
+}
+
+// Returns the formatter's command, which can be
+// given as a list of strings, or a single string.
+func formatCommand(v *View) []string {
+	switch cmd := v.Settings().Get("format_command").(type) {
+	case string:
+		if cmd != "" {
+			return []string{cmd}
+		}
+	case []string:
+		return cmd
+	case []interface{}:
+		var ret []string
+		for _, a := range cmd {
+			if s, ok := a.(string); ok {
+				ret = append(ret, s)
+			}
+		}
+		return ret
+	}
+	return nil
+}
+
+func (c *FormatBufferCommand) Run(v *View, e *Edit) error {
+	cmd := c.Cmd
+	if len(cmd) == 0 {
+		cmd = formatCommand(v)
+	}
+	if len(cmd) == 0 {
+		return fmt.Errorf("no formatter to format %s with", v)
+	}
+	timeout := v.Settings().Int("format_timeout", defaultFormatTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	p := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	p.Stdin = strings.NewReader(v.Substr(text.Region{A: 0, B: v.Size()}))
+	p.Stdout, p.Stderr = &stdout, &stderr
+	if err := p.Run(); ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s took longer than %d milliseconds", cmd[0], timeout)
+	} else if err != nil {
+		return fmt.Errorf("%s failed: %s %s", cmd[0], err, strings.TrimSpace(stderr.String()))
+	}
+	v.replaceChanged(e, NormalizeLineEndings(stdout.String()))
+	return nil
+}
+
+// Returns the commands of the "pre_save_commands" setting, which is
+// a list of names of text commands, or of lists of a name and args.
+func preSaveCommands(v *View) (ret []preSaveCommand) {
+	cmds, _ := v.Settings().Get("pre_save_commands").([]interface{})
+	for _, c := range cmds {
+		switch c := c.(type) {
+		case string:
+			ret = append(ret, preSaveCommand{name: c})
+		case []interface{}:
+			if len(c) == 0 {
+				break
+			}
+			name, _ := c[0].(string)
+			var args Args
+			if len(c) > 1 {
+				if m, ok := c[1].(map[string]interface{}); ok {
+					args = Args(m)
+				}
+			}
+			ret = append(ret, preSaveCommand{name: name, args: args})
+		default:
+			log.Warn("Invalid pre save command: %v", c)
+		}
+	}
+	return
+}
+
+// Runs the pre save pipeline of v, which is made of the text commands
+// turned on by the "trim_trailing_white_space_on_save",
+// "convert_indentation_on_save", "ensure_newline_at_eof_on_save" and
+// "format_on_save" settings, in that order, followed by those of the
+// "pre_save_commands" setting. They're all run inside one Edit, so
+// that they're undone at once. The changes of commands which fail are
+// undone, and the other commands still run. Large files aren't
+// transformed.
+func preSave(v *View) {
+	if v.IsLargeFile() || v.IsReadOnly() {
+		return
+	}
+	var cmds []preSaveCommand
+	for _, c := range []struct {
+		setting string
+		cmd     string
+	}{
+		{"trim_trailing_white_space_on_save", "trim_trailing_white_space"},
+		{"convert_indentation_on_save", "convert_indentation"},
+		{"ensure_newline_at_eof_on_save", "ensure_newline_at_eof"},
+		{"format_on_save", "format_buffer"},
+	} {
+		if v.Settings().Bool(c.setting, false) {
+			cmds = append(cmds, preSaveCommand{name: c.cmd})
+		}
+	}
+	cmds = append(cmds, preSaveCommands(v)...)
+	if len(cmds) == 0 {
+		return
+	}
+
+	ch := GetEditor().CommandHandler()
+	e := v.BeginEdit()
+	e.command = "pre_save"
+	for _, c := range cmds {
+		// Each command has an Edit of its own inside of e,
+		// so that the changes of a failed command are undone
+		ce := v.BeginEdit()
+		if err := ch.RunTextCommand(v, c.name, c.args); err != nil {
+			ce.Undo()
+			ce.bypassUndo = true
+			log.Warn("Pre save command %s failed on %s: %s", c.name, v, err)
+			if fe := GetEditor().Frontend(); fe != nil {
+				fe.StatusMessage(fmt.Sprintf("Couldn't run %s before saving: %s", c.name, err))
+			}
+		}
+		v.EndEdit(ce)
+	}
+	v.EndEdit(e)
+}
+
+func init() {
+	ch := GetEditor().CommandHandler()
+	for _, c := range []Command{
+		&TrimTrailingWhiteSpaceCommand{},
+		&EnsureNewlineAtEofCommand{},
+		&ConvertIndentationCommand{},
+		&FormatBufferCommand{},
+	} {
+		if err := ch.RegisterWithDefault(c); err != nil {
+			log.Error("Failed to register command: %s", err)
+		}
+	}
+	OnPreSave.Add(preSave)
+}
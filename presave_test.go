@@ -0,0 +1,182 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"errors"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/limetext/text"
+)
+
+func TestPreSaveCommands(t *testing.T) {
+	tests := []struct {
+		cmd      string
+		args     Args
+		settings map[string]interface{}
+		in, exp  string
+	}{
+		{"trim_trailing_white_space", nil, nil, "a \nb\t \n  \nc", "a\nb\n\nc"},
+		{"trim_trailing_white_space", nil, nil, "  a\n", "  a\n"},
+		{"ensure_newline_at_eof", nil, nil, "a", "a\n"},
+		{"ensure_newline_at_eof", nil, nil, "a\n", "a\n"},
+		{"ensure_newline_at_eof", nil, nil, "", ""},
+		{
+			"convert_indentation", nil,
+			map[string]interface{}{"translate_tabs_to_spaces": true, "tab_size": 4},
+			"\ta\n  \tb\n\t c\nd\t\n", "    a\n    b\n     c\nd\t\n",
+		},
+		{
+			"convert_indentation", nil,
+			map[string]interface{}{"translate_tabs_to_spaces": false, "tab_size": 2},
+			"    a\n   b\n\t\tc\n", "\t\ta\n\t b\n\t\tc\n",
+		},
+	}
+
+	w := GetEditor().NewWindow()
+	defer w.Close()
+	ch := GetEditor().CommandHandler()
+	for i, test := range tests {
+		v := w.NewFile()
+		e := v.BeginEdit()
+		v.Insert(e, 0, test.in)
+		v.EndEdit(e)
+		for k, s := range test.settings {
+			v.Settings().Set(k, s)
+		}
+		if err := ch.RunTextCommand(v, test.cmd, test.args); err != nil {
+			t.Errorf("Test %d: Error running %s: %s", i, test.cmd, err)
+		}
+		if s := v.Substr(text.Region{A: 0, B: v.Size()}); s != test.exp {
+			t.Errorf("Test %d: Expected %q, but got %q", i, test.exp, s)
+		}
+		v.SetScratch(true)
+		v.Close()
+	}
+}
+
+func TestFormatBuffer(t *testing.T) {
+	if _, err := exec.LookPath("tr"); err != nil {
+		t.Skip("There's no tr to format with")
+	}
+	w := GetEditor().NewWindow()
+	defer w.Close()
+	v := w.NewFile()
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+	e := v.BeginEdit()
+	v.Insert(e, 0, "abc\n")
+	v.EndEdit(e)
+
+	ch := GetEditor().CommandHandler()
+	tests := []struct {
+		cmd []interface{}
+		err bool
+		exp string
+	}{
+		{[]interface{}{"tr", "b", "B"}, false, "aBc\n"},
+		{[]interface{}{"false"}, true, "aBc\n"},
+		{[]interface{}{"lime-missing-formatter"}, true, "aBc\n"},
+		{[]interface{}{"sleep", "1"}, true, "aBc\n"},
+	}
+	v.Settings().Set("format_timeout", 100)
+	for i, test := range tests {
+		err := ch.RunTextCommand(v, "format_buffer", Args{"cmd": test.cmd})
+		if (err != nil) != test.err {
+			t.Errorf("Test %d: Expected error %v, but got %v", i, test.err, err)
+		}
+		if s := v.Substr(text.Region{A: 0, B: v.Size()}); s != test.exp {
+			t.Errorf("Test %d: Expected %q, but got %q", i, test.exp, s)
+		}
+	}
+}
+
+// Changes the buffer before failing
+type partialCommand struct {
+	DefaultCommand
+}
+
+func (c *partialCommand) Run(v *View, e *Edit) error {
+	v.Insert(e, 0, "partial ")
+	return errors.New("failed midway")
+}
+
+func TestPreSave(t *testing.T) {
+	if _, err := exec.LookPath("tr"); err != nil {
+		t.Skip("There's no tr to format with")
+	}
+	dir, rm := tempDir(t)
+	defer rm()
+	fn := filepath.Join(dir, "presave.txt")
+	if err := ioutil.WriteFile(fn, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := GetEditor().NewWindow()
+	defer w.Close()
+	v := w.OpenFile(fn, 0)
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+	e := v.BeginEdit()
+	v.Insert(e, 0, "a  \n\tb")
+	v.EndEdit(e)
+
+	v.Settings().Set("trim_trailing_white_space_on_save", true)
+	v.Settings().Set("ensure_newline_at_eof_on_save", true)
+	v.Settings().Set("convert_indentation_on_save", true)
+	v.Settings().Set("translate_tabs_to_spaces", true)
+	v.Settings().Set("tab_size", 2)
+	v.Settings().Set("format_on_save", true)
+	v.Settings().Set("format_command", []interface{}{"tr", "a", "A"})
+	_ = GetEditor().CommandHandler().Register("test_partial", &partialCommand{})
+	v.Settings().Set("pre_save_commands", []interface{}{
+		[]interface{}{"format_buffer", map[string]interface{}{"cmd": []interface{}{"false"}}},
+		"test_partial",
+	})
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+	exp := "A\n  b\n"
+	if s := v.Substr(text.Region{A: 0, B: v.Size()}); s != exp {
+		t.Errorf("Expected %q, but got %q", exp, s)
+	}
+	if d, _ := ioutil.ReadFile(fn); string(d) != exp {
+		t.Errorf("Expected %q to be saved, but got %q", exp, d)
+	}
+
+	v.UndoStack().Undo(true)
+	if s := v.Substr(text.Region{A: 0, B: v.Size()}); s != "a  \n\tb" {
+		t.Errorf("Expected one undo to revert the pre save changes, but got %q", s)
+	}
+}
+
+func TestPreSaveFailedSave(t *testing.T) {
+	w := GetEditor().NewWindow()
+	defer w.Close()
+	v := w.NewFile()
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+	e := v.BeginEdit()
+	v.Insert(e, 0, "a  ")
+	v.EndEdit(e)
+	v.Settings().Set("trim_trailing_white_space_on_save", true)
+
+	// bzip2 files can't be written, so the pre save commands aren't run
+	if err := v.SaveAs("mem://presave.txt.bz2"); err == nil {
+		t.Fatal("Expected an error saving a bzip2 file")
+	}
+	if s := v.Substr(text.Region{A: 0, B: v.Size()}); s != "a  " {
+		t.Errorf("Expected the view to be left alone, but got %q", s)
+	}
+}
@@ -8,11 +8,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"path/filepath"
 	"strings"
 
 	"github.com/limetext/backend/log"
+	"github.com/limetext/backend/vfs"
 	"github.com/limetext/text"
 )
 
@@ -54,14 +53,10 @@ func (p *Project) SaveAs(name string) error {
 	log.Fine("Saving project as %s", name)
 	if data, err := json.Marshal(p); err != nil {
 		return err
-	} else if err := ioutil.WriteFile(name, data, 0644); err != nil {
+	} else if err := vfs.WriteFile(name, data); err != nil {
 		return err
 	}
-	if abs, err := filepath.Abs(name); err != nil {
-		p.SetName(name)
-	} else {
-		p.SetName(abs)
-	}
+	p.SetName(vfs.Abs(name))
 	return nil
 }
 
@@ -173,7 +168,7 @@ func (p *Project) MarshalJSON() ([]byte, error) {
 }
 
 func (p *Project) Load(name string) error {
-	if data, err := ioutil.ReadFile(name); err != nil {
+	if data, err := vfs.ReadFile(name); err != nil {
 		return fmt.Errorf("Couldn't read file %s: %s", name, err)
 	} else if err := json.Unmarshal(data, p); err != nil {
 		return fmt.Errorf("Couldn't unmarshal project data\n%s\n%s", data, err)
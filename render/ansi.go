@@ -0,0 +1,144 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/limetext/text"
+	"github.com/limetext/util"
+)
+
+const (
+	ANSI_TRUE_COLOUR ANSIColours = iota // 24-bit colour escape sequences
+	ANSI_256_COLOUR                     // xterm 256 colour escape sequences
+)
+
+// The kind of colour escape sequences written by the ANSIRenderer.
+type ANSIColours int
+
+// The ANSIRenderer renders a Recipe of the whole Buffer as text
+// with ANSI escape sequences, for showing it in a terminal.
+//
+// Only the RenderUnits with the DRAW_TEXT flag, i.e the ones of the
+// syntax highlighting, are used for styling the text. Colours are only
+// set for text whose colours differ from the colour scheme's global
+// settings, which leaves the rest in the terminal's own colours.
+type ANSIRenderer struct {
+	W       io.Writer
+	Buffer  text.Buffer
+	Scheme  ColourScheme
+	Colours ANSIColours
+
+	err error
+}
+
+// The levels of the colour cube of the xterm 256 colours
+var cubeLevels = [...]int{0, 0x5f, 0x87, 0xaf, 0xd7, 0xff}
+
+// Returns the first error which occurred writing to W.
+func (r *ANSIRenderer) Err() error {
+	return r.err
+}
+
+func (r *ANSIRenderer) write(s string) {
+	if r.err == nil {
+		_, r.err = io.WriteString(r.W, s)
+	}
+}
+
+func sq(a int) int {
+	return a * a
+}
+
+// Returns the xterm 256 colour closest to c.
+func colour256(c Colour) int {
+	closest := func(v uint8) int {
+		i := 0
+		for j, l := range cubeLevels {
+			if sq(int(v)-l) < sq(int(v)-cubeLevels[i]) {
+				i = j
+			}
+		}
+		return i
+	}
+	r, g, b := closest(c.R), closest(c.G), closest(c.B)
+	cube := 16 + 36*r + 6*g + b
+	cubeDist := sq(int(c.R)-cubeLevels[r]) + sq(int(c.G)-cubeLevels[g]) + sq(int(c.B)-cubeLevels[b])
+
+	// The greyscale ramp goes from 8 to 238 in steps of 10
+	avg := (int(c.R) + int(c.G) + int(c.B)) / 3
+	grey := (avg - 3) / 10
+	if grey < 0 {
+		grey = 0
+	} else if grey > 23 {
+		grey = 23
+	}
+	l := 8 + 10*grey
+	if greyDist := sq(int(c.R)-l) + sq(int(c.G)-l) + sq(int(c.B)-l); greyDist < cubeDist {
+		return 232 + grey
+	}
+	return cube
+}
+
+// Returns the escape sequence parameter setting the colour c,
+// where layer is 38 for the foreground and 48 for the background.
+func (r *ANSIRenderer) colour(layer int, c Colour) string {
+	if r.Colours == ANSI_256_COLOUR {
+		return fmt.Sprintf("%d;5;%d", layer, colour256(c))
+	}
+	return fmt.Sprintf("%d;2;%d;%d;%d", layer, c.R, c.G, c.B)
+}
+
+// Returns the escape sequence styling text rendered with f.
+func (r *ANSIRenderer) style(f Flavour, gs Settings) string {
+	var s []string
+	if f.Font.Style&Bold != 0 {
+		s = append(s, "1")
+	}
+	if f.Font.Style&Italic != 0 {
+		s = append(s, "3")
+	}
+	if f.Font.Style&Underline != 0 {
+		s = append(s, "4")
+	}
+	if f.Foreground != gs.Foreground && f.Foreground.A != 0 {
+		s = append(s, r.colour(38, f.Foreground))
+	}
+	if f.Background != gs.Background && f.Background.A != 0 {
+		s = append(s, r.colour(48, f.Background))
+	}
+	if len(s) == 0 {
+		return ""
+	}
+	return "\x1b[" + strings.Join(s, ";") + "m"
+}
+
+func (r *ANSIRenderer) Render(recipe Recipe) {
+	pe := util.Prof.Enter("render.ANSIRenderer.Render")
+	defer pe.Exit()
+
+	gs := r.Scheme.GlobalSettings()
+	for _, ru := range textUnits(recipe, r.Buffer.Size()) {
+		style := r.style(ru.Flavour, gs)
+		// The style is reset at the end of each line so
+		// that the background doesn't fill the rest of it
+		for i, s := range strings.Split(r.Buffer.Substr(ru.Region), "\n") {
+			if i > 0 {
+				r.write("\n")
+			}
+			if s == "" {
+				continue
+			}
+			if style == "" {
+				r.write(s)
+			} else {
+				r.write(style + s + "\x1b[0m")
+			}
+		}
+	}
+}
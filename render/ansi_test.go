@@ -0,0 +1,67 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/limetext/text"
+)
+
+func TestColour256(t *testing.T) {
+	tests := []struct {
+		c   Colour
+		exp int
+	}{
+		{Colour{0, 0, 0, 0xff}, 16},
+		{Colour{0xff, 0xff, 0xff, 0xff}, 231},
+		{Colour{0xff, 0, 0, 0xff}, 196},
+		{Colour{0x5f, 0x87, 0xaf, 0xff}, 67},
+		{Colour{0x80, 0x80, 0x80, 0xff}, 244},
+		{Colour{0x12, 0x12, 0x12, 0xff}, 233},
+	}
+	for i, test := range tests {
+		if got := colour256(test.c); got != test.exp {
+			t.Errorf("Test %d: Expected %v to be %d, but got %d", i, test.c, test.exp, got)
+		}
+	}
+}
+
+func TestANSIRenderer(t *testing.T) {
+	buf := text.NewBuffer()
+	buf.Insert(0, "if a\nb")
+
+	vrm := make(ViewRegionMap)
+	vr := ViewRegions{Scope: "keyword", Flags: DRAW_TEXT}
+	vr.Regions.Add(text.Region{A: 0, B: 2})
+	vrm["kw"] = vr
+	recipe := TransformFlags(htmlColourScheme{}, vrm, text.Region{A: 0, B: buf.Size()})
+
+	tests := []struct {
+		colours ANSIColours
+		exp     string
+	}{
+		{
+			ANSI_TRUE_COLOUR,
+			"\x1b[1;38;2;255;0;0mif\x1b[0m a\nb",
+		},
+		{
+			ANSI_256_COLOUR,
+			"\x1b[1;38;5;196mif\x1b[0m a\nb",
+		},
+	}
+	for i, test := range tests {
+		var out bytes.Buffer
+		r := &ANSIRenderer{W: &out, Buffer: buf, Scheme: htmlColourScheme{}, Colours: test.colours}
+		r.Render(recipe)
+		if err := r.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if got := out.String(); got != test.exp {
+			t.Errorf("Test %d: Expected %q, but got %q", i, test.exp, got)
+		}
+	}
+}
@@ -0,0 +1,499 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The maximum depth of variables referring to other variables,
+// guarding against variables referring to themselves
+const maxColourVarDepth = 16
+
+// The CSS named colours
+var namedColours = map[string]Colour{
+	"transparent":          {0, 0, 0, 0},
+	"aliceblue":            {240, 248, 255, 255},
+	"antiquewhite":         {250, 235, 215, 255},
+	"aqua":                 {0, 255, 255, 255},
+	"aquamarine":           {127, 255, 212, 255},
+	"azure":                {240, 255, 255, 255},
+	"beige":                {245, 245, 220, 255},
+	"bisque":               {255, 228, 196, 255},
+	"black":                {0, 0, 0, 255},
+	"blanchedalmond":       {255, 235, 205, 255},
+	"blue":                 {0, 0, 255, 255},
+	"blueviolet":           {138, 43, 226, 255},
+	"brown":                {165, 42, 42, 255},
+	"burlywood":            {222, 184, 135, 255},
+	"cadetblue":            {95, 158, 160, 255},
+	"chartreuse":           {127, 255, 0, 255},
+	"chocolate":            {210, 105, 30, 255},
+	"coral":                {255, 127, 80, 255},
+	"cornflowerblue":       {100, 149, 237, 255},
+	"cornsilk":             {255, 248, 220, 255},
+	"crimson":              {220, 20, 60, 255},
+	"cyan":                 {0, 255, 255, 255},
+	"darkblue":             {0, 0, 139, 255},
+	"darkcyan":             {0, 139, 139, 255},
+	"darkgoldenrod":        {184, 134, 11, 255},
+	"darkgray":             {169, 169, 169, 255},
+	"darkgreen":            {0, 100, 0, 255},
+	"darkgrey":             {169, 169, 169, 255},
+	"darkkhaki":            {189, 183, 107, 255},
+	"darkmagenta":          {139, 0, 139, 255},
+	"darkolivegreen":       {85, 107, 47, 255},
+	"darkorange":           {255, 140, 0, 255},
+	"darkorchid":           {153, 50, 204, 255},
+	"darkred":              {139, 0, 0, 255},
+	"darksalmon":           {233, 150, 122, 255},
+	"darkseagreen":         {143, 188, 143, 255},
+	"darkslateblue":        {72, 61, 139, 255},
+	"darkslategray":        {47, 79, 79, 255},
+	"darkslategrey":        {47, 79, 79, 255},
+	"darkturquoise":        {0, 206, 209, 255},
+	"darkviolet":           {148, 0, 211, 255},
+	"deeppink":             {255, 20, 147, 255},
+	"deepskyblue":          {0, 191, 255, 255},
+	"dimgray":              {105, 105, 105, 255},
+	"dimgrey":              {105, 105, 105, 255},
+	"dodgerblue":           {30, 144, 255, 255},
+	"firebrick":            {178, 34, 34, 255},
+	"floralwhite":          {255, 250, 240, 255},
+	"forestgreen":          {34, 139, 34, 255},
+	"fuchsia":              {255, 0, 255, 255},
+	"gainsboro":            {220, 220, 220, 255},
+	"ghostwhite":           {248, 248, 255, 255},
+	"gold":                 {255, 215, 0, 255},
+	"goldenrod":            {218, 165, 32, 255},
+	"gray":                 {128, 128, 128, 255},
+	"green":                {0, 128, 0, 255},
+	"greenyellow":          {173, 255, 47, 255},
+	"grey":                 {128, 128, 128, 255},
+	"honeydew":             {240, 255, 240, 255},
+	"hotpink":              {255, 105, 180, 255},
+	"indianred":            {205, 92, 92, 255},
+	"indigo":               {75, 0, 130, 255},
+	"ivory":                {255, 255, 240, 255},
+	"khaki":                {240, 230, 140, 255},
+	"lavender":             {230, 230, 250, 255},
+	"lavenderblush":        {255, 240, 245, 255},
+	"lawngreen":            {124, 252, 0, 255},
+	"lemonchiffon":         {255, 250, 205, 255},
+	"lightblue":            {173, 216, 230, 255},
+	"lightcoral":           {240, 128, 128, 255},
+	"lightcyan":            {224, 255, 255, 255},
+	"lightgoldenrodyellow": {250, 250, 210, 255},
+	"lightgray":            {211, 211, 211, 255},
+	"lightgreen":           {144, 238, 144, 255},
+	"lightgrey":            {211, 211, 211, 255},
+	"lightpink":            {255, 182, 193, 255},
+	"lightsalmon":          {255, 160, 122, 255},
+	"lightseagreen":        {32, 178, 170, 255},
+	"lightskyblue":         {135, 206, 250, 255},
+	"lightslategray":       {119, 136, 153, 255},
+	"lightslategrey":       {119, 136, 153, 255},
+	"lightsteelblue":       {176, 196, 222, 255},
+	"lightyellow":          {255, 255, 224, 255},
+	"lime":                 {0, 255, 0, 255},
+	"limegreen":            {50, 205, 50, 255},
+	"linen":                {250, 240, 230, 255},
+	"magenta":              {255, 0, 255, 255},
+	"maroon":               {128, 0, 0, 255},
+	"mediumaquamarine":     {102, 205, 170, 255},
+	"mediumblue":           {0, 0, 205, 255},
+	"mediumorchid":         {186, 85, 211, 255},
+	"mediumpurple":         {147, 112, 219, 255},
+	"mediumseagreen":       {60, 179, 113, 255},
+	"mediumslateblue":      {123, 104, 238, 255},
+	"mediumspringgreen":    {0, 250, 154, 255},
+	"mediumturquoise":      {72, 209, 204, 255},
+	"mediumvioletred":      {199, 21, 133, 255},
+	"midnightblue":         {25, 25, 112, 255},
+	"mintcream":            {245, 255, 250, 255},
+	"mistyrose":            {255, 228, 225, 255},
+	"moccasin":             {255, 228, 181, 255},
+	"navajowhite":          {255, 222, 173, 255},
+	"navy":                 {0, 0, 128, 255},
+	"oldlace":              {253, 245, 230, 255},
+	"olive":                {128, 128, 0, 255},
+	"olivedrab":            {107, 142, 35, 255},
+	"orange":               {255, 165, 0, 255},
+	"orangered":            {255, 69, 0, 255},
+	"orchid":               {218, 112, 214, 255},
+	"palegoldenrod":        {238, 232, 170, 255},
+	"palegreen":            {152, 251, 152, 255},
+	"paleturquoise":        {175, 238, 238, 255},
+	"palevioletred":        {219, 112, 147, 255},
+	"papayawhip":           {255, 239, 213, 255},
+	"peachpuff":            {255, 218, 185, 255},
+	"peru":                 {205, 133, 63, 255},
+	"pink":                 {255, 192, 203, 255},
+	"plum":                 {221, 160, 221, 255},
+	"powderblue":           {176, 224, 230, 255},
+	"purple":               {128, 0, 128, 255},
+	"rebeccapurple":        {102, 51, 153, 255},
+	"red":                  {255, 0, 0, 255},
+	"rosybrown":            {188, 143, 143, 255},
+	"royalblue":            {65, 105, 225, 255},
+	"saddlebrown":          {139, 69, 19, 255},
+	"salmon":               {250, 128, 114, 255},
+	"sandybrown":           {244, 164, 96, 255},
+	"seagreen":             {46, 139, 87, 255},
+	"seashell":             {255, 245, 238, 255},
+	"sienna":               {160, 82, 45, 255},
+	"silver":               {192, 192, 192, 255},
+	"skyblue":              {135, 206, 235, 255},
+	"slateblue":            {106, 90, 205, 255},
+	"slategray":            {112, 128, 144, 255},
+	"slategrey":            {112, 128, 144, 255},
+	"snow":                 {255, 250, 250, 255},
+	"springgreen":          {0, 255, 127, 255},
+	"steelblue":            {70, 130, 180, 255},
+	"tan":                  {210, 180, 140, 255},
+	"teal":                 {0, 128, 128, 255},
+	"thistle":              {216, 191, 216, 255},
+	"tomato":               {255, 99, 71, 255},
+	"turquoise":            {64, 224, 208, 255},
+	"violet":               {238, 130, 238, 255},
+	"wheat":                {245, 222, 179, 255},
+	"white":                {255, 255, 255, 255},
+	"whitesmoke":           {245, 245, 245, 255},
+	"yellow":               {255, 255, 0, 255},
+	"yellowgreen":          {154, 205, 50, 255},
+}
+
+// ParseColour parses a colour in the CSS syntax used by .sublime-color-scheme
+// files, in which var(name) refers to the colour of the variable name in
+// vars. The supported colours are:
+//
+//	#rgb, #rgba, #rrggbb and #rrggbbaa
+//	rgb(), rgba(), hsl() and hsla()
+//	the CSS named colours
+//	color(base adjusters...) with the adjusters of AdjustColour
+func ParseColour(s string, vars map[string]string) (Colour, error) {
+	return parseColour(s, vars, 0)
+}
+
+// AdjustColour applies the space separated colour adjusters to c, as done
+// by the CSS color() function and the foreground_adjust of colour schemes.
+// The supported adjusters are:
+//
+//	alpha(v) or a(v)       sets the alpha to v, a number or percentage
+//	blend(c p%)            mixes c into the rgb of the colour, keeping p% of the colour
+//	blenda(c p%)           like blend but also mixes the alpha
+//	lightness(v) or l(v)   sets the hsl lightness
+//	saturation(v) or s(v)  sets the hsl saturation
+//
+// The lightness and saturation are percentages, which are made relative by
+// prefixing them with "+ " or "- ", or are multiplied with when prefixed
+// with "* ".
+func AdjustColour(c Colour, adjusters string, vars map[string]string) (Colour, error) {
+	return adjustColour(c, adjusters, vars, 0)
+}
+
+func parseColour(s string, vars map[string]string, depth int) (Colour, error) {
+	s = strings.TrimSpace(s)
+	if depth > maxColourVarDepth {
+		return Colour{}, fmt.Errorf("Variables nested too deeply in %q", s)
+	}
+	if strings.HasPrefix(s, "#") {
+		return parseHexColour(s)
+	}
+	name, args, ok := cssFunction(s)
+	if !ok {
+		if c, ok := namedColours[strings.ToLower(s)]; ok {
+			return c, nil
+		}
+		return Colour{}, fmt.Errorf("Unknown colour %q", s)
+	}
+
+	switch name {
+	case "var":
+		v, ok := vars[strings.TrimSpace(args)]
+		if !ok {
+			return Colour{}, fmt.Errorf("Undefined variable %q", strings.TrimSpace(args))
+		}
+		return parseColour(v, vars, depth+1)
+	case "color":
+		a := cssArgs(args)
+		if len(a) == 0 {
+			return Colour{}, fmt.Errorf("Missing base colour in %q", s)
+		}
+		c, err := parseColour(a[0], vars, depth+1)
+		if err != nil {
+			return Colour{}, err
+		}
+		return adjustColour(c, strings.Join(a[1:], " "), vars, depth)
+	case "rgb", "rgba", "hsl", "hsla":
+		a := cssArgs(args)
+		if len(a) != 3 && len(a) != 4 {
+			return Colour{}, fmt.Errorf("Expected 3 or 4 arguments in %q", s)
+		}
+		var (
+			c   Colour
+			v   [3]float64
+			err error
+		)
+		for i := range v {
+			max := 255.0
+			if name[0] == 'h' {
+				max = []float64{360, 1, 1}[i]
+			}
+			if v[i], err = cssNumber(strings.TrimSuffix(a[i], "deg"), max); err != nil {
+				return Colour{}, err
+			}
+		}
+		if name[0] == 'h' {
+			c = hslToColour(v[0], v[1], v[2])
+		} else {
+			c = Colour{channel(v[0]), channel(v[1]), channel(v[2]), 0}
+		}
+		c.A = 0xff
+		if len(a) == 4 {
+			alpha, err := cssNumber(a[3], 1)
+			if err != nil {
+				return Colour{}, err
+			}
+			c.A = channel(alpha * 0xff)
+		}
+		return c, nil
+	}
+	return Colour{}, fmt.Errorf("Unknown colour function %q", name)
+}
+
+func adjustColour(c Colour, adjusters string, vars map[string]string, depth int) (Colour, error) {
+	for _, adj := range cssArgs(adjusters) {
+		name, args, ok := cssFunction(adj)
+		if !ok {
+			return Colour{}, fmt.Errorf("Invalid colour adjuster %q", adj)
+		}
+		switch name {
+		case "alpha", "a":
+			a, err := cssNumber(args, 1)
+			if err != nil {
+				return Colour{}, err
+			}
+			c.A = channel(a * 0xff)
+		case "blend", "blenda":
+			a := cssArgs(args)
+			if len(a) != 2 {
+				return Colour{}, fmt.Errorf("Expected a colour and a percentage in %q", adj)
+			}
+			o, err := parseColour(a[0], vars, depth+1)
+			if err != nil {
+				return Colour{}, err
+			}
+			p, err := cssNumber(a[1], 1)
+			if err != nil {
+				return Colour{}, err
+			}
+			mix := func(x, y uint8) uint8 {
+				return channel(float64(x)*p + float64(y)*(1-p))
+			}
+			c.R, c.G, c.B = mix(c.R, o.R), mix(c.G, o.G), mix(c.B, o.B)
+			if name == "blenda" {
+				c.A = mix(c.A, o.A)
+			}
+		case "lightness", "l", "saturation", "s":
+			h, s, l := colourToHSL(c)
+			v := &l
+			if name[0] == 's' {
+				v = &s
+			}
+			if err := adjustValue(v, args); err != nil {
+				return Colour{}, err
+			}
+			a := c.A
+			c = hslToColour(h, s, l)
+			c.A = a
+		default:
+			return Colour{}, fmt.Errorf("Unknown colour adjuster %q", name)
+		}
+	}
+	return c, nil
+}
+
+// Sets, or changes when prefixed with an operator, the
+// value v in the range 0-1 as described by args.
+func adjustValue(v *float64, args string) error {
+	args = strings.TrimSpace(args)
+	op := byte(0)
+	if len(args) > 0 && strings.IndexByte("+-*", args[0]) != -1 {
+		op, args = args[0], strings.TrimSpace(args[1:])
+	}
+	n, err := cssNumber(args, 1)
+	if err != nil {
+		return err
+	}
+	switch op {
+	case '+':
+		*v += n
+	case '-':
+		*v -= n
+	case '*':
+		*v *= n
+	default:
+		*v = n
+	}
+	*v = math.Max(0, math.Min(1, *v))
+	return nil
+}
+
+func parseHexColour(s string) (Colour, error) {
+	h := s[1:]
+	if len(h) == 3 || len(h) == 4 {
+		var b strings.Builder
+		for _, r := range h {
+			b.WriteRune(r)
+			b.WriteRune(r)
+		}
+		h = b.String()
+	}
+	if len(h) == 6 {
+		h += "ff"
+	}
+	if len(h) != 8 {
+		return Colour{}, fmt.Errorf("Invalid hex colour %q", s)
+	}
+	v, err := strconv.ParseUint(h, 16, 32)
+	if err != nil {
+		return Colour{}, fmt.Errorf("Invalid hex colour %q", s)
+	}
+	return Colour{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+}
+
+// Splits s of the form name(args) into the lower
+// case name and args, or returns false if it isn't.
+func cssFunction(s string) (name, args string, ok bool) {
+	i := strings.IndexByte(s, '(')
+	if i <= 0 || !strings.HasSuffix(s, ")") {
+		return "", "", false
+	}
+	args = s[i+1 : len(s)-1]
+	depth := 0
+	for _, r := range args {
+		if r == '(' {
+			depth++
+		} else if r == ')' {
+			if depth--; depth < 0 {
+				return "", "", false
+			}
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(s[:i])), args, depth == 0
+}
+
+// Splits the arguments of a CSS function at commas, slashes and white
+// space, except for those inside of parentheses. The operators of
+// relative values are kept together with the value they apply to.
+func cssArgs(s string) (ret []string) {
+	depth, start := 0, -1
+	end := func(i int) {
+		if start != -1 {
+			ret = append(ret, s[start:i])
+			start = -1
+		}
+	}
+	for i, r := range s {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case depth == 0 && (r == ',' || r == '/' || r == ' ' || r == '\t' || r == '\n'):
+			if start != -1 && (s[start:i] == "+" || s[start:i] == "-" || s[start:i] == "*") {
+				continue
+			}
+			end(i)
+			continue
+		}
+		if start == -1 {
+			start = i
+		}
+	}
+	end(len(s))
+	return
+}
+
+// Parses the number s, where a percentage is relative to max.
+func cssNumber(s string, max float64) (float64, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "%") {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid percentage %q", s)
+		}
+		return v / 100 * max, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid number %q", s)
+	}
+	return v, nil
+}
+
+// Returns v rounded and clamped to the range of a colour channel.
+func channel(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(0xff, math.Round(v))))
+}
+
+// Returns the opaque colour of the hue h in degrees,
+// and the saturation s and lightness l from 0 to 1.
+func hslToColour(h, s, l float64) Colour {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	s = math.Max(0, math.Min(1, s))
+	l = math.Max(0, math.Min(1, l))
+	ch := (1 - math.Abs(2*l-1)) * s
+	x := ch * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g = ch, x
+	case h < 120:
+		r, g = x, ch
+	case h < 180:
+		g, b = ch, x
+	case h < 240:
+		g, b = x, ch
+	case h < 300:
+		r, b = x, ch
+	default:
+		r, b = ch, x
+	}
+	m := l - ch/2
+	return Colour{channel((r + m) * 0xff), channel((g + m) * 0xff), channel((b + m) * 0xff), 0xff}
+}
+
+// Returns the hue in degrees, and the saturation and lightness from 0 to 1, of c.
+func colourToHSL(c Colour) (h, s, l float64) {
+	r, g, b := float64(c.R)/0xff, float64(c.G)/0xff, float64(c.B)/0xff
+	max, min := math.Max(r, math.Max(g, b)), math.Min(r, math.Min(g, b))
+	l = (max + min) / 2
+	d := max - min
+	if d == 0 {
+		return 0, 0, l
+	}
+	s = d / (1 - math.Abs(2*l-1))
+	switch max {
+	case r:
+		h = math.Mod((g-b)/d, 6)
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	h *= 60
+	if h < 0 {
+		h += 360
+	}
+	return
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package render
+
+import "testing"
+
+func TestParseColour(t *testing.T) {
+	vars := map[string]string{
+		"red":   "#f00",
+		"alias": "var(red)",
+		"loop":  "var(loop)",
+		"faded": "color(var(red) alpha(0.5))",
+	}
+	tests := []struct {
+		in  string
+		exp Colour
+		err bool
+	}{
+		{"#f00", Colour{255, 0, 0, 255}, false},
+		{"#f008", Colour{255, 0, 0, 0x88}, false},
+		{"#102030", Colour{0x10, 0x20, 0x30, 255}, false},
+		{"#10203040", Colour{0x10, 0x20, 0x30, 0x40}, false},
+		{"#12345", Colour{}, true},
+		{"#xyz", Colour{}, true},
+		{"rgb(1, 2, 3)", Colour{1, 2, 3, 255}, false},
+		{"rgba(1, 2, 3, 0.5)", Colour{1, 2, 3, 128}, false},
+		{"rgb(100% 0% 50% / 50%)", Colour{255, 0, 128, 128}, false},
+		{"rgb(1, 2)", Colour{}, true},
+		{"hsl(0, 100%, 50%)", Colour{255, 0, 0, 255}, false},
+		{"hsl(120deg, 100%, 25%)", Colour{0, 128, 0, 255}, false},
+		{"hsla(240, 100%, 50%, 0)", Colour{0, 0, 255, 0}, false},
+		{"RebeccaPurple", Colour{102, 51, 153, 255}, false},
+		{"transparent", Colour{}, false},
+		{"notacolour", Colour{}, true},
+		{"var(alias)", Colour{255, 0, 0, 255}, false},
+		{"var(missing)", Colour{}, true},
+		{"var(loop)", Colour{}, true},
+		{"color(var(red) alpha(0.5))", Colour{255, 0, 0, 128}, false},
+		{"color(var(faded) a(25%))", Colour{255, 0, 0, 64}, false},
+		{"color(white blend(black 25%))", Colour{64, 64, 64, 255}, false},
+		{"color(#ffffff00 blenda(#000000ff 50%))", Colour{128, 128, 128, 128}, false},
+		{"color(hsl(0, 100%, 50%) l(+ 25%))", Colour{255, 128, 128, 255}, false},
+		{"color(hsl(0, 100%, 50%) lightness(25%))", Colour{128, 0, 0, 255}, false},
+		{"color(hsl(0, 100%, 50%) s(* 0))", Colour{128, 128, 128, 255}, false},
+		{"color(red min-contrast(white 4.5))", Colour{}, true},
+		{"color(red alpha)", Colour{}, true},
+	}
+	for i, test := range tests {
+		c, err := ParseColour(test.in, vars)
+		if test.err {
+			if err == nil {
+				t.Errorf("Test %d: Expected an error parsing %q, but got %v", i, test.in, c)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: Unexpected error parsing %q: %s", i, test.in, err)
+		} else if c != test.exp {
+			t.Errorf("Test %d: Expected %q to be %v, but got %v", i, test.in, test.exp, c)
+		}
+	}
+}
+
+func TestAdjustColour(t *testing.T) {
+	c, err := AdjustColour(Colour{0, 0, 255, 255}, "l(- 25%) a(0.5)", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := (Colour{0, 0, 128, 128}); c != exp {
+		t.Errorf("Expected %v, but got %v", exp, c)
+	}
+	if _, err := AdjustColour(c, "bogus(1)", nil); err == nil {
+		t.Error("Expected an error for an unknown adjuster")
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"sort"
+
+	"github.com/limetext/text"
+	"github.com/limetext/util"
+)
+
+// The layers of decorations, from the bottom to the top
+const (
+	LAYER_TEXT      Layer = iota // The syntax highlighting, i.e DRAW_TEXT regions
+	LAYER_REGION                 // Regions filled and outlined with the colour of their scope
+	LAYER_UNDERLINE              // Regions drawn with one of the DRAW_*_UNDERLINE flags
+	LAYER_SELECTION              // The selection
+)
+
+const (
+	UNDERLINE_NONE     UnderlineStyle = iota // Not underlined
+	UNDERLINE_SOLID                          // DRAW_SOLID_UNDERLINE
+	UNDERLINE_STIPPLED                       // DRAW_STIPPLED_UNDERLINE
+	UNDERLINE_SQUIGGLY                       // DRAW_SQUIGGLY_UNDERLINE
+)
+
+type (
+	// The layer a Decoration is drawn in. Decorations of
+	// higher layers are drawn on top of those of lower ones.
+	Layer int
+
+	// How a region is underlined.
+	UnderlineStyle int
+
+	// A Decoration is one of the things drawn on a Run of text,
+	// derived from the Flavour of a region and its flags.
+	Decoration struct {
+		Layer   Layer
+		Flavour Flavour
+		// Whether the region is filled, and outlined, with its colour.
+		// That's the Foreground of the Flavour for the LAYER_REGION and
+		// LAYER_UNDERLINE layers, as the scope of a region decides its
+		// colour, and the Background for the LAYER_SELECTION one.
+		Fill    bool
+		Outline bool
+		// The underline, drawn in the colour of the region
+		Underline UnderlineStyle
+	}
+
+	// A Run is a Region of text with the same decorations all over it.
+	Run struct {
+		Region text.Region
+		// The colours and font the text is drawn with, i.e the
+		// composition of the decorations, with the flags of all
+		// of them
+		Flavour Flavour
+		// The decorations of the run, from the bottom to the top
+		Decorations []Decoration
+	}
+)
+
+// Returns the Decoration a region rendered with f is drawn as.
+func decoration(f Flavour) Decoration {
+	d := Decoration{
+		Flavour: f,
+		Fill:    f.Flags&DRAW_NO_FILL == 0,
+		Outline: f.Flags&DRAW_NO_OUTLINE == 0,
+	}
+	switch {
+	case f.Flags&DRAW_SQUIGGLY_UNDERLINE != 0:
+		d.Underline = UNDERLINE_SQUIGGLY
+	case f.Flags&DRAW_STIPPLED_UNDERLINE != 0:
+		d.Underline = UNDERLINE_STIPPLED
+	case f.Flags&DRAW_SOLID_UNDERLINE != 0:
+		d.Underline = UNDERLINE_SOLID
+	}
+	switch {
+	case f.Flags&SELECTION != 0:
+		d.Layer = LAYER_SELECTION
+	case f.Flags&DRAW_TEXT != 0:
+		d.Layer, d.Outline = LAYER_TEXT, false
+	case d.Underline != UNDERLINE_NONE:
+		d.Layer = LAYER_UNDERLINE
+	default:
+		d.Layer = LAYER_REGION
+	}
+	return d
+}
+
+// Orders decorations by layer, and then arbitrarily but deterministically.
+func decorationLess(a, b Decoration) bool {
+	if a.Layer != b.Layer {
+		return a.Layer < b.Layer
+	}
+	if a.Flavour.Flags != b.Flavour.Flags {
+		return a.Flavour.Flags < b.Flavour.Flags
+	}
+	if a.Flavour.Foreground != b.Flavour.Foreground {
+		return a.Flavour.Foreground.String() < b.Flavour.Foreground.String()
+	}
+	return a.Flavour.Background.String() < b.Flavour.Background.String()
+}
+
+// Returns c drawn over bg, c's alpha being its opacity.
+func over(c, bg Colour) Colour {
+	a := float64(c.A) / 0xff
+	mix := func(x, y uint8) uint8 {
+		return channel(float64(x)*a + float64(y)*(1-a))
+	}
+	return Colour{mix(c.R, bg.R), mix(c.G, bg.G), mix(c.B, bg.B), channel(float64(c.A) + float64(bg.A)*(1-a))}
+}
+
+// Returns the Flavour text with the decorations ds is drawn with.
+//
+// The foreground and font are those of the syntax highlighting, unless the
+// selection has a foreground colour other than the global one. Backgrounds
+// are drawn on top of each other: the one of the syntax highlighting, the
+// colours of filled regions, and the background of the selection.
+func composeFlavour(ds []Decoration, gs Settings) Flavour {
+	f := Flavour{Foreground: gs.Foreground, Background: gs.Background}
+	for _, d := range ds {
+		f.Flags |= d.Flavour.Flags
+		switch d.Layer {
+		case LAYER_TEXT:
+			f.Foreground, f.Font = d.Flavour.Foreground, d.Flavour.Font
+			f.Background = over(d.Flavour.Background, f.Background)
+		case LAYER_SELECTION:
+			if d.Fill {
+				f.Background = over(d.Flavour.Background, f.Background)
+			}
+			if fg := d.Flavour.Foreground; fg.A != 0 && fg != gs.Foreground {
+				f.Foreground = fg
+			}
+		default:
+			if d.Fill {
+				f.Background = over(d.Flavour.Foreground, f.Background)
+			}
+		}
+	}
+	return f
+}
+
+func sameDecorations(a, b []Decoration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Compose stacks the regions of recipe, which would be the Recipe of the
+// viewport, on top of each other and splits the viewport into Runs of text
+// with the same decorations. Unlike the Recipe, in which each Region has one
+// Flavour, this lets e.g syntax colours, underlines and selections apply
+// to the same text. Text without any decorations is drawn with the global
+// settings gs, and HIDDEN regions are left out.
+func Compose(recipe Recipe, gs Settings, viewport text.Region) (ret []Run) {
+	pe := util.Prof.Enter("render.Compose")
+	defer pe.Exit()
+
+	type span struct {
+		region text.Region
+		d      Decoration
+	}
+	var spans []span
+	vp := text.Region{A: viewport.Begin(), B: viewport.End()}
+	points := []int{vp.A, vp.B}
+	for _, ru := range recipe.Transcribe() {
+		if ru.Flavour.Flags&HIDDEN != 0 {
+			continue
+		}
+		r := text.Region{A: ru.Region.Begin(), B: ru.Region.End()}
+		if r.A < vp.A {
+			r.A = vp.A
+		}
+		if r.B > vp.B {
+			r.B = vp.B
+		}
+		if r.A >= r.B {
+			continue
+		}
+		spans = append(spans, span{r, decoration(ru.Flavour)})
+		points = append(points, r.A, r.B)
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].region.A < spans[j].region.A
+	})
+	sort.Ints(points)
+
+	var active []span
+	next := 0
+	for i := 0; i+1 < len(points); i++ {
+		a, b := points[i], points[i+1]
+		if a == b {
+			continue
+		}
+		for next < len(spans) && spans[next].region.A <= a {
+			active = append(active, spans[next])
+			next++
+		}
+		ds := []Decoration{}
+		live := active[:0]
+		for _, s := range active {
+			if s.region.B > a {
+				live = append(live, s)
+				ds = append(ds, s.d)
+			}
+		}
+		active = live
+		sort.Slice(ds, func(i, j int) bool {
+			return decorationLess(ds[i], ds[j])
+		})
+		if l := len(ret) - 1; l >= 0 && ret[l].Region.B == a && sameDecorations(ret[l].Decorations, ds) {
+			ret[l].Region.B = b
+			continue
+		}
+		ret = append(ret, Run{Region: text.Region{A: a, B: b}, Flavour: composeFlavour(ds, gs), Decorations: ds})
+	}
+	return
+}
@@ -0,0 +1,85 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/limetext/text"
+)
+
+func TestCompose(t *testing.T) {
+	var (
+		white = Colour{255, 255, 255, 255}
+		black = Colour{0, 0, 0, 255}
+		red   = Colour{255, 0, 0, 255}
+		grey  = Colour{0x40, 0x40, 0x40, 255}
+		faded = Colour{0, 0, 255, 0x80}
+	)
+	gs := Settings{Foreground: white, Background: black}
+	regions := func(rs ...text.Region) (ret text.RegionSet) {
+		ret.AddAll(rs)
+		return
+	}
+	keyword := Flavour{Foreground: red, Background: black, Font: Font{Style: Bold}, Flags: DRAW_TEXT}
+	squiggle := Flavour{Foreground: red, Flags: DRAW_SQUIGGLY_UNDERLINE | DRAW_NO_FILL | DRAW_NO_OUTLINE}
+	highlight := Flavour{Foreground: faded, Flags: HIGHLIGHT | DRAW_NO_OUTLINE}
+	selection := Flavour{Foreground: white, Background: grey, Flags: SELECTION}
+	hidden := Flavour{Foreground: red, Flags: HIDDEN}
+	recipe := Recipe{
+		keyword:   regions(text.Region{A: 0, B: 4}),
+		squiggle:  regions(text.Region{A: 2, B: 6}),
+		highlight: regions(text.Region{A: 5, B: 8}),
+		selection: regions(text.Region{A: 3, B: 5}),
+		hidden:    regions(text.Region{A: 0, B: 10}),
+	}
+
+	var (
+		dk = decoration(keyword)
+		dq = decoration(squiggle)
+		dh = decoration(highlight)
+		ds = decoration(selection)
+	)
+	if dk.Layer != LAYER_TEXT || dq.Layer != LAYER_UNDERLINE || dq.Underline != UNDERLINE_SQUIGGLY || dq.Fill || dq.Outline || dh.Layer != LAYER_REGION || !dh.Fill || dh.Outline || ds.Layer != LAYER_SELECTION {
+		t.Errorf("Unexpected decorations %+v %+v %+v %+v", dk, dq, dh, ds)
+	}
+
+	bold := Font{Style: Bold}
+	exp := []Run{
+		{text.Region{A: 0, B: 2}, Flavour{Foreground: red, Background: black, Font: bold, Flags: DRAW_TEXT}, []Decoration{dk}},
+		{text.Region{A: 2, B: 3}, Flavour{Foreground: red, Background: black, Font: bold, Flags: keyword.Flags | squiggle.Flags}, []Decoration{dk, dq}},
+		{text.Region{A: 3, B: 4}, Flavour{Foreground: red, Background: grey, Font: bold, Flags: keyword.Flags | squiggle.Flags | SELECTION}, []Decoration{dk, dq, ds}},
+		{text.Region{A: 4, B: 5}, Flavour{Foreground: white, Background: grey, Flags: squiggle.Flags | SELECTION}, []Decoration{dq, ds}},
+		{text.Region{A: 5, B: 6}, Flavour{Foreground: white, Background: Colour{0, 0, 0x80, 255}, Flags: highlight.Flags | squiggle.Flags}, []Decoration{dh, dq}},
+		{text.Region{A: 6, B: 8}, Flavour{Foreground: white, Background: Colour{0, 0, 0x80, 255}, Flags: highlight.Flags}, []Decoration{dh}},
+		{text.Region{A: 8, B: 10}, Flavour{Foreground: white, Background: black}, []Decoration{}},
+	}
+	runs := Compose(recipe, gs, text.Region{A: 0, B: 10})
+	if !reflect.DeepEqual(runs, exp) {
+		t.Errorf("Expected\n%+v, but got\n%+v", exp, runs)
+	}
+
+	runs = Compose(recipe, gs, text.Region{A: 7, B: 3})
+	if len(runs) == 0 || runs[0].Region.A != 3 || runs[len(runs)-1].Region.B != 7 {
+		t.Errorf("Expected the runs to cover the viewport, but got %+v", runs)
+	}
+}
+
+func TestOver(t *testing.T) {
+	tests := []struct {
+		c, bg, exp Colour
+	}{
+		{Colour{255, 0, 0, 255}, Colour{0, 0, 255, 255}, Colour{255, 0, 0, 255}},
+		{Colour{255, 0, 0, 0}, Colour{0, 0, 255, 255}, Colour{0, 0, 255, 255}},
+		{Colour{255, 0, 0, 0x80}, Colour{0, 0, 255, 255}, Colour{0x80, 0, 0x7f, 255}},
+		{Colour{255, 0, 0, 0x80}, Colour{}, Colour{0x80, 0, 0, 0x80}},
+	}
+	for i, test := range tests {
+		if c := over(test.c, test.bg); c != test.exp {
+			t.Errorf("Test %d: Expected %v, but got %v", i, test.exp, c)
+		}
+	}
+}
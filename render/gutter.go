@@ -0,0 +1,190 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"sort"
+
+	"github.com/limetext/text"
+	"github.com/limetext/util"
+)
+
+const (
+	LINE_UNCHANGED     LineChange = iota // The line is the same as in the saved content
+	LINE_ADDED                           // The line was added
+	LINE_MODIFIED                        // The line was changed
+	LINE_REMOVED_ABOVE                   // Lines were removed right above this line
+	LINE_REMOVED_BELOW                   // Lines were removed right below this line, which is the last one
+)
+
+// Above this many lines in a changed part of the text,
+// its lines are marked as modified without diffing them.
+const maxDiffLines = 1 << 10
+
+type (
+	// How a line differs from the last saved content.
+	LineChange int
+
+	// A GutterIcon is the icon of a ViewRegions shown next to a line.
+	GutterIcon struct {
+		// The key of the ViewRegions in the ViewRegionMap
+		Key      string
+		Icon     string
+		Scope    string
+		Priority int
+	}
+
+	// A GutterLine contains what is displayed
+	// in the gutter next to a buffer line.
+	GutterLine struct {
+		// The line number, starting at 1
+		Number int
+		// The Region of the line, not including the line ending
+		Region text.Region
+		// The icons of regions starting on this line, highest priority first
+		Icons []GutterIcon
+		// Whether part of the line is folded
+		Folded bool
+		Change LineChange
+	}
+
+	// The Gutter of a viewport, one GutterLine per visible
+	// buffer line. Lines hidden in folds are left out.
+	Gutter []GutterLine
+)
+
+// NewGutter creates the Gutter of the lines of buf touched by viewport.
+// Gutter icons are taken from the ViewRegions of regions with an Icon,
+// skipping HIDDEN ones. changes contains the LineChange of each line of
+// buf, as returned by DiffLines, and may be nil.
+func NewGutter(buf text.Buffer, viewport text.Region, regions ViewRegionMap, folds []text.Region, changes []LineChange) (ret Gutter) {
+	pe := util.Prof.Enter("render.NewGutter")
+	defer pe.Exit()
+
+	icons := make(map[int][]GutterIcon)
+	for k, vr := range regions {
+		if vr.Icon == "" || vr.Flags&HIDDEN != 0 {
+			continue
+		}
+		gi := GutterIcon{Key: k, Icon: vr.Icon, Scope: vr.Scope, Priority: vr.Priority}
+		for _, r := range vr.Regions.Regions() {
+			row, _ := buf.RowCol(r.Begin())
+			icons[row] = append(icons[row], gi)
+		}
+	}
+
+	for p := buf.Line(viewport.Begin()).Begin(); p <= viewport.End(); {
+		line := buf.Line(p)
+		hidden, folded := false, false
+		for _, f := range folds {
+			if f.Begin() < line.A && line.A <= f.End() {
+				hidden = true
+			}
+			if line.A <= f.Begin() && f.Begin() <= line.B && !f.Empty() {
+				folded = true
+			}
+		}
+		if !hidden {
+			row, _ := buf.RowCol(line.A)
+			gl := GutterLine{Number: row + 1, Region: line, Folded: folded}
+			if row < len(changes) {
+				gl.Change = changes[row]
+			}
+			gl.Icons = icons[row]
+			sort.Slice(gl.Icons, func(i, j int) bool {
+				a, b := gl.Icons[i], gl.Icons[j]
+				if a.Priority == b.Priority {
+					return a.Key < b.Key
+				}
+				return a.Priority > b.Priority
+			})
+			ret = append(ret, gl)
+		}
+		if p = line.B + 1; p > buf.Size() {
+			break
+		}
+	}
+	return
+}
+
+// DiffLines returns how each of the lines in current
+// differs from the lines in saved.
+func DiffLines(saved, current []string) []LineChange {
+	pe := util.Prof.Enter("render.DiffLines")
+	defer pe.Exit()
+
+	ret := make([]LineChange, len(current))
+	// Skip the common prefix and suffix
+	a := 0
+	for a < len(saved) && a < len(current) && saved[a] == current[a] {
+		a++
+	}
+	bs, bc := len(saved), len(current)
+	for bs > a && bc > a && saved[bs-1] == current[bc-1] {
+		bs--
+		bc--
+	}
+	s, c := saved[a:bs], current[a:bc]
+	if len(s)*len(c) > maxDiffLines*maxDiffLines {
+		markHunk(ret, a, len(s), len(c))
+		return ret
+	}
+
+	// Longest common subsequence of the remaining lines,
+	// lcs[i][j] being the one of s[i:] and c[j:]
+	lcs := make([][]int, len(s)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(c)+1)
+	}
+	for i := len(s) - 1; i >= 0; i-- {
+		for j := len(c) - 1; j >= 0; j-- {
+			if s[i] == c[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	removed, added := 0, 0
+	for i < len(s) || j < len(c) {
+		switch {
+		case i < len(s) && j < len(c) && s[i] == c[j]:
+			markHunk(ret, a+j-added, removed, added)
+			removed, added = 0, 0
+			i++
+			j++
+		case j < len(c) && (i == len(s) || lcs[i][j+1] >= lcs[i+1][j]):
+			added++
+			j++
+		default:
+			removed++
+			i++
+		}
+	}
+	markHunk(ret, a+j-added, removed, added)
+	return ret
+}
+
+// Marks the lines of a hunk starting at line, in which removed lines were
+// replaced by added lines. Added lines replacing removed ones are modified.
+func markHunk(changes []LineChange, line, removed, added int) {
+	for i := 0; i < added; i++ {
+		if i < removed {
+			changes[line+i] = LINE_MODIFIED
+		} else {
+			changes[line+i] = LINE_ADDED
+		}
+	}
+	if removed > 0 && added == 0 {
+		if line < len(changes) {
+			changes[line] = LINE_REMOVED_ABOVE
+		} else if line > 0 {
+			changes[line-1] = LINE_REMOVED_BELOW
+		}
+	}
+}
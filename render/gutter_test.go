@@ -0,0 +1,80 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/limetext/text"
+)
+
+func TestDiffLines(t *testing.T) {
+	const (
+		U  = LINE_UNCHANGED
+		A  = LINE_ADDED
+		M  = LINE_MODIFIED
+		RA = LINE_REMOVED_ABOVE
+		RB = LINE_REMOVED_BELOW
+	)
+	tests := []struct {
+		saved, current string
+		exp            []LineChange
+	}{
+		{"a\nb\nc", "a\nb\nc", []LineChange{U, U, U}},
+		{"a\nb\nc", "a\nx\nb\nc", []LineChange{U, A, U, U}},
+		{"a\nb\nc", "a\nx\nc", []LineChange{U, M, U}},
+		{"a\nb\nc", "a\nc", []LineChange{U, RA}},
+		{"a\nb\nc", "a\nb", []LineChange{U, RB}},
+		{"a\nb\nc\nd", "x\nb\ny\nz\nd", []LineChange{M, U, M, A, U}},
+		{"a\nb\nc", "b\nc\nd", []LineChange{RA, U, A}},
+		{"", "a\nb", []LineChange{M, A}},
+	}
+	for i, test := range tests {
+		got := DiffLines(strings.Split(test.saved, "\n"), strings.Split(test.current, "\n"))
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("Test %d: Expected %v, but got %v", i, test.exp, got)
+		}
+	}
+}
+
+func TestNewGutter(t *testing.T) {
+	buf := text.NewBuffer()
+	buf.Insert(0, "a\nb {\nc\n}\nd")
+
+	regions := make(ViewRegionMap)
+	add := func(key, icon string, priority int, flags ViewRegionFlags, rs ...text.Region) {
+		vr := ViewRegions{Icon: icon, Priority: priority, Flags: flags}
+		vr.Regions.AddAll(rs)
+		regions[key] = vr
+	}
+	add("bookmarks", "bookmark", 0, 0, text.Region{A: 0, B: 0})
+	add("errors", "error", 10, 0, text.Region{A: 1, B: 3})
+	add("hidden", "dot", 20, HIDDEN, text.Region{A: 0, B: 0})
+	add("plain", "", 30, 0, text.Region{A: 0, B: 0})
+
+	folds := []text.Region{{A: 5, B: 8}}
+	changes := []LineChange{LINE_UNCHANGED, LINE_MODIFIED, LINE_ADDED, LINE_UNCHANGED, LINE_REMOVED_BELOW}
+	g := NewGutter(buf, text.Region{A: 0, B: buf.Size()}, regions, folds, changes)
+
+	exp := Gutter{
+		{Number: 1, Region: text.Region{A: 0, B: 1}, Icons: []GutterIcon{
+			{Key: "errors", Icon: "error", Priority: 10},
+			{Key: "bookmarks", Icon: "bookmark"},
+		}},
+		{Number: 2, Region: text.Region{A: 2, B: 5}, Folded: true, Change: LINE_MODIFIED},
+		{Number: 5, Region: text.Region{A: 10, B: 11}, Change: LINE_REMOVED_BELOW},
+	}
+	if !reflect.DeepEqual(g, exp) {
+		t.Errorf("Expected gutter\n%v, but got\n%v", exp, g)
+	}
+
+	g = NewGutter(buf, text.Region{A: 10, B: 11}, regions, nil, nil)
+	exp = Gutter{{Number: 5, Region: text.Region{A: 10, B: 11}}}
+	if !reflect.DeepEqual(g, exp) {
+		t.Errorf("Expected gutter %v, but got %v", exp, g)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+
+	"github.com/limetext/text"
+	"github.com/limetext/util"
+)
+
+// The HTMLRenderer renders a Recipe of the whole Buffer as a standalone
+// html document, styled with the colour scheme's settings.
+//
+// Only the RenderUnits with the DRAW_TEXT flag, i.e the ones of the
+// syntax highlighting, are used for styling the text.
+type HTMLRenderer struct {
+	// The document is written to W
+	W      io.Writer
+	Buffer text.Buffer
+	Scheme ColourScheme
+	// The title of the document
+	Title string
+	// The font used for the text and the number of columns between tab stops
+	Font    Font
+	TabSize int
+	// Whether to prefix the lines with their line number
+	LineNumbers bool
+	// When set, the icon of highest priority of each line is shown
+	// next to it. Typically the Gutter of the whole Buffer.
+	Gutter Gutter
+
+	err error
+}
+
+// Returns the first error which occurred writing to W.
+func (r *HTMLRenderer) Err() error {
+	return r.err
+}
+
+func (r *HTMLRenderer) write(format string, args ...interface{}) {
+	if r.err == nil {
+		_, r.err = fmt.Fprintf(r.W, format, args...)
+	}
+}
+
+// Returns the css representation of c.
+func cssColour(c Colour) string {
+	if c.A == 0xff {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("rgba(%d, %d, %d, %.3f)", c.R, c.G, c.B, float64(c.A)/0xff)
+}
+
+// Returns the css style of the text rendered with f.
+func (r *HTMLRenderer) style(f Flavour, gs Settings) string {
+	var s []string
+	if f.Foreground != gs.Foreground && f.Foreground.A != 0 {
+		s = append(s, "color: "+cssColour(f.Foreground))
+	}
+	if f.Background != gs.Background && f.Background.A != 0 {
+		s = append(s, "background-color: "+cssColour(f.Background))
+	}
+	if f.Font.Style&Bold != 0 {
+		s = append(s, "font-weight: bold")
+	}
+	if f.Font.Style&Italic != 0 {
+		s = append(s, "font-style: italic")
+	}
+	if f.Font.Style&Underline != 0 {
+		s = append(s, "text-decoration: underline")
+	}
+	return strings.Join(s, "; ")
+}
+
+func (r *HTMLRenderer) Render(recipe Recipe) {
+	pe := util.Prof.Enter("render.HTMLRenderer.Render")
+	defer pe.Exit()
+
+	gs := r.Scheme.GlobalSettings()
+	tabSize := r.TabSize
+	if tabSize <= 0 {
+		tabSize = 4
+	}
+	font := "monospace"
+	if r.Font.Name != "" {
+		font = fmt.Sprintf("%q, monospace", r.Font.Name)
+	}
+	r.write(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { margin: 0; background-color: %s; color: %s; }
+pre { margin: 0; padding: 0.5em; font-family: %s; tab-size: %d; -moz-tab-size: %d; }
+.ln { color: %s; background-color: %s; user-select: none; }
+.icon { width: 1em; height: 1em; vertical-align: middle; }
+</style>
+</head>
+<body>
+<pre>`, html.EscapeString(r.Title), cssColour(gs.Background), cssColour(gs.Foreground), font, tabSize, tabSize, cssColour(gs.GutterForeground), cssColour(gs.Gutter))
+
+	icons := make(map[int]GutterIcon)
+	for _, gl := range r.Gutter {
+		if len(gl.Icons) > 0 {
+			icons[gl.Number] = gl.Icons[0]
+		}
+	}
+	lines, _ := r.Buffer.RowCol(r.Buffer.Size())
+	width := len(fmt.Sprint(lines + 1))
+	line := 0
+	prefix := func() {
+		line++
+		if !r.LineNumbers && r.Gutter == nil {
+			return
+		}
+		r.write(`<span class="ln">`)
+		if r.Gutter != nil {
+			if gi, ok := icons[line]; ok {
+				r.write(`<img class="icon" src="%s" alt="%s">`, html.EscapeString(gi.Icon), html.EscapeString(gi.Key))
+			} else {
+				r.write(`<img class="icon" alt="">`)
+			}
+		}
+		if r.LineNumbers {
+			r.write("%*d ", width, line)
+		}
+		r.write(`</span>`)
+	}
+	emit := func(reg text.Region, style string) {
+		for i, s := range strings.Split(r.Buffer.Substr(reg), "\n") {
+			if i > 0 {
+				r.write("\n")
+				prefix()
+			}
+			if s == "" {
+				continue
+			}
+			if style == "" {
+				r.write("%s", html.EscapeString(s))
+			} else {
+				r.write(`<span style="%s">%s</span>`, style, html.EscapeString(s))
+			}
+		}
+	}
+
+	prefix()
+	for _, ru := range textUnits(recipe, r.Buffer.Size()) {
+		emit(ru.Region, r.style(ru.Flavour, gs))
+	}
+	r.write("</pre>\n</body>\n</html>\n")
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/limetext/text"
+)
+
+type htmlColourScheme struct{}
+
+func (cs htmlColourScheme) Spice(vr *ViewRegions) Flavour {
+	f := Flavour{Foreground: Colour{0xee, 0xee, 0xee, 0xff}, Background: Colour{0, 0, 0, 0xff}}
+	switch vr.Scope {
+	case "keyword":
+		f.Foreground = Colour{0xff, 0, 0, 0xff}
+		f.Font.Style = Bold
+	case "selection":
+		f.Background = Colour{0, 0, 0xff, 0xff}
+	}
+	return f
+}
+
+func (cs htmlColourScheme) GlobalSettings() Settings {
+	return Settings{
+		Foreground: Colour{0xee, 0xee, 0xee, 0xff},
+		Background: Colour{0, 0, 0, 0xff},
+	}
+}
+
+func TestHTMLRenderer(t *testing.T) {
+	buf := text.NewBuffer()
+	buf.Insert(0, "if a<b\nx")
+
+	vrm := make(ViewRegionMap)
+	add := func(key, scope string, flags ViewRegionFlags, rs ...text.Region) {
+		vr := ViewRegions{Scope: scope, Flags: flags}
+		vr.Regions.AddAll(rs)
+		vrm[key] = vr
+	}
+	add("kw", "keyword", DRAW_TEXT, text.Region{A: 0, B: 2})
+	add("src", "source", DRAW_TEXT, text.Region{A: 2, B: 6})
+	add("sel", "selection", SELECTION, text.Region{A: 1, B: 4})
+	recipe := TransformFlags(htmlColourScheme{}, vrm, text.Region{A: 0, B: buf.Size()})
+
+	var out bytes.Buffer
+	r := &HTMLRenderer{
+		W:           &out,
+		Buffer:      buf,
+		Scheme:      htmlColourScheme{},
+		Title:       "a<b",
+		LineNumbers: true,
+	}
+	r.Render(recipe)
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `<pre><span class="ln">1 </span><span style="color: #ff0000; font-weight: bold">if</span> a&lt;b
+<span class="ln">2 </span>x</pre>`
+	if got := out.String(); !strings.Contains(got, exp) {
+		t.Errorf("Expected the output to contain\n%s\nbut got\n%s", exp, got)
+	}
+	for _, exp := range []string{"<title>a&lt;b</title>", "background-color: #000000; color: #eeeeee;"} {
+		if got := out.String(); !strings.Contains(got, exp) {
+			t.Errorf("Expected the output to contain %q, but got\n%s", exp, got)
+		}
+	}
+}
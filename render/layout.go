@@ -0,0 +1,359 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"sort"
+	"unicode"
+
+	"github.com/limetext/text"
+	"github.com/limetext/util"
+)
+
+type (
+	// The LayoutSettings control how text is laid out into visual lines.
+	LayoutSettings struct {
+		// The number of columns between tab stops
+		TabSize int
+		// Whether lines wider than WrapWidth are wrapped
+		WordWrap bool
+		// The width at which lines are wrapped
+		WrapWidth int
+		// When set, the width of the text is measured with Metrics
+		// in Font, for proportional fonts. Otherwise each character
+		// is one column wide, or two for wide east asian characters.
+		Metrics FontMetrics
+		Font    Font
+		// Regions of the text which are folded, i.e hidden
+		// and shown as FoldPlaceholder
+		Folds           []text.Region
+		FoldPlaceholder string
+		// The phantoms shown with the text, except for those inside of folds
+		Phantoms []Phantom
+	}
+
+	// A Cell is a part of a visual line showing one character,
+	// together with any combining characters following it, a
+	// folded region or a phantom.
+	Cell struct {
+		// The Region of the buffer shown by this Cell
+		Region text.Region
+		// The first character shown, 0 for folded regions and phantoms
+		Rune rune
+		// The offset from the start of the visual line and the
+		// width of this cell, in columns or as measured by the
+		// FontMetrics
+		X, Width int
+		// Whether the cell is the placeholder of a folded region
+		Fold bool
+		// The phantom shown by the cell, whose Region is then
+		// the empty region of the point it's shown at
+		Phantom *Phantom
+	}
+
+	// A VisualLine is one row of laid out text.
+	VisualLine struct {
+		// The Region of the buffer shown on this line,
+		// not including the line ending
+		Region text.Region
+		Cells  []Cell
+		Width  int
+		// Whether the line is the continuation of a wrapped line
+		Wrapped bool
+		// The PHANTOM_BELOW phantom shown by this line, which then
+		// has no cells and the empty region of the end of the line
+		// above it
+		Phantom *Phantom
+	}
+
+	// A Layout is the text of a viewport laid out into visual lines.
+	// The first line is the one containing the start of the viewport,
+	// and rows are numbered from it.
+	Layout struct {
+		Settings LayoutSettings
+		Lines    []VisualLine
+	}
+
+	layoutBuilder struct {
+		*Layout
+		cur   VisualLine
+		space int
+		// The phantoms by the point they're laid out at
+		phantoms map[int][]*Phantom
+	}
+)
+
+// Wide east asian characters, which take up two columns
+var wideRanges = []struct{ lo, hi rune }{
+	{0x1100, 0x115F},
+	{0x2E80, 0x303E},
+	{0x3041, 0x33FF},
+	{0x3400, 0x4DBF},
+	{0x4E00, 0x9FFF},
+	{0xA000, 0xA4CF},
+	{0xAC00, 0xD7A3},
+	{0xF900, 0xFAFF},
+	{0xFE30, 0xFE4F},
+	{0xFF00, 0xFF60},
+	{0xFFE0, 0xFFE6},
+	{0x1F300, 0x1F64F},
+	{0x1F900, 0x1F9FF},
+	{0x20000, 0x2FFFD},
+	{0x30000, 0x3FFFD},
+}
+
+// Returns whether r is combined with the character before it.
+func isCombining(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me) || r == 0x200D
+}
+
+// Returns the number of columns r takes up.
+func runeWidth(r rune) int {
+	i := sort.Search(len(wideRanges), func(i int) bool {
+		return wideRanges[i].hi >= r
+	})
+	if i < len(wideRanges) && wideRanges[i].lo <= r {
+		return 2
+	}
+	return 1
+}
+
+// NewLayout lays out the lines of buf touched by viewport. Lines are
+// laid out in full, and the viewport is extended to include the whole
+// of any fold it overlaps.
+func NewLayout(buf text.Buffer, viewport text.Region, s LayoutSettings) *Layout {
+	pe := util.Prof.Enter("render.NewLayout")
+	defer pe.Exit()
+
+	if s.TabSize <= 0 {
+		s.TabSize = 4
+	}
+	if s.FoldPlaceholder == "" {
+		s.FoldPlaceholder = "…"
+	}
+	var folds []text.Region
+	for _, f := range s.Folds {
+		if !f.Empty() {
+			folds = append(folds, text.Region{A: f.Begin(), B: f.End()})
+		}
+	}
+	sort.Slice(folds, func(i, j int) bool {
+		return folds[i].A < folds[j].A
+	})
+
+	a, b := buf.Line(viewport.Begin()).Begin(), buf.Line(viewport.End()).End()
+	for _, f := range folds {
+		if f.A < a && f.B >= a {
+			a = buf.Line(f.A).Begin()
+		}
+		if f.A < b && f.B > b {
+			b = buf.Line(f.B).End()
+		}
+	}
+	data := buf.SubstrR(text.Region{A: a, B: b})
+
+	lb := &layoutBuilder{
+		Layout:   &Layout{Settings: s},
+		cur:      VisualLine{Region: text.Region{A: a, B: a}},
+		phantoms: make(map[int][]*Phantom),
+	}
+	lb.space = lb.measure([]rune{' '})
+	for i := range lb.Settings.Phantoms {
+		ph := &lb.Settings.Phantoms[i]
+		p := ph.Point(buf)
+		lb.phantoms[p] = append(lb.phantoms[p], ph)
+	}
+	for p := a; p < b; {
+		if len(folds) > 0 && folds[0].B <= p {
+			folds = folds[1:]
+			continue
+		}
+		lb.addPhantoms(p, PHANTOM_INLINE)
+		if len(folds) > 0 && folds[0].A == p {
+			f := folds[0]
+			lb.add(Cell{Region: f, Fold: true})
+			p = f.B
+			continue
+		}
+		r := data[p-a]
+		if r == '\n' {
+			lb.endLine(p)
+			lb.newLine(p+1, false)
+			p++
+			continue
+		}
+		if l := len(lb.cur.Cells); l > 0 && isCombining(r) {
+			lb.cur.Cells[l-1].Region.B = p + 1
+			lb.cur.Region.B = p + 1
+		} else {
+			lb.add(Cell{Region: text.Region{A: p, B: p + 1}, Rune: r})
+		}
+		p++
+	}
+	lb.addPhantoms(b, PHANTOM_INLINE)
+	lb.endLine(b)
+	lb.Lines = append(lb.Lines, lb.cur)
+	return lb.Layout
+}
+
+// Adds the cells of the phantoms of the given layout at point.
+func (lb *layoutBuilder) addPhantoms(point int, layout PhantomLayout) {
+	for _, ph := range lb.phantoms[point] {
+		if ph.Layout == layout {
+			lb.add(Cell{Region: text.Region{A: point, B: point}, Phantom: ph})
+		}
+	}
+}
+
+// Adds the phantoms at the end of the line ending at point, with
+// a line for each of the phantoms shown below it.
+func (lb *layoutBuilder) endLine(point int) {
+	lb.addPhantoms(point, PHANTOM_END_OF_LINE)
+	for _, ph := range lb.phantoms[point] {
+		if ph.Layout == PHANTOM_BELOW {
+			lb.newLine(point, false)
+			lb.cur.Phantom = ph
+			lb.cur.Width = lb.measure([]rune(ph.Content))
+		}
+	}
+}
+
+func (lb *layoutBuilder) measure(rs []rune) int {
+	if m := lb.Settings.Metrics; m != nil {
+		return m.Measure(lb.Settings.Font, rs).Width
+	}
+	w := 0
+	for _, r := range rs {
+		if !isCombining(r) {
+			w += runeWidth(r)
+		}
+	}
+	return w
+}
+
+// Returns the width of c when placed at x.
+func (lb *layoutBuilder) width(c Cell, x int) int {
+	switch {
+	case c.Fold:
+		return lb.measure([]rune(lb.Settings.FoldPlaceholder))
+	case c.Phantom != nil:
+		return lb.measure([]rune(c.Phantom.Content))
+	case c.Rune == '\t':
+		stop := lb.Settings.TabSize * lb.space
+		return stop - x%stop
+	default:
+		return lb.measure([]rune{c.Rune})
+	}
+}
+
+// Adds c to the current line, wrapping the line first if c doesn't fit.
+func (lb *layoutBuilder) add(c Cell) {
+	c.X = lb.cur.Width
+	c.Width = lb.width(c, c.X)
+	if s := lb.Settings; s.WordWrap && s.WrapWidth > 0 && c.X+c.Width > s.WrapWidth && len(lb.cur.Cells) > 0 && !unicode.IsSpace(c.Rune) {
+		lb.wrap(c)
+		c.X = lb.cur.Width
+		c.Width = lb.width(c, c.X)
+	}
+	lb.cur.Cells = append(lb.cur.Cells, c)
+	lb.cur.Width += c.Width
+	lb.cur.Region.B = c.Region.B
+}
+
+// Wraps the current line at the start of the word next belongs to,
+// where next is the cell which didn't fit on the line. Words wider
+// than the line are broken right before next.
+func (lb *layoutBuilder) wrap(next Cell) {
+	cells := lb.cur.Cells
+	i := len(cells)
+	for j := len(cells); j > 0; j-- {
+		c := next
+		if j < len(cells) {
+			c = cells[j]
+		}
+		if unicode.IsSpace(cells[j-1].Rune) && !unicode.IsSpace(c.Rune) {
+			i = j
+			break
+		}
+	}
+	rest := append([]Cell(nil), cells[i:]...)
+	lb.cur.Cells = cells[:i]
+	lb.cur.Width = 0
+	if i > 0 {
+		c := cells[i-1]
+		lb.cur.Width = c.X + c.Width
+	}
+	start := lb.cur.Region.B
+	if len(rest) > 0 {
+		start = rest[0].Region.A
+		lb.cur.Region.B = start
+	}
+	lb.newLine(start, true)
+	for _, c := range rest {
+		lb.add(c)
+	}
+}
+
+// Ends the current line, starting a new one at point.
+func (lb *layoutBuilder) newLine(point int, wrapped bool) {
+	lb.Lines = append(lb.Lines, lb.cur)
+	lb.cur = VisualLine{Region: text.Region{A: point, B: point}, Wrapped: wrapped}
+}
+
+// Returns the visual row and column, or x offset when using FontMetrics,
+// of point. A point inside of a folded region is placed at the fold's
+// placeholder, and a point at which a line is wrapped is placed at the
+// start of the following row. Points are placed after inline phantoms
+// shown at them, but before the end of line phantoms of their line.
+//
+// ok is false if the point isn't inside of the layout.
+func (l *Layout) PointToVisual(point int) (row, col int, ok bool) {
+	for i, line := range l.Lines {
+		if point < line.Region.A {
+			break
+		}
+		if point > line.Region.B {
+			continue
+		}
+		if point == line.Region.B && i+1 < len(l.Lines) && l.Lines[i+1].Wrapped {
+			continue
+		}
+		for _, c := range line.Cells {
+			if point >= c.Region.A && point < c.Region.B {
+				return i, c.X, true
+			}
+		}
+		for _, c := range line.Cells {
+			if c.Phantom != nil && c.Phantom.Layout == PHANTOM_END_OF_LINE {
+				return i, c.X, true
+			}
+		}
+		return i, line.Width, true
+	}
+	return 0, 0, false
+}
+
+// Returns the buffer point shown at the visual row and column. Rows
+// and columns outside of the layout are clamped to its bounds.
+func (l *Layout) VisualToPoint(row, col int) int {
+	if len(l.Lines) == 0 {
+		return 0
+	}
+	if row < 0 {
+		row = 0
+	} else if row >= len(l.Lines) {
+		row = len(l.Lines) - 1
+	}
+	line := l.Lines[row]
+	for _, c := range line.Cells {
+		if col < c.X+c.Width {
+			if c.Fold || col < c.X+(c.Width+1)/2 {
+				return c.Region.A
+			}
+			return c.Region.B
+		}
+	}
+	return line.Region.B
+}
@@ -0,0 +1,254 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/limetext/text"
+)
+
+type dummyMetrics struct{}
+
+// Every character is 10 wide, and 'i' is 4 wide
+func (m dummyMetrics) Measure(f Font, rs []rune) (ret FontMeasurement) {
+	for _, r := range rs {
+		if r == 'i' {
+			ret.Width += 4
+		} else {
+			ret.Width += 10
+		}
+	}
+	ret.Height = 12
+	return
+}
+
+func newLayoutBuffer(data string) text.Buffer {
+	buf := text.NewBuffer()
+	buf.Insert(0, data)
+	return buf
+}
+
+func TestLayoutLines(t *testing.T) {
+	tests := []struct {
+		data     string
+		settings LayoutSettings
+		exp      []text.Region
+		widths   []int
+	}{
+		{
+			"ab\ncd",
+			LayoutSettings{},
+			[]text.Region{{A: 0, B: 2}, {A: 3, B: 5}},
+			[]int{2, 2},
+		},
+		{
+			"a\tb",
+			LayoutSettings{TabSize: 4},
+			[]text.Region{{A: 0, B: 3}},
+			[]int{5},
+		},
+		{
+			"the quick brown fox",
+			LayoutSettings{WordWrap: true, WrapWidth: 10},
+			[]text.Region{{A: 0, B: 10}, {A: 10, B: 19}},
+			[]int{10, 9},
+		},
+		{
+			"abcdefgh",
+			LayoutSettings{WordWrap: true, WrapWidth: 3},
+			[]text.Region{{A: 0, B: 3}, {A: 3, B: 6}, {A: 6, B: 8}},
+			[]int{3, 3, 2},
+		},
+		{
+			"a世界b",
+			LayoutSettings{},
+			[]text.Region{{A: 0, B: 4}},
+			[]int{6},
+		},
+		{
+			"éx",
+			LayoutSettings{},
+			[]text.Region{{A: 0, B: 3}},
+			[]int{2},
+		},
+		{
+			"a{\nb\n}c\nd",
+			LayoutSettings{Folds: []text.Region{{A: 2, B: 5}}},
+			[]text.Region{{A: 0, B: 7}, {A: 8, B: 9}},
+			[]int{5, 1},
+		},
+		{
+			"ii a",
+			LayoutSettings{Metrics: dummyMetrics{}},
+			[]text.Region{{A: 0, B: 4}},
+			[]int{28},
+		},
+	}
+
+	for i, test := range tests {
+		buf := newLayoutBuffer(test.data)
+		l := NewLayout(buf, text.Region{A: 0, B: buf.Size()}, test.settings)
+		var got []text.Region
+		var widths []int
+		for _, line := range l.Lines {
+			got = append(got, line.Region)
+			widths = append(widths, line.Width)
+		}
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("Test %d: Expected lines %v, but got %v", i, test.exp, got)
+		}
+		if !reflect.DeepEqual(widths, test.widths) {
+			t.Errorf("Test %d: Expected widths %v, but got %v", i, test.widths, widths)
+		}
+	}
+}
+
+func TestLayoutViewport(t *testing.T) {
+	buf := newLayoutBuffer("one\ntwo\nthree\nfour")
+	l := NewLayout(buf, text.Region{A: 5, B: 10}, LayoutSettings{})
+	exp := []text.Region{{A: 4, B: 7}, {A: 8, B: 13}}
+	var got []text.Region
+	for _, line := range l.Lines {
+		got = append(got, line.Region)
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected lines %v, but got %v", exp, got)
+	}
+}
+
+func TestLayoutMapping(t *testing.T) {
+	buf := newLayoutBuffer("a\tbc d{x}\nef")
+	l := NewLayout(buf, text.Region{A: 0, B: buf.Size()}, LayoutSettings{
+		TabSize:   4,
+		WordWrap:  true,
+		WrapWidth: 8,
+		Folds:     []text.Region{{A: 7, B: 8}},
+	})
+
+	tests := []struct {
+		point    int
+		row, col int
+	}{
+		{0, 0, 0},
+		{1, 0, 1},
+		{2, 0, 4},
+		{5, 1, 0},
+		{7, 1, 2},
+		{8, 1, 3},
+		{9, 1, 4},
+		{10, 2, 0},
+		{12, 2, 2},
+	}
+	for i, test := range tests {
+		row, col, ok := l.PointToVisual(test.point)
+		if !ok || row != test.row || col != test.col {
+			t.Errorf("Test %d: Expected %d to be at %d:%d, but got %d:%d %v", i, test.point, test.row, test.col, row, col, ok)
+		}
+		if p := l.VisualToPoint(test.row, test.col); p != test.point {
+			t.Errorf("Test %d: Expected %d:%d to be %d, but got %d", i, test.row, test.col, test.point, p)
+		}
+	}
+	if p := l.VisualToPoint(0, 2); p != 1 {
+		t.Errorf("Expected the left half of a tab to be its start, but got %d", p)
+	}
+	if p := l.VisualToPoint(5, 100); p != buf.Size() {
+		t.Errorf("Expected the end of the buffer, but got %d", p)
+	}
+	if _, _, ok := l.PointToVisual(100); ok {
+		t.Error("Expected a point outside of the layout not to be found")
+	}
+}
+
+func TestLayoutPhantoms(t *testing.T) {
+	buf := newLayoutBuffer("ab\ncd\nef")
+	l := NewLayout(buf, text.Region{A: 0, B: buf.Size()}, LayoutSettings{
+		Folds: []text.Region{{A: 6, B: 8}},
+		Phantoms: []Phantom{
+			{Id: 1, Region: text.Region{A: 1, B: 2}, Content: "xy", Layout: PHANTOM_INLINE},
+			{Id: 2, Region: text.Region{A: 0, B: 0}, Content: "!!", Layout: PHANTOM_END_OF_LINE},
+			{Id: 3, Region: text.Region{A: 4, B: 5}, Content: "below", Layout: PHANTOM_BELOW},
+			{Id: 4, Region: text.Region{A: 7, B: 7}, Content: "folded", Layout: PHANTOM_INLINE},
+		},
+	})
+
+	type cell struct {
+		region   text.Region
+		x, width int
+		phantom  int
+	}
+	exp := []struct {
+		region  text.Region
+		width   int
+		phantom int
+		cells   []cell
+	}{
+		{text.Region{A: 0, B: 2}, 6, 0, []cell{{text.Region{A: 0, B: 1}, 0, 1, 0}, {text.Region{A: 1, B: 1}, 1, 2, 1}, {text.Region{A: 1, B: 2}, 3, 1, 0}, {text.Region{A: 2, B: 2}, 4, 2, 2}}},
+		{text.Region{A: 3, B: 5}, 2, 0, []cell{{text.Region{A: 3, B: 4}, 0, 1, 0}, {text.Region{A: 4, B: 5}, 1, 1, 0}}},
+		{text.Region{A: 5, B: 5}, 5, 3, nil},
+		{text.Region{A: 6, B: 8}, 1, 0, []cell{{text.Region{A: 6, B: 8}, 0, 1, 0}}},
+	}
+	if len(l.Lines) != len(exp) {
+		t.Fatalf("Expected %d lines, but got %d", len(exp), len(l.Lines))
+	}
+	for i, e := range exp {
+		line := l.Lines[i]
+		id := 0
+		if line.Phantom != nil {
+			id = line.Phantom.Id
+		}
+		if line.Region != e.region || line.Width != e.width || id != e.phantom {
+			t.Errorf("Line %d: Expected %v, width %d and phantom %d, but got %v, %d and %d", i, e.region, e.width, e.phantom, line.Region, line.Width, id)
+		}
+		var cells []cell
+		for _, c := range line.Cells {
+			id := 0
+			if c.Phantom != nil {
+				id = c.Phantom.Id
+			}
+			cells = append(cells, cell{c.Region, c.X, c.Width, id})
+		}
+		if !reflect.DeepEqual(cells, e.cells) {
+			t.Errorf("Line %d: Expected cells %v, but got %v", i, e.cells, cells)
+		}
+	}
+
+	tests := []struct {
+		point, row, col int
+	}{
+		{1, 0, 3},
+		{2, 0, 4},
+		{5, 1, 2},
+		{6, 3, 0},
+	}
+	for i, test := range tests {
+		if row, col, ok := l.PointToVisual(test.point); !ok || row != test.row || col != test.col {
+			t.Errorf("Test %d: Expected %d to be at %d:%d, but got %d:%d", i, test.point, test.row, test.col, row, col)
+		}
+	}
+
+	// The end of a line is after the inline phantoms shown
+	// there, but before the end of line phantoms
+	buf = newLayoutBuffer("ab\ncd")
+	l = NewLayout(buf, text.Region{A: 0, B: buf.Size()}, LayoutSettings{
+		Phantoms: []Phantom{
+			{Id: 1, Region: text.Region{A: 2, B: 2}, Content: ": int", Layout: PHANTOM_INLINE},
+			{Id: 2, Region: text.Region{A: 0, B: 0}, Content: "!!", Layout: PHANTOM_END_OF_LINE},
+			{Id: 3, Region: text.Region{A: 5, B: 5}, Content: "xy", Layout: PHANTOM_INLINE},
+		},
+	})
+	tests = []struct {
+		point, row, col int
+	}{
+		{2, 0, 7},
+		{5, 1, 4},
+	}
+	for i, test := range tests {
+		if row, col, ok := l.PointToVisual(test.point); !ok || row != test.row || col != test.col {
+			t.Errorf("Test %d: Expected %d to be at %d:%d, but got %d:%d", i, test.point, test.row, test.col, row, col)
+		}
+	}
+}
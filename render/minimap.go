@@ -0,0 +1,188 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/limetext/text"
+	"github.com/limetext/util"
+)
+
+const (
+	MINIMAP_SELECTION MinimapMarkers = (1 << iota) // A selection is in the bucket
+	MINIMAP_SEARCH                                 // A search result, i.e a HIGHLIGHT region, is in the bucket
+	MINIMAP_ERROR                                  // A region with an "invalid" or "error" scope is in the bucket
+	MINIMAP_CHANGE                                 // A line of the bucket changed since it was saved
+)
+
+// The maximum number of colours of a MinimapBucket
+const maxBucketColours = 4
+
+type (
+	// The kinds of markers shown on a MinimapBucket
+	MinimapMarkers int
+
+	// A MinimapColour is a foreground colour of the text of a
+	// MinimapBucket, and the number of characters having it.
+	MinimapColour struct {
+		Colour Colour
+		Weight int
+	}
+
+	// A MinimapBucket is a group of consecutive
+	// lines, shown as one row of the minimap.
+	MinimapBucket struct {
+		// The first line of the bucket and the line after its last
+		// line, starting at 0
+		Lines text.Region
+		// The Region of the buffer the lines span
+		Region text.Region
+		// The dominant foreground colours of the text, most common first
+		Colours []MinimapColour
+		Markers MinimapMarkers
+		// The change of the first changed line of the bucket,
+		// LINE_UNCHANGED if there's none
+		Change LineChange
+	}
+
+	// A Minimap is a downscaled overview of a whole Buffer,
+	// meant for minimaps and overview rulers.
+	Minimap struct {
+		LinesPerBucket int
+		Buckets        []MinimapBucket
+	}
+)
+
+// NewMinimap creates a Minimap of buf with at most the given number of
+// buckets, which would typically be the height of the minimap in pixels.
+//
+// The colours of the text are those of the DRAW_TEXT RenderUnits of recipe,
+// which should be a Recipe of the whole Buffer, and the Foreground of gs for
+// the text outside of them. The markers come from the
+// SELECTION and HIGHLIGHT flags, and the scopes, of regions, which aren't
+// modified. Regions and RenderUnits with the HIDDEN or HIDE_ON_MINIMAP
+// flags are left out.
+// changes contains the LineChange of each line of buf, as returned by
+// DiffLines, and may be nil.
+func NewMinimap(buf text.Buffer, recipe Recipe, gs Settings, regions []*ViewRegions, changes []LineChange, buckets int) *Minimap {
+	pe := util.Prof.Enter("render.NewMinimap")
+	defer pe.Exit()
+
+	last, _ := buf.RowCol(buf.Size())
+	lines := last + 1
+	if buckets <= 0 {
+		buckets = 1
+	}
+	m := &Minimap{LinesPerBucket: (lines + buckets - 1) / buckets}
+	for l := 0; l < lines; l += m.LinesPerBucket {
+		b := MinimapBucket{Lines: text.Region{A: l, B: l + m.LinesPerBucket}}
+		if b.Lines.B > lines {
+			b.Lines.B = lines
+		}
+		b.Region = text.Region{A: buf.TextPoint(l, 0), B: buf.Line(buf.TextPoint(b.Lines.B-1, 0)).End()}
+		for _, c := range changes[min(l, len(changes)):min(b.Lines.B, len(changes))] {
+			if c != LINE_UNCHANGED {
+				b.Markers |= MINIMAP_CHANGE
+				b.Change = c
+				break
+			}
+		}
+		m.Buckets = append(m.Buckets, b)
+	}
+	bucket := func(point int) *MinimapBucket {
+		row, _ := buf.RowCol(point)
+		return &m.Buckets[min(row/m.LinesPerBucket, len(m.Buckets)-1)]
+	}
+
+	weights := make([]map[Colour]int, len(m.Buckets))
+	for _, ru := range textUnits(recipe, buf.Size()) {
+		if ru.Flavour.Flags&(HIDDEN|HIDE_ON_MINIMAP) != 0 {
+			continue
+		}
+		fg := ru.Flavour.Foreground
+		// The text between the units isn't highlighted
+		if ru.Flavour.Flags&DRAW_TEXT == 0 {
+			fg = gs.Foreground
+		}
+		row, _ := buf.RowCol(ru.Region.Begin())
+		for _, r := range buf.Substr(ru.Region) {
+			if r == '\n' {
+				row++
+				continue
+			}
+			if unicode.IsSpace(r) {
+				continue
+			}
+			i := row / m.LinesPerBucket
+			if weights[i] == nil {
+				weights[i] = make(map[Colour]int)
+			}
+			weights[i][fg]++
+		}
+	}
+	for i, w := range weights {
+		b := &m.Buckets[i]
+		for c, n := range w {
+			b.Colours = append(b.Colours, MinimapColour{Colour: c, Weight: n})
+		}
+		sort.Slice(b.Colours, func(i, j int) bool {
+			a, c := b.Colours[i], b.Colours[j]
+			if a.Weight == c.Weight {
+				return a.Colour.String() < c.Colour.String()
+			}
+			return a.Weight > c.Weight
+		})
+		if len(b.Colours) > maxBucketColours {
+			b.Colours = b.Colours[:maxBucketColours]
+		}
+	}
+
+	for i := range regions {
+		vr := regions[i]
+		if vr.Flags&(HIDDEN|HIDE_ON_MINIMAP) != 0 {
+			continue
+		}
+		marker := minimapMarker(vr)
+		if marker == 0 {
+			continue
+		}
+		for _, r := range vr.Regions.Regions() {
+			first, last := bucket(r.Begin()), bucket(r.End())
+			for b := first; ; b = &m.Buckets[b.Lines.A/m.LinesPerBucket+1] {
+				b.Markers |= marker
+				if b == last {
+					break
+				}
+			}
+		}
+	}
+	return m
+}
+
+// Returns the marker the regions of vr are shown with, or 0 if none.
+func minimapMarker(vr *ViewRegions) MinimapMarkers {
+	switch {
+	case vr.Flags&SELECTION != 0:
+		return MINIMAP_SELECTION
+	case vr.Flags&HIGHLIGHT != 0:
+		return MINIMAP_SEARCH
+	}
+	for _, s := range strings.FieldsFunc(vr.Scope, func(r rune) bool { return r == ' ' || r == '.' }) {
+		if s == "invalid" || s == "error" {
+			return MINIMAP_ERROR
+		}
+	}
+	return 0
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
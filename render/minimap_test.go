@@ -0,0 +1,73 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/limetext/text"
+)
+
+func TestNewMinimap(t *testing.T) {
+	buf := text.NewBuffer()
+	buf.Insert(0, "a\nbb\nc c\nd\ne\nf")
+
+	var (
+		red  = Colour{255, 0, 0, 255}
+		blue = Colour{0, 0, 255, 255}
+		grey = Colour{128, 128, 128, 255}
+	)
+	regions := func(rs ...text.Region) (ret text.RegionSet) {
+		ret.AddAll(rs)
+		return
+	}
+	recipe := Recipe{
+		{Foreground: red, Flags: DRAW_TEXT}:                    regions(text.Region{A: 2, B: 4}, text.Region{A: 5, B: 6}),
+		{Foreground: blue, Flags: DRAW_TEXT}:                   regions(text.Region{A: 6, B: 8}),
+		{Foreground: blue, Flags: DRAW_TEXT | HIDE_ON_MINIMAP}: regions(text.Region{A: 0, B: 1}),
+	}
+	vrs := []*ViewRegions{
+		{Regions: regions(text.Region{A: 11, B: 11}), Flags: SELECTION},
+		{Regions: regions(text.Region{A: 0, B: 1}), Flags: HIGHLIGHT},
+		{Regions: regions(text.Region{A: 3, B: 9}), Scope: "sublimelinter.mark.error"},
+		{Regions: regions(text.Region{A: 13, B: 14}), Scope: "invalid.illegal", Flags: HIDE_ON_MINIMAP},
+		{Regions: regions(text.Region{A: 13, B: 14}), Scope: "comment"},
+	}
+	changes := []LineChange{LINE_UNCHANGED, LINE_UNCHANGED, LINE_UNCHANGED, LINE_MODIFIED, LINE_UNCHANGED, LINE_UNCHANGED}
+
+	m := NewMinimap(buf, recipe, Settings{Foreground: grey}, vrs, changes, 3)
+	exp := &Minimap{
+		LinesPerBucket: 2,
+		Buckets: []MinimapBucket{
+			{
+				Lines:   text.Region{A: 0, B: 2},
+				Region:  text.Region{A: 0, B: 4},
+				Colours: []MinimapColour{{red, 2}},
+				Markers: MINIMAP_SEARCH | MINIMAP_ERROR,
+			},
+			{
+				Lines:   text.Region{A: 2, B: 4},
+				Region:  text.Region{A: 5, B: 10},
+				Colours: []MinimapColour{{blue, 1}, {grey, 1}, {red, 1}},
+				Markers: MINIMAP_ERROR | MINIMAP_CHANGE,
+				Change:  LINE_MODIFIED,
+			},
+			{
+				Lines:   text.Region{A: 4, B: 6},
+				Region:  text.Region{A: 11, B: 14},
+				Colours: []MinimapColour{{grey, 2}},
+				Markers: MINIMAP_SELECTION,
+			},
+		},
+	}
+	if !reflect.DeepEqual(m, exp) {
+		t.Errorf("Expected %+v, but got %+v", exp, m)
+	}
+
+	if m := NewMinimap(buf, nil, Settings{}, nil, nil, 100); m.LinesPerBucket != 1 || len(m.Buckets) != 6 {
+		t.Errorf("Expected 6 buckets of one line, but got %d of %d", len(m.Buckets), m.LinesPerBucket)
+	}
+}
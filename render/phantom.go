@@ -0,0 +1,41 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package render
+
+import "github.com/limetext/text"
+
+const (
+	PHANTOM_INLINE      PhantomLayout = iota // Shown in the text, right before the start of its region
+	PHANTOM_BELOW                            // Shown as a block below the line its region starts on
+	PHANTOM_END_OF_LINE                      // Shown after the end of the line its region starts on
+)
+
+type (
+	// How a Phantom is laid out together with the text.
+	PhantomLayout int
+
+	// A Phantom is virtual text shown with the text of a buffer, without
+	// being part of it, e.g lint messages, inlay type hints or blame info.
+	Phantom struct {
+		// The id returned when the phantom was added
+		Id int
+		// The key the phantom was added with, which
+		// all of the phantoms of one kind share
+		Key string
+		// The Region the phantom annotates
+		Region text.Region
+		// The virtual text
+		Content string
+		Layout  PhantomLayout
+	}
+)
+
+// Returns the point the phantom is shown at in buf.
+func (p *Phantom) Point(buf text.Buffer) int {
+	if p.Layout == PHANTOM_INLINE {
+		return p.Region.Begin()
+	}
+	return buf.Line(p.Region.Begin()).End()
+}
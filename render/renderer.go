@@ -45,6 +45,34 @@ type (
 		GlobalSettings() Settings
 	}
 
+	// A Rule describes the rule of a ColourScheme
+	// which determines how a scope is styled.
+	Rule struct {
+		// The name of the rule, as given in the colour scheme
+		Name string
+		// The scope selector the rule applies to
+		Selector string
+	}
+
+	// The RuleMatcher interface can be optionally implemented by a
+	// ColourScheme, making it possible to find out why text is coloured
+	// the way it is.
+	RuleMatcher interface {
+		// Returns the rule which Spice uses for the given scope,
+		// or false if the global settings are used.
+		MatchRule(scope string) (Rule, bool)
+	}
+
+	// The VersionedColourScheme interface can be optionally implemented
+	// by a ColourScheme which changes in place, e.g when it's reloaded
+	// from its file. The Flavours Spice memoized for another version of
+	// the scheme aren't used.
+	VersionedColourScheme interface {
+		ColourScheme
+		// Returns a number which changes whenever the scheme does
+		Version() int
+	}
+
 	Renderer interface {
 		// Renders the given Recipe
 		Render(Recipe)
@@ -58,6 +86,7 @@ type (
 //
 // The remaining ViewRegions are then passed on to the ColourScheme for determining the exact Flavour
 // for which that RegionSet should be styled, adding Regions of the same Flavour to the same RegionSet.
+// The Flavours are memoized, see Spice.
 //
 // Typically there are more ViewRegions available in a text buffer than there are unique Flavours in
 // a ColourScheme, so this operation can be viewed as reducing the number of state changes required to
@@ -66,13 +95,29 @@ type (
 // The final output, the Recipe, contains a mapping of all unique Flavours and that Flavour's
 // associated RegionSet.
 func Transform(scheme ColourScheme, data ViewRegionMap, viewport text.Region) Recipe {
+	return transform(scheme, data, viewport, false)
+}
+
+// TransformFlags is the same as Transform, except that the Flags of the
+// ViewRegions are kept in the Flavours of the Recipe, so that e.g text and
+// selections of the same colours are told apart rather than merged.
+// Renderers telling the text from the rest, such as the HTMLRenderer and
+// the ANSIRenderer, need a Recipe created by TransformFlags.
+func TransformFlags(scheme ColourScheme, data ViewRegionMap, viewport text.Region) Recipe {
+	return transform(scheme, data, viewport, true)
+}
+
+func transform(scheme ColourScheme, data ViewRegionMap, viewport text.Region, flags bool) Recipe {
 	pe := util.Prof.Enter("render.Transform")
 	defer pe.Exit()
 
 	data.Cull(viewport)
 	recipe := make(Recipe)
 	for _, v := range data {
-		k := scheme.Spice(&v)
+		k := Spice(scheme, &v)
+		if flags {
+			k.Flags |= v.Flags
+		}
 		rs := recipe[k]
 		a := util.Prof.Enter("render.Transform.(Regions)")
 		r := v.Regions.Regions()
@@ -100,6 +145,27 @@ func (r Recipe) Transcribe() (ret TranscribedRecipe) {
 	return
 }
 
+// Returns the units of recipe with the DRAW_TEXT flag, with the gaps
+// between them, and up to size, filled in with units of the zero Flavour.
+func textUnits(recipe Recipe, size int) (ret TranscribedRecipe) {
+	p := 0
+	for _, ru := range recipe.Transcribe() {
+		if ru.Flavour.Flags&DRAW_TEXT == 0 || ru.Region.End() <= p {
+			continue
+		}
+		if a := ru.Region.Begin(); a > p {
+			ret = append(ret, RenderUnit{Region: text.Region{A: p, B: a}})
+			p = a
+		}
+		ret = append(ret, RenderUnit{Flavour: ru.Flavour, Region: text.Region{A: p, B: ru.Region.End()}})
+		p = ru.Region.End()
+	}
+	if p < size {
+		ret = append(ret, RenderUnit{Region: text.Region{A: p, B: size}})
+	}
+	return
+}
+
 // Just used to satisfy the sort.Interface interface, typically not used otherwise.
 func (r *TranscribedRecipe) Len() int {
 	return len(*r)
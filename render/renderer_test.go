@@ -113,6 +113,31 @@ func TestTransform(t *testing.T) {
 	}
 }
 
+func TestTransformFlags(t *testing.T) {
+	newMap := func() ViewRegionMap {
+		vrm := make(ViewRegionMap)
+		txt, sel := ViewRegions{Scope: "A", Flags: DRAW_TEXT}, ViewRegions{Scope: "A", Flags: SELECTION}
+		txt.Regions.Add(text.Region{A: 0, B: 5})
+		sel.Regions.Add(text.Region{A: 5, B: 10})
+		vrm["text"], vrm["sel"] = txt, sel
+		return vrm
+	}
+	viewport := text.Region{A: 0, B: 10}
+
+	// Regions of the same colours are merged, whatever their flags
+	rec := Transform(dummyColourScheme{}, newMap(), viewport)
+	if rs := rec[flavourA]; len(rec) != 1 || rs.Len() != 2 {
+		t.Errorf("Expected the regions to be merged into %v, but got %v", flavourA, rec)
+	}
+
+	rec = TransformFlags(dummyColourScheme{}, newMap(), viewport)
+	ft, fs := flavourA, flavourA
+	ft.Flags, fs.Flags = DRAW_TEXT, SELECTION
+	if rt, rs := rec[ft], rec[fs]; len(rec) != 2 || rt.Len() != 1 || rs.Len() != 1 {
+		t.Errorf("Expected the text and the selection to be kept apart, but got %v", rec)
+	}
+}
+
 func TestRecipeTranscribe(t *testing.T) {
 	tests := []struct {
 		rec  Recipe
@@ -36,6 +36,7 @@ type Settings struct {
 	GutterForeground        Colour
 	Selection               Colour
 	SelectionBackground     Colour
+	SelectionForeground     Colour
 	SelectionBorder         Colour
 	InactiveSelection       Colour
 	Guide                   Colour
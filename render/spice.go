@@ -0,0 +1,102 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/limetext/util"
+)
+
+// Above this many memoized Flavours of a ColourScheme, they are all forgotten.
+const maxSpices = 1 << 14
+
+type (
+	spiceKey struct {
+		scope string
+		flags ViewRegionFlags
+	}
+
+	// The memoized Flavours of a version of a ColourScheme
+	spiceMap struct {
+		version  int
+		flavours map[spiceKey]Flavour
+	}
+
+	spiceCache struct {
+		lock sync.Mutex
+		m    map[ColourScheme]*spiceMap
+		// Incremented on invalidation, so that Flavours spiced
+		// while a scheme changed aren't memoized
+		gen int
+	}
+)
+
+// The memoized Flavours of each ColourScheme
+var spices = spiceCache{m: make(map[ColourScheme]*spiceMap)}
+
+// Spice returns the Flavour scheme.Spice returns for vr, memoized by the
+// scheme, its version if it's a VersionedColourScheme, and the Scope and
+// Flags of vr, which are all a ColourScheme should take into account.
+//
+// Memoized Flavours are counted as "render.Spice.hit" by util.Prof, and
+// calls of scheme.Spice as "render.Spice.miss".
+func Spice(scheme ColourScheme, vr *ViewRegions) Flavour {
+	// Schemes which can't be map keys aren't memoized
+	if !reflect.TypeOf(scheme).Comparable() {
+		return scheme.Spice(vr)
+	}
+	key := spiceKey{vr.Scope, vr.Flags}
+	version := 0
+	if vs, ok := scheme.(VersionedColourScheme); ok {
+		version = vs.Version()
+	}
+	spices.lock.Lock()
+	var (
+		f  Flavour
+		ok bool
+	)
+	if sm := spices.m[scheme]; sm != nil && sm.version == version {
+		f, ok = sm.flavours[key]
+	}
+	gen := spices.gen
+	spices.lock.Unlock()
+	if ok {
+		pe := util.Prof.Enter("render.Spice.hit")
+		pe.Exit()
+		return f
+	}
+
+	pe := util.Prof.Enter("render.Spice.miss")
+	f = scheme.Spice(vr)
+	pe.Exit()
+
+	spices.lock.Lock()
+	defer spices.lock.Unlock()
+	if gen != spices.gen {
+		return f
+	}
+	sm := spices.m[scheme]
+	if sm == nil || sm.version != version || len(sm.flavours) >= maxSpices {
+		sm = &spiceMap{version, make(map[spiceKey]Flavour)}
+		spices.m[scheme] = sm
+	}
+	sm.flavours[key] = f
+	return f
+}
+
+// InvalidateSpice forgets the memoized Flavours of scheme. It must
+// be called whenever a scheme which isn't a VersionedColourScheme
+// changes, and when a scheme is no longer used.
+func InvalidateSpice(scheme ColourScheme) {
+	if scheme == nil || !reflect.TypeOf(scheme).Comparable() {
+		return
+	}
+	spices.lock.Lock()
+	defer spices.lock.Unlock()
+	delete(spices.m, scheme)
+	spices.gen++
+}
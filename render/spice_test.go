@@ -0,0 +1,99 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"testing"
+
+	"github.com/limetext/util"
+)
+
+type countingColourScheme struct {
+	ColourScheme
+	calls int
+	bg    Colour
+}
+
+func (cs *countingColourScheme) Spice(vr *ViewRegions) Flavour {
+	cs.calls++
+	f := Flavour{Background: cs.bg}
+	if vr.Flags&SELECTION != 0 {
+		f.Foreground = Colour{1, 1, 1, 1}
+	}
+	return f
+}
+
+type versionedColourScheme struct {
+	countingColourScheme
+	version int
+}
+
+func (cs *versionedColourScheme) Version() int {
+	return cs.version
+}
+
+func profCalls(name string) int {
+	for _, r := range util.Prof.Results() {
+		if r.Name == name {
+			return r.Calls
+		}
+	}
+	return 0
+}
+
+func TestSpice(t *testing.T) {
+	cs := &countingColourScheme{bg: Colour{1, 2, 3, 4}}
+	hits, misses := profCalls("render.Spice.hit"), profCalls("render.Spice.miss")
+
+	for i := 0; i < 3; i++ {
+		if f := Spice(cs, &ViewRegions{Scope: "a"}); f.Background != cs.bg {
+			t.Errorf("Test %d: Expected background %v, but got %v", i, cs.bg, f.Background)
+		}
+	}
+	if cs.calls != 1 {
+		t.Errorf("Expected 1 call of Spice, but got %d", cs.calls)
+	}
+	if f := Spice(cs, &ViewRegions{Scope: "a", Flags: SELECTION}); f.Foreground != (Colour{1, 1, 1, 1}) {
+		t.Errorf("Expected the flags to be spiced separately, but got %v", f)
+	}
+	Spice(cs, &ViewRegions{Scope: "b"})
+	if cs.calls != 3 {
+		t.Errorf("Expected 3 calls of Spice, but got %d", cs.calls)
+	}
+	if h, m := profCalls("render.Spice.hit")-hits, profCalls("render.Spice.miss")-misses; h != 2 || m != 3 {
+		t.Errorf("Expected 2 hits and 3 misses, but got %d and %d", h, m)
+	}
+
+	cs.bg = Colour{5, 6, 7, 8}
+	InvalidateSpice(cs)
+	if f := Spice(cs, &ViewRegions{Scope: "a"}); f.Background != cs.bg {
+		t.Errorf("Expected background %v after invalidation, but got %v", cs.bg, f.Background)
+	}
+	if cs.calls != 4 {
+		t.Errorf("Expected 4 calls of Spice, but got %d", cs.calls)
+	}
+
+	other := &countingColourScheme{}
+	Spice(other, &ViewRegions{Scope: "a"})
+	if other.calls != 1 {
+		t.Errorf("Expected schemes to be memoized separately, but got %d calls", other.calls)
+	}
+}
+
+func TestSpiceVersion(t *testing.T) {
+	cs := &versionedColourScheme{}
+	Spice(cs, &ViewRegions{Scope: "a"})
+	Spice(cs, &ViewRegions{Scope: "a"})
+	if cs.calls != 1 {
+		t.Errorf("Expected 1 call of Spice, but got %d", cs.calls)
+	}
+
+	// Changing the version is enough for the scheme to be spiced again
+	cs.bg = Colour{1, 2, 3, 4}
+	cs.version++
+	if f := Spice(cs, &ViewRegions{Scope: "a"}); f.Background != cs.bg || cs.calls != 2 {
+		t.Errorf("Expected background %v from a 2nd call of Spice, but got %v from %d calls", cs.bg, f.Background, cs.calls)
+	}
+}
@@ -23,6 +23,7 @@ const (
 	SELECTION                                             // This Region is part of selected text
 	HIGHLIGHT                                             // This Region is part of highlighted text
 	DRAW_TEXT                                             // The actual text contained in the region should be rendered
+	PHANTOM                                               // Phantoms are shown at the region, see Phantom
 	DEFAULT                 ViewRegionFlags = 0           // No flags at all, only draw the region itself and not the text
 )
 
@@ -49,6 +50,8 @@ type (
 		Scope string
 		// Gutter icon (displayed next to line numbers) URI.
 		Icon string
+		// Icons of higher priority are shown first in the gutter.
+		Priority int
 		// Flags used to hint at how the region should be rendered.
 		Flags ViewRegionFlags
 	}
@@ -76,12 +79,17 @@ func (vrm *ViewRegionMap) Cull(viewport text.Region) {
 // Removes any regions that are outside of the given viewport,
 // and clips the regions that are intersecting it so that
 // all regions remaining are fully contained inside of the viewport.
+// Empty regions are removed, unless the flags include DRAW_EMPTY.
 func (vr *ViewRegions) Cull(viewport text.Region) {
 	pe := util.Prof.Enter("render.vr.Cull")
 	defer pe.Exit()
 	nr := []text.Region{}
 	for _, r := range vr.Regions.Regions() {
-		if viewport.Intersects(r) {
+		if r.Empty() {
+			if vr.Flags&DRAW_EMPTY != 0 && viewport.Contains(r.A) {
+				nr = append(nr, r)
+			}
+		} else if viewport.Intersects(r) {
 			in := viewport.Intersection(r)
 			if in.Size() != 0 {
 				nr = append(nr, in)
@@ -94,7 +102,7 @@ func (vr *ViewRegions) Cull(viewport text.Region) {
 
 // Creates a copy of this ViewRegions object.
 func (vr *ViewRegions) Clone() *ViewRegions {
-	ret := ViewRegions{Scope: vr.Scope, Icon: vr.Icon, Flags: vr.Flags}
+	ret := ViewRegions{Scope: vr.Scope, Icon: vr.Icon, Priority: vr.Priority, Flags: vr.Flags}
 	ret.Regions.AddAll(vr.Regions.Regions())
 	return &ret
 }
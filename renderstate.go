@@ -0,0 +1,249 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"github.com/limetext/backend/render"
+	"github.com/limetext/text"
+	"github.com/limetext/util"
+)
+
+// The number of buffer edits a View remembers for its RenderStates.
+// A RenderState which is more edits behind than this is fully redrawn.
+const maxRenderEdits = 256
+
+type (
+	// A RenderState keeps track of what was last rendered of a View in
+	// a viewport, so that frontends only need to redraw what changed since
+	// the last frame rather than the whole viewport on every keystroke.
+	//
+	// A RenderState isn't safe for concurrent use.
+	RenderState struct {
+		view     *View
+		viewport text.Region
+		full     bool
+		// What the last frame was rendered from
+		changeCount int
+		regions     int
+		selection   []text.Region
+		scheme      string
+		lines       int
+		units       map[render.RenderUnit]bool
+	}
+
+	// A RenderDiff describes what needs redrawing since the last frame.
+	RenderDiff struct {
+		// Whether the whole viewport needs to be redrawn, in which
+		// case Dirty is the viewport's lines and Units all of its units
+		Full bool
+		// The regions of the buffer to redraw, extended to whole lines
+		Dirty []text.Region
+		// The units of the current frame which overlap Dirty
+		Units render.TranscribedRecipe
+	}
+
+	// An edit of the View's buffer, made at ChangeCount count
+	renderEdit struct {
+		count           int
+		position, delta int
+	}
+)
+
+// NewRenderState creates a RenderState of this View in viewport. The
+// first call to its Update returns a full redraw.
+func (v *View) NewRenderState(viewport text.Region) *RenderState {
+	return &RenderState{view: v, viewport: viewport, full: true}
+}
+
+// Returns the viewport of the RenderState.
+func (rs *RenderState) Viewport() text.Region {
+	return rs.viewport
+}
+
+// Changes the viewport of the RenderState, which causes the next Update
+// to return a full redraw unless the viewport is the same as before.
+func (rs *RenderState) SetViewport(viewport text.Region) {
+	if viewport != rs.viewport {
+		rs.viewport = viewport
+		rs.full = true
+	}
+}
+
+// Invalidate makes the next Update return a full redraw, for example
+// after the frontend lost what it had drawn.
+func (rs *RenderState) Invalidate() {
+	rs.full = true
+}
+
+// Changed returns whether anything affecting the rendering of the viewport
+// changed since the last Update: the buffer, the selection, the regions of
+// the View, including those of the syntax highlighting, or its colour scheme.
+// It's cheap compared to Update, which can be skipped when this is false.
+func (rs *RenderState) Changed() bool {
+	v := rs.view
+	if rs.full || v.ChangeCount() != rs.changeCount || v.Settings().String("color_scheme", "") != rs.scheme {
+		return true
+	}
+	v.lock.Lock()
+	regions := v.regionsChanged
+	v.lock.Unlock()
+	if regions != rs.regions {
+		return true
+	}
+	return !sameRegions(v.Sel().Regions(), rs.selection)
+}
+
+func sameRegions(a, b []text.Region) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Update renders the viewport and returns what changed since the last
+// Update. The RenderDiff is empty when nothing changed.
+func (rs *RenderState) Update() (ret RenderDiff) {
+	pe := util.Prof.Enter("RenderState.Update")
+	defer pe.Exit()
+	if !rs.Changed() {
+		return
+	}
+
+	v := rs.view
+	v.lock.Lock()
+	edits := append([]renderEdit(nil), v.renderEdits...)
+	rs.regions = v.regionsChanged
+	v.lock.Unlock()
+	cc := v.ChangeCount()
+	lines, _ := v.RowCol(v.Size())
+	sel := v.Sel().Regions()
+	rs.scheme = v.Settings().String("color_scheme", "")
+
+	units := make(map[render.RenderUnit]bool)
+	var all render.TranscribedRecipe
+	for _, ru := range v.Transform(rs.viewport).Transcribe() {
+		units[ru] = true
+		all = append(all, ru)
+	}
+	old := rs.units
+	rs.units = units
+	// Move the units of the last frame to where they are now
+	if cc != rs.changeCount {
+		moved := make(map[render.RenderUnit]bool, len(old))
+		for ru := range old {
+			for _, e := range edits {
+				if e.count > rs.changeCount {
+					ru.Region.Adjust(e.position, e.delta)
+				}
+			}
+			moved[ru] = true
+		}
+		old = moved
+	}
+
+	full := rs.full
+	if !full && cc != rs.changeCount {
+		// The first edit we need must not have been forgotten
+		if len(edits) == 0 || edits[0].count > rs.changeCount+1 {
+			full = true
+		}
+	}
+	if full {
+		rs.full = false
+		rs.changeCount, rs.lines, rs.selection = cc, lines, sel
+		ret.Full = true
+		ret.Dirty = wholeLines(v, rs.viewport, []text.Region{rs.viewport})
+		ret.Units = all
+		return
+	}
+
+	var dirty []text.Region
+	add := func(r text.Region) {
+		dirty = append(dirty, text.Region{A: r.Begin(), B: r.End()})
+	}
+	if cc != rs.changeCount {
+		first := text.Region{A: -1, B: -1}
+		for _, e := range edits {
+			if e.count <= rs.changeCount {
+				continue
+			}
+			for i := range dirty {
+				dirty[i].Adjust(e.position, e.delta)
+			}
+			r := text.Region{A: e.position, B: e.position}
+			if e.delta > 0 {
+				r.B += e.delta
+			}
+			if first.A != -1 {
+				first.Adjust(e.position, e.delta)
+			}
+			if first.A == -1 || r.A < first.A {
+				first = text.Region{A: r.A, B: r.A}
+			}
+			add(r)
+		}
+		// Everything after the edits moved when lines were added or removed
+		if lines != rs.lines && first.A != -1 && first.A < rs.viewport.End() {
+			add(text.Region{A: first.A, B: rs.viewport.End()})
+		}
+	}
+	// Carets aren't render units, so the lines of
+	// changed selections are always redrawn
+	if !sameRegions(sel, rs.selection) {
+		for _, r := range rs.selection {
+			for _, e := range edits {
+				if e.count > rs.changeCount {
+					r.Adjust(e.position, e.delta)
+				}
+			}
+			add(r)
+		}
+		for _, r := range sel {
+			add(r)
+		}
+	}
+	for ru := range old {
+		if !units[ru] {
+			add(ru.Region)
+		}
+	}
+	for ru := range units {
+		if !old[ru] {
+			add(ru.Region)
+		}
+	}
+	rs.changeCount, rs.lines, rs.selection = cc, lines, sel
+
+	ret.Dirty = wholeLines(v, rs.viewport, dirty)
+	for _, ru := range all {
+		for _, d := range ret.Dirty {
+			if ru.Region.Begin() <= d.End() && ru.Region.End() >= d.Begin() {
+				ret.Units = append(ret.Units, ru)
+				break
+			}
+		}
+	}
+	return
+}
+
+// Extends the regions to whole lines, clipped to the lines of the
+// viewport, and merges them.
+func wholeLines(v *View, viewport text.Region, regions []text.Region) (ret []text.Region) {
+	vp := text.Region{A: v.Line(viewport.Begin()).Begin(), B: v.Line(viewport.End()).End()}
+	var set text.RegionSet
+	for _, r := range regions {
+		if r.Begin() > vp.End() || r.End() < vp.Begin() {
+			continue
+		}
+		r = text.Region{A: v.Line(r.Begin()).Begin(), B: v.Line(r.End()).End()}
+		set.Add(vp.Intersection(r))
+	}
+	return set.Regions()
+}
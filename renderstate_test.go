@@ -0,0 +1,126 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/limetext/text"
+)
+
+func TestRenderState(t *testing.T) {
+	w := GetEditor().NewWindow()
+	defer w.Close()
+
+	v := w.NewFile()
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+
+	edit := func(f func(e *Edit)) {
+		e := v.BeginEdit()
+		f(e)
+		v.EndEdit(e)
+		if !v.waitForParse(time.Second) {
+			t.Fatal("Timed out waiting for the view to be parsed")
+		}
+	}
+	edit(func(e *Edit) { v.Insert(e, 0, "aa\nbb\ncc\ndd") })
+	v.Sel().Clear()
+	v.Sel().Add(text.Region{A: 0, B: 0})
+
+	rs := v.NewRenderState(text.Region{A: 0, B: v.Size()})
+	if d := rs.Update(); !d.Full || !reflect.DeepEqual(d.Dirty, []text.Region{{A: 0, B: 11}}) {
+		t.Errorf("Expected the first update to be a full redraw, but got %+v", d)
+	}
+	if rs.Changed() {
+		t.Error("Expected no changes right after an update")
+	}
+	if d := rs.Update(); d.Full || d.Dirty != nil || d.Units != nil {
+		t.Errorf("Expected an empty update, but got %+v", d)
+	}
+
+	tests := []struct {
+		change func()
+		dirty  []text.Region
+	}{
+		{
+			func() { v.AddRegions("test", []text.Region{{A: 3, B: 4}}, "comment", "", 0) },
+			[]text.Region{{A: 3, B: 5}},
+		},
+		{
+			func() { edit(func(e *Edit) { v.Insert(e, 7, "x") }) },
+			[]text.Region{{A: 6, B: 9}},
+		},
+		{
+			func() {
+				v.Sel().Clear()
+				v.Sel().Add(text.Region{A: 10, B: 10})
+			},
+			[]text.Region{{A: 0, B: 2}, {A: 10, B: 12}},
+		},
+		{
+			func() { v.EraseRegions("test") },
+			[]text.Region{{A: 3, B: 5}},
+		},
+		{
+			func() { edit(func(e *Edit) { v.Insert(e, 4, "\n") }) },
+			[]text.Region{{A: 3, B: 13}},
+		},
+	}
+	for i, test := range tests {
+		test.change()
+		if !rs.Changed() {
+			t.Errorf("Test %d: Expected a change", i)
+		}
+		d := rs.Update()
+		if d.Full {
+			t.Errorf("Test %d: Expected a partial update", i)
+		}
+		if !reflect.DeepEqual(d.Dirty, test.dirty) {
+			t.Errorf("Test %d: Expected dirty regions %v, but got %v", i, test.dirty, d.Dirty)
+		}
+		for _, ru := range d.Units {
+			found := false
+			for _, r := range d.Dirty {
+				found = found || r.Intersects(ru.Region) || r.Contains(ru.Region.A)
+			}
+			if !found {
+				t.Errorf("Test %d: Expected unit %v to be in the dirty regions", i, ru)
+			}
+		}
+	}
+
+	rs.SetViewport(text.Region{A: 0, B: 5})
+	if d := rs.Update(); !d.Full || !reflect.DeepEqual(d.Dirty, []text.Region{{A: 0, B: 6}}) {
+		t.Errorf("Expected a full redraw of the new viewport, but got %+v", d)
+	}
+
+	v.AddRegions("test", []text.Region{{A: 1, B: 2}}, "comment", "", 0)
+	rs.Update()
+	for i, change := range []func(){
+		func() { v.Fold(text.Region{A: 0, B: 2}) },
+		func() { v.Unfold(text.Region{A: 0, B: 2}) },
+		func() { v.SetRegionsPriority("test", 1) },
+	} {
+		change()
+		if !rs.Changed() {
+			t.Errorf("Test %d: Expected a change", i)
+		}
+		rs.Update()
+	}
+
+	e := v.BeginEdit()
+	for i := 0; i <= maxRenderEdits; i++ {
+		v.Insert(e, 0, "x")
+	}
+	v.EndEdit(e)
+	if d := rs.Update(); !d.Full {
+		t.Error("Expected a full redraw after more edits than are remembered")
+	}
+}
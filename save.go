@@ -0,0 +1,138 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/limetext/backend/log"
+	"github.com/limetext/backend/vfs"
+)
+
+// Returned by SaveAs when the user chose not to overwrite a read-only file
+var errReadOnlyFile = errors.New("read-only file")
+
+// The mode bits of a file kept when it's replaced by an atomic save
+const preservedMode = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// Returns whether the file name exists and has none of its write bits set.
+func isReadOnlyFile(name string) bool {
+	fi, err := vfs.Stat(name)
+	return err == nil && fi.Mode().Perm()&0222 == 0
+}
+
+// Returns the file saving to name writes, which is the file name links to
+// if it's a symbolic link, and its FileInfo, nil if it doesn't exist. If
+// name is a link to nowhere, it's returned with the link's FileInfo.
+func saveTarget(name string) (string, os.FileInfo, error) {
+	fi, err := os.Lstat(name)
+	if os.IsNotExist(err) {
+		return name, nil, nil
+	} else if err != nil {
+		return "", nil, err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return name, fi, nil
+	}
+	target, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return name, fi, nil
+	}
+	fi, err = os.Stat(target)
+	if os.IsNotExist(err) {
+		return target, nil, nil
+	} else if err != nil {
+		return "", nil, err
+	}
+	return target, fi, nil
+}
+
+// Gives the file name the mode, owner and extended attributes of the file
+// it's going to replace, from which were read fi.
+func preserveFile(name, from string, fi os.FileInfo) error {
+	// Changing the owner clears the setuid and setgid bits,
+	// so it's done first
+	if err := chownLike(name, fi); err != nil {
+		return err
+	}
+	if err := os.Chmod(name, fi.Mode()&preservedMode); err != nil {
+		return err
+	}
+	if err := copyXattrs(from, name); err != nil {
+		log.Warn("Couldn't copy the extended attributes of %s: %s", from, err)
+	}
+	return nil
+}
+
+// Writes the buffer to the file name, through the codec c if it's not
+// nil. If the "atomic_save" setting is true,
+// a file which exists is replaced by a new one written next to it, which is
+// given its mode, owner and extended attributes. Files which can't be
+// replaced without losing those, or their hard links, are written in place.
+// Symbolic links are followed, the file they link to being written rather
+// than the link replaced. Files which aren't local are written through the
+// file system of their URI, see the vfs package.
+func (v *View) write(name string, c FileCodec) error {
+	if atomic := v.Settings().Bool("atomic_save", true); v.FileName() == "" || !atomic || !vfs.IsLocal(name) {
+		return v.nonAtomicSave(name, c)
+	}
+	// name may be a file:// URI, which os functions don't understand
+	_, p := vfs.Split(name)
+	target, fi, err := saveTarget(p)
+	if err != nil {
+		return err
+	}
+	inPlace := func() error {
+		return v.nonAtomicSave(target, c)
+	}
+	if fi != nil && (fi.Mode()&os.ModeSymlink != 0 || hasHardLinks(fi)) {
+		return inPlace()
+	}
+
+	n, err := ioutil.TempDir(filepath.Dir(target), "lime")
+	if os.IsPermission(err) {
+		// The file may be writable even if its directory isn't
+		return inPlace()
+	} else if err != nil {
+		return err
+	}
+	defer os.RemoveAll(n)
+	tmpf := filepath.Join(n, "tmp")
+	if err := v.nonAtomicSave(tmpf, c); err != nil {
+		return err
+	}
+	if fi != nil {
+		if err := preserveFile(tmpf, target, fi); err != nil {
+			log.Fine("Saving %s in place, as replacing it would change it: %s", target, err)
+			return inPlace()
+		}
+	}
+	if err := os.Rename(tmpf, target); err != nil {
+		log.Fine("Couldn't replace %s, saving it in place: %s", target, err)
+		return inPlace()
+	}
+	return nil
+}
+
+// Saves the buffer to name through the Frontend, after having been denied
+// permission to with err, if the Frontend is an ElevationFrontend. Otherwise
+// err is returned. The buffer is written through the codec c if it's not nil.
+func (v *View) saveWithElevation(name string, c FileCodec, err error) error {
+	fe, ok := GetEditor().Frontend().(ElevationFrontend)
+	if !ok {
+		return err
+	}
+	log.Info("Saving %s with elevated privileges: %s", name, err)
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(v.writeFile(w, c))
+	}()
+	defer r.Close()
+	return fe.SaveWithElevation(v, name, r)
+}
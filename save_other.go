@@ -0,0 +1,19 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+//go:build windows || plan9
+// +build windows plan9
+
+package backend
+
+import "os"
+
+// Files have no owners to keep on this platform.
+func chownLike(name string, fi os.FileInfo) error {
+	return nil
+}
+
+func hasHardLinks(fi os.FileInfo) bool {
+	return false
+}
@@ -0,0 +1,283 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/limetext/text"
+)
+
+type elevationFrontend struct {
+	dummyFrontend
+	name string
+	data []byte
+}
+
+func (fe *elevationFrontend) SaveWithElevation(v *View, name string, r io.Reader) error {
+	fe.name = name
+	var err error
+	fe.data, err = ioutil.ReadAll(r)
+	return err
+}
+
+func TestSavePreservesFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("File modes and links aren't kept on windows")
+	}
+	dir, rm := tempDir(t)
+	defer rm()
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, []byte("a"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(file, 0751); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("file", link); err != nil {
+		t.Fatal(err)
+	}
+	hard := filepath.Join(dir, "hard")
+	if err := ioutil.WriteFile(hard, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(dir, "other")
+	if err := os.Link(hard, other); err != nil {
+		t.Fatal(err)
+	}
+
+	w := GetEditor().NewWindow()
+	defer w.Close()
+
+	tests := []struct {
+		open string
+		// The file expected to be written
+		file string
+		mode os.FileMode
+	}{
+		{file, file, 0751},
+		{link, file, 0751},
+		{hard, other, 0644},
+	}
+	for i, test := range tests {
+		if err := ioutil.WriteFile(test.open, []byte("a"), 0); err != nil {
+			t.Fatal(err)
+		}
+		v := w.OpenFile(test.open, 0)
+		v.Settings().Set("atomic_save", true)
+		e := v.BeginEdit()
+		v.Insert(e, 0, "b")
+		v.EndEdit(e)
+		if err := v.Save(); err != nil {
+			t.Fatalf("Test %d: Couldn't save %s: %s", i, test.open, err)
+		}
+		if d, err := ioutil.ReadFile(test.file); err != nil || string(d) != "ba" {
+			t.Errorf("Test %d: Expected %s to be written, but got %q, %v", i, test.file, d, err)
+		}
+		if fi, err := os.Stat(test.file); err != nil || fi.Mode() != test.mode {
+			t.Errorf("Test %d: Expected the mode %s, but got %v, %v", i, test.mode, fi.Mode(), err)
+		}
+		v.Close()
+	}
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Expected the link to still be a link, but got %v", err)
+	}
+}
+
+func TestSaveNewFileMode(t *testing.T) {
+	dir, rm := tempDir(t)
+	defer rm()
+	fn := filepath.Join(dir, "new")
+
+	w := GetEditor().NewWindow()
+	defer w.Close()
+	v := w.NewFile()
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+	if err := v.SaveAs(fn); err != nil {
+		t.Fatal(err)
+	}
+	// Only the umask restricts the mode of new files
+	cmp := filepath.Join(dir, "cmp")
+	f, err := os.OpenFile(cmp, os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	fi, _ := os.Stat(fn)
+	ci, _ := os.Stat(cmp)
+	if fi.Mode() != ci.Mode() {
+		t.Errorf("Expected the mode %s, but got %s", ci.Mode(), fi.Mode())
+	}
+}
+
+func TestSaveFileURI(t *testing.T) {
+	dir, rm := tempDir(t)
+	defer rm()
+	fn := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(fn, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := GetEditor().NewWindow()
+	defer w.Close()
+	v := w.OpenFile("file://"+fn, 0)
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+	e := v.BeginEdit()
+	v.Insert(e, 1, "b")
+	v.EndEdit(e)
+	if err := v.Save(); err != nil {
+		t.Fatalf("Couldn't save %s: %s", v.FileName(), err)
+	}
+	if d, _ := ioutil.ReadFile(fn); string(d) != "ab" {
+		t.Errorf("Expected %q to be saved, but got %q", "ab", d)
+	}
+}
+
+func TestSaveReadOnlyFile(t *testing.T) {
+	dir, rm := tempDir(t)
+	defer rm()
+	fn := filepath.Join(dir, "ro")
+	if err := ioutil.WriteFile(fn, []byte("a"), 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	ed := GetEditor()
+	fe := &dummyFrontend{}
+	defer setFrontend(fe)()
+
+	w := ed.NewWindow()
+	defer w.Close()
+	v := w.OpenFile(fn, 0)
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+	e := v.BeginEdit()
+	v.Insert(e, 0, "b")
+	v.EndEdit(e)
+
+	if err := v.Save(); err != errReadOnlyFile {
+		t.Errorf("Expected %s, but got %v", errReadOnlyFile, err)
+	}
+	if d, _ := ioutil.ReadFile(fn); string(d) != "a" {
+		t.Errorf("Expected the read-only file not to be overwritten, but got %q", d)
+	}
+
+	fe.SetDefaultAction(true)
+	if err := v.Save(); err != nil && !os.IsPermission(err) {
+		t.Errorf("Expected the read-only file to be overwritten, but got %s", err)
+	}
+}
+
+func TestSaveWithElevation(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Getuid() == 0 {
+		t.Skip("Permission to write files can't be denied")
+	}
+	dir, rm := tempDir(t)
+	defer rm()
+	fn := filepath.Join(dir, "denied")
+	if err := ioutil.WriteFile(fn, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ed := GetEditor()
+	fe := &elevationFrontend{}
+	defer setFrontend(fe)()
+
+	w := ed.NewWindow()
+	defer w.Close()
+	v := w.OpenFile(fn, 0)
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+	e := v.BeginEdit()
+	v.Insert(e, 0, "b")
+	v.EndEdit(e)
+
+	// Neither the file nor its directory can be written
+	if err := os.Chmod(fn, 0400); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0500); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0700)
+	fe.SetDefaultAction(true)
+	if err := v.Save(); err != nil {
+		t.Fatalf("Expected the file to be saved with elevation, but got %s", err)
+	}
+	if fe.name != fn || string(fe.data) != "ba" {
+		t.Errorf("Expected the frontend to save %q to %s, but got %q to %s", "ba", fn, fe.data, fe.name)
+	}
+	if v.IsDirty() {
+		t.Error("Expected the view to be clean")
+	}
+}
+
+type statusFrontend struct {
+	dummyFrontend
+	status []string
+}
+
+func (fe *statusFrontend) StatusMessage(msg string) {
+	fe.m.Lock()
+	defer fe.m.Unlock()
+	fe.status = append(fe.status, msg)
+}
+
+func TestViewReadOnly(t *testing.T) {
+	ed := GetEditor()
+	fe := &statusFrontend{}
+	defer setFrontend(fe)()
+
+	w := ed.NewWindow()
+	defer w.Close()
+	v := w.NewFile()
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+	e := v.BeginEdit()
+	v.Insert(e, 0, "abc")
+	v.EndEdit(e)
+
+	v.SetReadOnly(true)
+	if !v.IsReadOnly() {
+		t.Fatal("Expected the view to be read-only")
+	}
+	e = v.BeginEdit()
+	v.Insert(e, 0, "x")
+	v.Erase(e, text.Region{A: 0, B: 1})
+	v.Replace(e, text.Region{A: 1, B: 2}, "y")
+	v.EndEdit(e)
+	v.UndoStack().Undo(true)
+	if s := v.Substr(text.Region{A: 0, B: v.Size()}); s != "abc" {
+		t.Errorf("Expected the read-only view not to change, but got %q", s)
+	}
+	if len(fe.status) != 1 {
+		t.Errorf("Expected the user to be told once that the view is read-only, but got %q", fe.status)
+	}
+
+	v.SetReadOnly(false)
+	for v.UndoStack().Position() > 0 {
+		v.UndoStack().Undo(true)
+	}
+	if v.Size() != 0 {
+		t.Errorf("Expected the edit to be undone, but got %q", v.Substr(text.Region{A: 0, B: v.Size()}))
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package backend
+
+import (
+	"os"
+	"syscall"
+)
+
+// Gives the file name the owner and group of the file fi was read from,
+// if they aren't those name has already.
+func chownLike(name string, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	cur, err := os.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if cst, ok := cur.Sys().(*syscall.Stat_t); ok && cst.Uid == st.Uid && cst.Gid == st.Gid {
+		return nil
+	}
+	return os.Lchown(name, int(st.Uid), int(st.Gid))
+}
+
+// Returns whether the file fi was read from has other hard links,
+// which would no longer be the same file if it was replaced.
+func hasHardLinks(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && uint64(st.Nlink) > 1
+}
@@ -0,0 +1,108 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/limetext/backend/log"
+	"github.com/limetext/backend/parser"
+	"github.com/limetext/backend/render"
+	"github.com/limetext/text"
+	qparser "github.com/quarnster/parser"
+)
+
+type (
+	// A Scope is one of the nested scopes at a point.
+	Scope struct {
+		Name   string
+		Region text.Region
+	}
+
+	// The ScopeStack describes the scopes at a point of a View
+	// and how the colour scheme styles the text at that point.
+	ScopeStack struct {
+		// The nested scopes containing the point, outermost first
+		Scopes []Scope
+		// The innermost node of the syntax tree containing the point,
+		// nil if the syntax highlighter isn't backed by a tree
+		Node *qparser.Node
+		// The Flavour the colour scheme gives the scopes
+		Flavour render.Flavour
+		// The colour scheme rule which produced Flavour, nil if the
+		// colour scheme can't tell or no rule matched
+		Rule *render.Rule
+	}
+
+	// Shows the scope name at the first cursor, and the
+	// colour scheme rule matching it, in the status bar.
+	ShowScopeNameCommand struct {
+		DefaultCommand
+	}
+)
+
+// Returns the full concatenated scope name of ss, which is
+// the same as the one returned by View.ScopeName.
+func (ss *ScopeStack) Name() string {
+	names := make([]string, len(ss.Scopes))
+	for i, s := range ss.Scopes {
+		names[i] = s.Name
+	}
+	return strings.Join(names, " ")
+}
+
+// Returns the ScopeStack at point. Unless the syntax highlighter of the
+// view implements parser.NodeHighlighter, only the innermost scope's
+// extent is known and the scopes will all have that Region.
+func (v *View) ScopeStack(point int) (ss ScopeStack) {
+	v.lock.Lock()
+	if nh, ok := v.syntax.(parser.NodeHighlighter); ok {
+		nodes := nh.Nodes(point)
+		for _, n := range nodes {
+			ss.Scopes = append(ss.Scopes, Scope{Name: n.Name, Region: n.Range})
+		}
+		if l := len(nodes); l > 0 {
+			ss.Node = nodes[l-1]
+		}
+	} else if v.syntax != nil {
+		r := v.syntax.ScopeExtent(point)
+		for _, name := range strings.Fields(v.syntax.ScopeName(point)) {
+			ss.Scopes = append(ss.Scopes, Scope{Name: name, Region: r})
+		}
+	}
+	v.lock.Unlock()
+
+	cs := v.Settings().String("color_scheme", "")
+	scheme := GetEditor().GetColorScheme(cs)
+	name := ss.Name()
+	ss.Flavour = scheme.Spice(&render.ViewRegions{Scope: name, Flags: render.DRAW_TEXT})
+	if rm, ok := scheme.(render.RuleMatcher); ok {
+		if r, ok := rm.MatchRule(name); ok {
+			ss.Rule = &r
+		}
+	}
+	return
+}
+
+func (c *ShowScopeNameCommand) Run(v *View, e *Edit) error {
+	sel := v.Sel()
+	if sel.Len() == 0 {
+		return nil
+	}
+	ss := v.ScopeStack(sel.Get(0).B)
+	msg := fmt.Sprintf("Scope: %s", ss.Name())
+	if ss.Rule != nil {
+		msg += fmt.Sprintf(", rule: %s (%s)", ss.Rule.Name, ss.Rule.Selector)
+	}
+	GetEditor().Frontend().StatusMessage(msg)
+	return nil
+}
+
+func init() {
+	if err := GetEditor().CommandHandler().RegisterWithDefault(&ShowScopeNameCommand{}); err != nil {
+		log.Error("Failed to register command: %s", err)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/limetext/backend/parser"
+	"github.com/limetext/backend/render"
+	"github.com/limetext/text"
+	qparser "github.com/quarnster/parser"
+)
+
+type dummyParser struct {
+	root *qparser.Node
+}
+
+func (p *dummyParser) Parse() (*qparser.Node, error) {
+	return p.root, nil
+}
+
+func TestScopeStack(t *testing.T) {
+	const cs = "testdata/Monokai.tmTheme"
+	w := GetEditor().NewWindow()
+	defer w.Close()
+
+	v := w.NewFile()
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+
+	GetEditor().AddColorScheme(cs, newDummyColorScheme(t, cs))
+	v.Settings().Set("color_scheme", cs)
+
+	e := v.BeginEdit()
+	v.Insert(e, 0, `a = "b"`)
+	v.EndEdit(e)
+	// Wait for the view's own parse so it doesn't replace the syntax set below
+	for i := 0; v.Settings().Int("lime.syntax.updated", -1) != v.ChangeCount(); i++ {
+		if i > 100 {
+			t.Fatal("Parsing the view took too long")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	str := &qparser.Node{Name: "string.quoted.double.test", Range: text.Region{A: 4, B: 7}}
+	root := &qparser.Node{Name: "source.test", Range: text.Region{A: 0, B: 7}, Children: []*qparser.Node{str}}
+	sh, err := parser.NewSyntaxHighlighter(&dummyParser{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.lock.Lock()
+	v.syntax = sh
+	v.lock.Unlock()
+
+	ss := v.ScopeStack(5)
+	exp := []Scope{
+		{"source.test", text.Region{A: 0, B: 7}},
+		{"string.quoted.double.test", text.Region{A: 4, B: 7}},
+	}
+	if !reflect.DeepEqual(ss.Scopes, exp) {
+		t.Errorf("Expected scopes %v, but got %v", exp, ss.Scopes)
+	}
+	if ss.Node != str {
+		t.Errorf("Expected the innermost node %v, but got %v", str, ss.Node)
+	}
+	if n := ss.Name(); n != v.ScopeName(5) {
+		t.Errorf("Expected the scope name %q, but got %q", v.ScopeName(5), n)
+	}
+	if ss.Rule == nil || ss.Rule.Name != "String" || ss.Rule.Selector != "string" {
+		t.Errorf("Expected the String rule, but got %v", ss.Rule)
+	}
+
+	if ss = v.ScopeStack(1); ss.Rule != nil {
+		t.Errorf("Expected no rule outside of the string, but got %v", ss.Rule)
+	}
+
+	// The end of the buffer is only in the outermost scope
+	ss = v.ScopeStack(7)
+	if exp := []Scope{{"source.test", text.Region{A: 0, B: 7}}}; !reflect.DeepEqual(ss.Scopes, exp) {
+		t.Errorf("Expected scopes %v at the end of the buffer, but got %v", exp, ss.Scopes)
+	}
+	if ss.Node != root {
+		t.Errorf("Expected the root node at the end of the buffer, but got %v", ss.Node)
+	}
+}
+
+func TestTextMateColorSchemeRule(t *testing.T) {
+	const path = "testdata/Monokai.tmTheme"
+	ed := GetEditor()
+	ed.loadColorScheme(path)
+	cs, ok := ed.GetColorScheme(path).(*TextMateColorScheme)
+	if !ok {
+		t.Fatalf("Expected %s to be loaded as a TextMateColorScheme", path)
+	}
+	tests := []struct {
+		scope string
+		rule  render.Rule
+		ok    bool
+	}{
+		{"source.go string.quoted.double.go", render.Rule{Name: "String", Selector: "string"}, true},
+		{"source.go comment.line.go", render.Rule{Name: "Comment", Selector: "comment"}, true},
+		{"source.go", render.Rule{}, false},
+	}
+	for i, test := range tests {
+		if r, ok := cs.MatchRule(test.scope); ok != test.ok || r != test.rule {
+			t.Errorf("Test %d: Expected %v, %v, but got %v, %v", i, test.rule, test.ok, r, ok)
+		}
+	}
+}
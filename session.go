@@ -0,0 +1,371 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/limetext/backend/log"
+	"github.com/limetext/backend/vfs"
+	"github.com/limetext/text"
+)
+
+const (
+	// The name of the session file in the user path
+	sessionFileName = "Session.lime-session"
+	// The default of the "hot_exit_interval" setting, in seconds
+	defaultHotExitInterval = 60
+)
+
+type (
+	// A Session is a snapshot of the windows and views of the Editor,
+	// including unsaved changes, from which they can be restored.
+	Session struct {
+		Windows []WindowSession `json:"windows"`
+		// The index of the active window, -1 if none
+		Active int `json:"active"`
+	}
+
+	// A snapshot of a Window.
+	WindowSession struct {
+		Project  string                 `json:"project,omitempty"`
+		Settings map[string]interface{} `json:"settings,omitempty"`
+		Views    []ViewSession          `json:"views"`
+		// The index of the active view, -1 if none
+		Active int `json:"active"`
+	}
+
+	// A snapshot of a View.
+	ViewSession struct {
+		FileName string `json:"file_name,omitempty"`
+		Name     string `json:"name,omitempty"`
+		Scratch  bool   `json:"scratch,omitempty"`
+		ReadOnly bool   `json:"read_only,omitempty"`
+		// Whether the view had unsaved changes, in which case
+		// Content is what it contained
+		Dirty   bool   `json:"dirty,omitempty"`
+		Content string `json:"content,omitempty"`
+		// The settings of the view itself, including its syntax
+		Settings   map[string]interface{} `json:"settings,omitempty"`
+		Encoding   string                 `json:"encoding,omitempty"`
+		LineEnding string                 `json:"line_ending,omitempty"`
+		Selection  []text.Region          `json:"selection,omitempty"`
+		// The region of the view visible in the frontend
+		Visible text.Region `json:"visible"`
+	}
+)
+
+// Returns the own settings of s, except for the internal "lime." ones.
+func sessionSettings(s *text.Settings) map[string]interface{} {
+	var m map[string]interface{}
+	if data, err := json.Marshal(s); err != nil {
+		log.Warn("Couldn't marshal settings: %s", err)
+		return nil
+	} else if err := json.Unmarshal(data, &m); err != nil {
+		log.Warn("Couldn't unmarshal settings: %s", err)
+		return nil
+	}
+	for k := range m {
+		if strings.HasPrefix(k, "lime.") {
+			delete(m, k)
+		}
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+func setSessionSettings(s *text.Settings, m map[string]interface{}) {
+	for k, v := range m {
+		s.Set(k, v)
+	}
+}
+
+// Snapshot returns a Session of the current windows and views.
+func (e *Editor) Snapshot() *Session {
+	s := &Session{Active: -1}
+	fe := e.Frontend()
+	for i, w := range e.Windows() {
+		if w == e.ActiveWindow() {
+			s.Active = i
+		}
+		ws := WindowSession{
+			Project:  w.Project().FileName(),
+			Settings: sessionSettings(w.Settings()),
+			Active:   -1,
+		}
+		for j, v := range w.Views() {
+			if v == w.ActiveView() {
+				ws.Active = j
+			}
+			vs := ViewSession{
+				FileName:   v.FileName(),
+				Name:       v.Name(),
+				Scratch:    v.IsScratch(),
+				ReadOnly:   v.IsReadOnly(),
+				Dirty:      v.IsDirty(),
+				Settings:   sessionSettings(v.Settings()),
+				Encoding:   v.Encoding(),
+				LineEnding: v.LineEnding(),
+				Selection:  v.Sel().Regions(),
+			}
+			// The content of clean files is on disk
+			if vs.Dirty || vs.FileName == "" {
+				vs.Content = v.Substr(text.Region{A: 0, B: v.Size()})
+			}
+			if fe != nil {
+				vs.Visible = fe.VisibleRegion(v)
+			}
+			ws.Views = append(ws.Views, vs)
+		}
+		s.Windows = append(s.Windows, ws)
+	}
+	return s
+}
+
+// Restore opens the windows and views of the session. Files are reloaded
+// from disk, with any unsaved changes of the session applied on top of
+// them as an edit which can be undone, so that views are dirty exactly
+// when they differ from their files.
+func (s *Session) Restore() {
+	ed := GetEditor()
+	var active *Window
+	for i, ws := range s.Windows {
+		w := ws.restore()
+		if i == s.Active {
+			active = w
+		}
+	}
+	if active != nil {
+		ed.SetActiveWindow(active)
+	}
+}
+
+func (ws *WindowSession) restore() *Window {
+	w := GetEditor().NewWindow()
+	setSessionSettings(w.Settings(), ws.Settings)
+	if ws.Project != "" {
+		w.OpenProject(ws.Project)
+	}
+	var active *View
+	for i := range ws.Views {
+		v := ws.Views[i].restore(w)
+		if v != nil && i == ws.Active {
+			active = v
+		}
+	}
+	if active != nil {
+		w.SetActiveView(active)
+	}
+	return w
+}
+
+func (vs *ViewSession) restore(w *Window) *View {
+	var v *View
+	if vs.FileName != "" {
+		if _, err := vfs.Stat(vs.FileName); err == nil {
+			v = w.OpenFile(vs.FileName, 0)
+		} else if !vs.Dirty {
+			log.Warn("Not restoring %s: %s", vs.FileName, err)
+			return nil
+		}
+	}
+	if v == nil {
+		if vs.FileName != "" && !vs.Dirty {
+			// A binary file the user chose not to open
+			return nil
+		}
+		v = w.NewFile()
+		v.SetFileName(vs.FileName)
+		// The content of an untitled view which isn't dirty,
+		// e.g a scratch one, is restored as being saved
+		if vs.FileName == "" && !vs.Dirty {
+			v.SetScratch(true)
+			e := v.BeginEdit()
+			v.Insert(e, 0, vs.Content)
+			v.EndEdit(e)
+			v.setSaved()
+		}
+	}
+	if vs.Name != "" {
+		v.SetName(vs.Name)
+	}
+	setSessionSettings(v.Settings(), vs.Settings)
+	if vs.Encoding != "" {
+		if err := v.SetEncoding(vs.Encoding); err != nil {
+			log.Warn(err)
+		}
+	}
+	if vs.LineEnding != "" {
+		if err := v.SetLineEnding(vs.LineEnding); err != nil {
+			log.Warn(err)
+		}
+	}
+	if vs.Dirty {
+		if r := (text.Region{A: 0, B: v.Size()}); v.Substr(r) != vs.Content {
+			v.SetScratch(false)
+			e := v.BeginEdit()
+			v.Replace(e, r, vs.Content)
+			v.EndEdit(e)
+		}
+	}
+	v.SetScratch(vs.Scratch)
+	v.SetReadOnly(vs.ReadOnly)
+
+	v.Sel().Clear()
+	for _, r := range vs.Selection {
+		if r.A <= v.Size() && r.B <= v.Size() {
+			v.Sel().Add(r)
+		}
+	}
+	if v.Sel().Len() == 0 {
+		v.Sel().Add(text.Region{A: 0, B: 0})
+	}
+	if fe := GetEditor().Frontend(); fe != nil {
+		fe.Show(v, vs.Visible.Intersection(text.Region{A: 0, B: v.Size()}))
+	}
+	return v
+}
+
+// Returns the path of the session file, which is under the user path.
+func (e *Editor) SessionPath() string {
+	if e.UserPath() == "" {
+		return ""
+	}
+	return filepath.Join(e.UserPath(), sessionFileName)
+}
+
+// SaveSession writes a snapshot of the windows and views to the file name,
+// replacing it only once the snapshot has been written in full.
+func (e *Editor) SaveSession(name string) error {
+	data, err := json.MarshalIndent(e.Snapshot(), "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeSession(name, data)
+}
+
+// Counts the session writes, to give each its own temporary file
+var sessionWrites int32
+
+// Writes data to a temporary file next to name and renames it over name.
+// vfs can't remove files, so the temporary file is left behind if
+// the write fails.
+func writeSession(name string, data []byte) error {
+	tmp := fmt.Sprintf("%s.%d.tmp", name, atomic.AddInt32(&sessionWrites, 1))
+	if err := vfs.WriteFile(tmp, data); err != nil {
+		return err
+	}
+	return vfs.Rename(tmp, name)
+}
+
+// RestoreSession restores the windows and views of the session file
+// name, if there is one. See Session.Restore.
+func (e *Editor) RestoreSession(name string) error {
+	data, err := vfs.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("Couldn't unmarshal session %s: %s", name, err)
+	}
+	s.Restore()
+	return nil
+}
+
+// Restores the session saved when the editor last exited, if the "hot_exit"
+// setting is true, and only then starts saving it periodically so that it
+// isn't overwritten before it's restored.
+func (e *Editor) initSession() {
+	if name := e.SessionPath(); name != "" && e.Settings().Bool("hot_exit", true) {
+		if err := e.RestoreSession(name); err != nil {
+			log.Error("Couldn't restore the session: %s", err)
+		}
+	}
+	go e.sessionthread()
+}
+
+// HotExit closes all the windows. If the "hot_exit" setting is true, the
+// session is saved first and unsaved changes are kept in it, rather than
+// asking the user about them. Returns false if a window didn't close.
+func (e *Editor) HotExit() bool {
+	hot := e.Settings().Bool("hot_exit", true)
+	if hot {
+		if name := e.SessionPath(); name == "" {
+			hot = false
+		} else if err := e.SaveSession(name); err != nil {
+			log.Error("Couldn't save the session: %s", err)
+			hot = false
+		}
+	}
+	for _, w := range e.Windows() {
+		if hot {
+			for _, v := range w.Views() {
+				v.SetScratch(true)
+			}
+		}
+		if !w.Close() {
+			return false
+		}
+	}
+	return true
+}
+
+// Periodically saves the session, every "hot_exit_interval" seconds, so
+// that unsaved changes can be restored after a crash.
+func (e *Editor) sessionthread() {
+	var last []byte
+	for {
+		interval := e.Settings().Int("hot_exit_interval", defaultHotExitInterval)
+		if interval <= 0 {
+			interval = defaultHotExitInterval
+		}
+		time.Sleep(time.Duration(interval) * time.Second)
+
+		if name := e.SessionPath(); e.Settings().Bool("hot_exit", true) && name != "" {
+			last = e.autoSaveSession(name, last)
+		}
+	}
+}
+
+// Saves the session to the file name, unless it's the same as last. The
+// windows and views are snapshotted by the input go-routine changing them,
+// only the file is written by the calling go-routine. Returns the saved
+// session, or last if it wasn't saved.
+func (e *Editor) autoSaveSession(name string, last []byte) []byte {
+	ch := make(chan []byte, 1)
+	e.post(func() {
+		var data []byte
+		defer func() { ch <- data }()
+		// Without windows there's nothing to restore
+		if len(e.Windows()) == 0 {
+			return
+		}
+		var err error
+		if data, err = json.MarshalIndent(e.Snapshot(), "", "\t"); err != nil {
+			log.Error("Couldn't snapshot the session: %s", err)
+		}
+	})
+	data := <-ch
+	if data == nil || bytes.Equal(data, last) {
+		return last
+	}
+	if err := writeSession(name, data); err != nil {
+		log.Error("Couldn't save the session: %s", err)
+		return last
+	}
+	return data
+}
@@ -0,0 +1,251 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/limetext/backend/vfs"
+	"github.com/limetext/text"
+)
+
+func TestSession(t *testing.T) {
+	dir, rm := tempDir(t)
+	defer rm()
+	clean := filepath.Join(dir, "clean.txt")
+	dirty := filepath.Join(dir, "dirty.txt")
+	for _, fn := range []string{clean, dirty} {
+		if err := ioutil.WriteFile(fn, []byte("on disk\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	session := filepath.Join(dir, sessionFileName)
+
+	ed := GetEditor()
+	before := ed.Windows()
+
+	w := ed.NewWindow()
+	w.Settings().Set("test_window_setting", "a")
+	cv := w.OpenFile(clean, 0)
+	cv.Sel().Clear()
+	cv.Sel().Add(text.Region{A: 3, B: 5})
+
+	dv := w.OpenFile(dirty, 0)
+	e := dv.BeginEdit()
+	dv.Insert(e, 0, "unsaved ")
+	dv.EndEdit(e)
+	dv.SetLineEnding("windows")
+
+	uv := w.NewFile()
+	e = uv.BeginEdit()
+	uv.Insert(e, 0, "untitled")
+	uv.EndEdit(e)
+	uv.SetName("Untitled")
+	uv.Settings().Set("tab_size", 2)
+	w.SetActiveView(dv)
+
+	// Other tests' windows aren't part of this session
+	ss := ed.Snapshot()
+	ss.Windows, ss.Active = ss.Windows[len(before):], -1
+	data, err := json.Marshal(ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSession(session, data); err != nil {
+		t.Fatalf("Couldn't save the session: %s", err)
+	}
+	for _, v := range w.Views() {
+		v.SetScratch(true)
+	}
+	w.Close()
+
+	// The clean file changed on disk since the session was saved
+	if err := ioutil.WriteFile(clean, []byte("changed on disk\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ed.RestoreSession(session); err != nil {
+		t.Fatalf("Couldn't restore the session: %s", err)
+	}
+	ws := ed.Windows()
+	if len(ws) != len(before)+1 {
+		t.Fatalf("Expected 1 window, but got %d", len(ws)-len(before))
+	}
+	w = ws[len(before)]
+	defer func() {
+		for _, v := range w.Views() {
+			v.SetScratch(true)
+		}
+		w.Close()
+	}()
+	if s := w.Settings().String("test_window_setting", ""); s != "a" {
+		t.Errorf("Expected the window setting to be restored, but got %q", s)
+	}
+	vs := w.Views()
+	if len(vs) != 3 {
+		t.Fatalf("Expected 3 views, but got %d", len(vs))
+	}
+	cv, dv, uv = vs[0], vs[1], vs[2]
+	if w.ActiveView() != dv {
+		t.Error("Expected the active view to be restored")
+	}
+
+	tests := []struct {
+		v       *View
+		content string
+		dirty   bool
+	}{
+		{cv, "changed on disk\n", false},
+		{dv, "unsaved on disk\n", true},
+		{uv, "untitled", true},
+	}
+	for i, test := range tests {
+		if s := test.v.Substr(text.Region{A: 0, B: test.v.Size()}); s != test.content {
+			t.Errorf("Test %d: Expected the content %q, but got %q", i, test.content, s)
+		}
+		if test.v.IsDirty() != test.dirty {
+			t.Errorf("Test %d: Expected dirty to be %v, but it was %v", i, test.dirty, test.v.IsDirty())
+		}
+	}
+	if exp := []text.Region{{A: 3, B: 5}}; !reflect.DeepEqual(cv.Sel().Regions(), exp) {
+		t.Errorf("Expected the selection %v, but got %v", exp, cv.Sel().Regions())
+	}
+	if dv.LineEnding() != LINE_ENDING_WINDOWS {
+		t.Errorf("Expected the line ending %s, but got %s", LINE_ENDING_WINDOWS, dv.LineEnding())
+	}
+	if uv.Name() != "Untitled" || uv.FileName() != "" || uv.Settings().Int("tab_size", 4) != 2 {
+		t.Errorf("Expected the untitled view to be restored, but got %q, %q, %d", uv.Name(), uv.FileName(), uv.Settings().Int("tab_size", 4))
+	}
+
+	// Undoing the unsaved changes goes back to the file
+	dv.UndoStack().Undo(true)
+	if s := dv.Substr(text.Region{A: 0, B: dv.Size()}); s != "on disk\n" {
+		t.Errorf("Expected undo to go back to the file, but got %q", s)
+	}
+}
+
+func TestInitSession(t *testing.T) {
+	dir, rm := tempDir(t)
+	defer rm()
+
+	ed := GetEditor()
+	old := ed.UserPath()
+	ed.userPath = dir
+	defer func() { ed.userPath = old }()
+	data, err := json.Marshal(&Session{Windows: []WindowSession{{Views: []ViewSession{{Content: "restored", Dirty: true}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSession(ed.SessionPath(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	before := len(ed.Windows())
+	ed.initSession()
+	ws := ed.Windows()
+	if len(ws) != before+1 {
+		t.Fatalf("Expected the session to be restored in a new window, but got %d", len(ws)-before)
+	}
+	w := ws[before]
+	defer func() {
+		for _, v := range w.Views() {
+			v.SetScratch(true)
+		}
+		w.Close()
+	}()
+	if vs := w.Views(); len(vs) != 1 || vs[0].Substr(text.Region{A: 0, B: vs[0].Size()}) != "restored" {
+		t.Errorf("Expected the view of the session to be restored, but got %v", vs)
+	}
+}
+
+func TestAutoSaveSession(t *testing.T) {
+	dir, rm := tempDir(t)
+	defer rm()
+	name := filepath.Join(dir, sessionFileName)
+
+	ed := GetEditor()
+	w := ed.NewWindow()
+	defer w.Close()
+
+	// The session is snapshotted once the input, e.g a command, is handled
+	busy := make(chan bool)
+	ed.post(func() { <-busy })
+	done := make(chan []byte)
+	go func() { done <- ed.autoSaveSession(name, nil) }()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("Expected the session not to be saved while the input is handled, but got %v", err)
+	}
+	close(busy)
+	select {
+	case data := <-done:
+		if d, err := ioutil.ReadFile(name); err != nil || !bytes.Equal(d, data) {
+			t.Errorf("Expected the session to be saved, but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the session to be saved once the input was handled")
+	}
+}
+
+func TestRestoreMissingSession(t *testing.T) {
+	if err := GetEditor().RestoreSession(filepath.Join(os.TempDir(), "missing", sessionFileName)); err != nil {
+		t.Errorf("Expected no error restoring a missing session, but got %s", err)
+	}
+}
+
+func TestSessionVfs(t *testing.T) {
+	const name = "mem://session/" + sessionFileName
+	if err := GetEditor().RestoreSession(name); err != nil {
+		t.Errorf("Expected no error restoring a missing session, but got %s", err)
+	}
+
+	data := []byte(`{"windows": []}`)
+	if err := writeSession(name, data); err != nil {
+		t.Fatalf("Couldn't save the session: %s", err)
+	}
+	if d, err := vfs.ReadFile(name); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(d, data) {
+		t.Errorf("Expected the session %q, but got %q", data, d)
+	}
+	if err := GetEditor().RestoreSession(name); err != nil {
+		t.Errorf("Couldn't restore the session: %s", err)
+	}
+}
+
+func TestHotExit(t *testing.T) {
+	dir, rm := tempDir(t)
+	defer rm()
+
+	ed := GetEditor()
+	old := ed.UserPath()
+	ed.userPath = dir
+	defer func() { ed.userPath = old }()
+
+	w := ed.NewWindow()
+	v := w.NewFile()
+	e := v.BeginEdit()
+	v.Insert(e, 0, "unsaved")
+	v.EndEdit(e)
+
+	start := time.Now()
+	if !ed.HotExit() {
+		t.Fatal("Expected hot exit to close the dirty view without asking")
+	}
+	if len(ed.Windows()) != 0 {
+		t.Errorf("Expected all windows to be closed, but got %d", len(ed.Windows()))
+	}
+	fi, err := os.Stat(ed.SessionPath())
+	if err != nil || fi.ModTime().Before(start.Add(-time.Second)) {
+		t.Errorf("Expected the session to be saved, but got %v", err)
+	}
+}
@@ -5,7 +5,11 @@
 package backend
 
 import (
+	"context"
 	"fmt"
+	"path"
+	"regexp"
+	"strings"
 
 	"github.com/limetext/backend/log"
 	"github.com/limetext/backend/parser"
@@ -13,44 +17,227 @@ import (
 	"github.com/limetext/text"
 )
 
-// Any syntax definition for view should implement this interface
-// also it should register it self from editor.AddSyntax
-type Syntax interface {
-	// provides parser for creating syntax highlighter
-	Parser(data string) (parser.Parser, error)
-	Name() string
-	// filetypes this syntax supports
-	FileTypes() []string
+type (
+	// Any syntax definition for view should implement this interface
+	// also it should register it self from editor.AddSyntax
+	Syntax interface {
+		// provides parser for creating syntax highlighter
+		Parser(data string) (parser.Parser, error)
+		Name() string
+		// filetypes this syntax supports
+		FileTypes() []string
+	}
+
+	// The FirstLineMatcher interface can be optionally implemented
+	// by a Syntax which can be recognized by the first line of a file,
+	// e.g "#!/usr/bin/env python" or "<?xml version="1.0"?>".
+	FirstLineMatcher interface {
+		// Returns a regular expression matching the first line
+		FirstLineMatch() string
+	}
+
+	// The SyntaxEmbedder interface can be optionally implemented by a
+	// Syntax whose files contain regions in other languages, e.g
+	// javascript in html <script> tags or fenced code blocks in markdown.
+	SyntaxEmbedder interface {
+		// Returns the regions of data which should be parsed
+		// with another registered syntax.
+		Embedded(data string) []EmbeddedSyntax
+	}
+
+	// An EmbeddedSyntax is a Region, in runes, of the data passed to
+	// SyntaxEmbedder.Embedded and the syntax it's written in. Syntax is
+	// either the path a syntax was registered with or a mode name like
+	// "js", which is resolved the same way as in modelines.
+	EmbeddedSyntax struct {
+		Region text.Region
+		Syntax string
+	}
+)
+
+var (
+	shebangPattern = regexp.MustCompile(`^#!\s*(\S+)((?:\s+\S+)*)`)
+	emacsModeline  = regexp.MustCompile(`-\*-(.*?)-\*-`)
+	vimModeline    = regexp.MustCompile(`\b(?:vi|vim|ex):.*?\b(?:ft|filetype|syn|syntax)=([\w+#.-]+)`)
+)
+
+// Returns the syntax mode declared in an emacs or vim modeline
+// on the given line, e.g "-*- mode: go -*-" or "vim: ft=go".
+func modeline(line string) string {
+	if m := emacsModeline.FindStringSubmatch(line); m != nil {
+		if !strings.Contains(m[1], ":") {
+			return strings.TrimSpace(m[1])
+		}
+		for _, kv := range strings.Split(m[1], ";") {
+			if i := strings.Index(kv, ":"); i != -1 && strings.TrimSpace(kv[:i]) == "mode" {
+				return strings.TrimSpace(kv[i+1:])
+			}
+		}
+	}
+	if m := vimModeline.FindStringSubmatch(line); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// Returns the interpreter named by a shebang line,
+// e.g "python" for "#!/usr/bin/env python3".
+func shebang(line string) string {
+	m := shebangPattern.FindStringSubmatch(line)
+	if m == nil {
+		return ""
+	}
+	interp := path.Base(m[1])
+	if interp == "env" {
+		interp = ""
+		for _, arg := range strings.Fields(m[2]) {
+			if !strings.HasPrefix(arg, "-") && !strings.Contains(arg, "=") {
+				interp = path.Base(arg)
+				break
+			}
+		}
+	}
+	return strings.TrimRight(interp, "0123456789.")
+}
+
+// detectSyntax returns the syntax file which should be used for
+// the view, judging by its file name and contents. In order of
+// precedence it looks at modelines in the first and last lines of
+// the buffer, the user's "extension_syntaxes" setting, the file
+// types syntaxes registered for, the syntaxes' first line match and
+// finally the interpreter of a shebang line.
+//
+// An empty string is returned if no syntax could be determined.
+func (v *View) detectSyntax() string {
+	ed := GetEditor()
+	var lines []string
+	if v.Size() > 0 {
+		rows, _ := v.RowCol(v.Size())
+		for i := 0; i <= rows; i++ {
+			if i == modelineLines && rows >= 2*modelineLines {
+				i = rows - modelineLines + 1
+			}
+			lines = append(lines, v.Substr(v.Line(v.TextPoint(i, 0))))
+		}
+	}
+	for _, line := range lines {
+		if mode := modeline(line); mode != "" {
+			if syn := ed.modeSyntax(mode); syn != "" {
+				return syn
+			}
+		}
+	}
+
+	// The syntax of a compressed file is that of the file it contains
+	name := path.Base(trimCodecExtension(v.FileName()))
+	ext := strings.TrimPrefix(path.Ext(name), ".")
+	if m, ok := v.Settings().Get("extension_syntaxes").(map[string]interface{}); ok {
+		for _, key := range []string{name, ext} {
+			if syn, ok := m[key].(string); ok && key != "" {
+				return syn
+			}
+		}
+	}
+	if syn := ed.fileTypeSyntax(name); syn != "" && v.FileName() != "" {
+		return syn
+	}
+	if syn := ed.fileTypeSyntax(ext); syn != "" && ext != "" {
+		return syn
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+	if syn := ed.firstLineSyntax(lines[0]); syn != "" {
+		return syn
+	}
+	if interp := shebang(lines[0]); interp != "" {
+		return ed.modeSyntax(interp)
+	}
+	return ""
 }
 
-func syntaxHighlighter(name, data string) parser.SyntaxHighlighter {
+const (
+	// The number of lines at the beginning and at the
+	// end of a buffer which are searched for modelines.
+	modelineLines = 5
+	// How deep syntaxes may be embedded in each other.
+	maxEmbedDepth = 4
+)
+
+// Returns the syntax highlighter for data, or nil if
+// ctx was cancelled before the parse had finished.
+func syntaxHighlighter(ctx context.Context, name, data string) parser.SyntaxHighlighter {
 	if name == "" {
 		return &syntax{}
 	}
-	sh, err := syntaxProvider(name, data)
-	if err != nil {
+	sh, err := syntaxProvider(ctx, name, data)
+	if ctx.Err() != nil {
+		return nil
+	} else if err != nil {
 		log.Error("%s, falling back to default syntax", err)
 		return &syntax{}
 	}
 	return sh
 }
 
-func syntaxProvider(name, data string) (parser.SyntaxHighlighter, error) {
+func syntaxProvider(ctx context.Context, name, data string) (parser.SyntaxHighlighter, error) {
 	syn := GetEditor().GetSyntax(name)
 	if syn == nil {
 		return nil, fmt.Errorf("No syntax %s in editor", name)
 	}
-	pr, err := syn.Parser(data)
+	pr, err := syntaxParser(syn, data, 0)
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't get parser from syntax: %s", err)
 	}
-	sh, err := parser.NewSyntaxHighlighter(pr)
+	sh, err := parser.NewSyntaxHighlighterContext(ctx, pr)
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't create syntaxhighlighter: %s", err)
 	}
 	return sh, nil
 }
 
+// Returns the parser of syn for data, which also parses
+// the regions of data embedded in other syntaxes.
+func syntaxParser(syn Syntax, data string, depth int) (parser.Parser, error) {
+	pr, err := syn.Parser(data)
+	if err != nil {
+		return nil, err
+	}
+	se, ok := syn.(SyntaxEmbedder)
+	if !ok || depth >= maxEmbedDepth {
+		return pr, nil
+	}
+
+	ed := GetEditor()
+	runes := []rune(data)
+	var embeds []parser.Embedding
+	for _, es := range se.Embedded(data) {
+		a, b := es.Region.Begin(), es.Region.End()
+		if a < 0 || b > len(runes) || a == b {
+			continue
+		}
+		esyn := ed.GetSyntax(es.Syntax)
+		if esyn == nil {
+			esyn = ed.GetSyntax(ed.modeSyntax(es.Syntax))
+		}
+		if esyn == nil {
+			log.Fine("No syntax %s in editor to embed", es.Syntax)
+			continue
+		}
+		epr, err := syntaxParser(esyn, string(runes[a:b]), depth+1)
+		if err != nil {
+			log.Warn("Couldn't get parser from embedded syntax %s: %s", es.Syntax, err)
+			continue
+		}
+		embeds = append(embeds, parser.Embedding{Region: text.Region{A: a, B: b}, Parser: epr})
+	}
+	if len(embeds) == 0 {
+		return pr, nil
+	}
+	return parser.NewEmbeddingParser(pr, embeds), nil
+}
+
 type syntax struct{}
 
 func (s *syntax) Adjust(position, delta int) {}
@@ -5,11 +5,14 @@
 package backend
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/limetext/backend/parser"
 	"github.com/limetext/sublime/textmate/language"
 	"github.com/limetext/text"
+	qparser "github.com/quarnster/parser"
 )
 
 type dummySyntax struct {
@@ -38,8 +41,160 @@ func (s *dummySyntax) FileTypes() []string {
 	return s.l.FileTypes
 }
 
+func (s *dummySyntax) FirstLineMatch() string {
+	return s.l.FirstLineMatch
+}
+
+// embedSyntax is a plain text syntax whose
+// files embed the syntaxes of embeds.
+type embedSyntax struct {
+	embeds []EmbeddedSyntax
+}
+
+func (s *embedSyntax) Parser(data string) (parser.Parser, error) {
+	root := &qparser.Node{Name: "text.embed", Range: text.Region{A: 0, B: len([]rune(data))}}
+	return &dummyParser{root}, nil
+}
+
+func (s *embedSyntax) Name() string {
+	return "Embed"
+}
+
+func (s *embedSyntax) FileTypes() []string {
+	return nil
+}
+
+func (s *embedSyntax) Embedded(data string) []EmbeddedSyntax {
+	return s.embeds
+}
+
 func addSetSyntax(tb testing.TB, settings *text.Settings, path string) {
 	syn := newDummySytax(tb, path)
 	GetEditor().AddSyntax(path, syn)
 	settings.Set("syntax", path)
 }
+
+func TestDetectSyntax(t *testing.T) {
+	const (
+		golang = "testdata/Go.tmLanguage"
+		python = "testdata/Python.tmLanguage"
+	)
+	ed := GetEditor()
+	ed.AddSyntax(golang, newDummySytax(t, golang))
+	py := newDummySytax(t, golang)
+	py.l.Name, py.l.FileTypes, py.l.FirstLineMatch = "Python", []string{"py"}, ""
+	ed.AddSyntax(python, py)
+	defer func() {
+		delete(ed.syntaxes, python)
+		delete(ed.filetypes, "py")
+	}()
+
+	tests := []struct {
+		file string
+		data string
+		exts map[string]interface{}
+		exp  string
+	}{
+		{"a.go", "", nil, golang},
+		{"a.py", "", nil, python},
+		{"a.txt", "// -*- mode: go -*-", nil, golang},
+		{"a", "#!/usr/bin/env python3\n", nil, python},
+		{"a", "#!/usr/bin/python -u\n", nil, python},
+		{"a", "x" + strings.Repeat("\n", 20) + "# vim: set ft=python:", nil, python},
+		{"a.py", "// -*- go -*-", nil, golang},
+		{"a.tmpl", "", map[string]interface{}{"tmpl": golang}, golang},
+		{"a.go", "", map[string]interface{}{"go": python}, python},
+		{"a", "plain text", nil, ""},
+	}
+
+	w := ed.NewWindow()
+	defer w.Close()
+
+	for i, test := range tests {
+		v := w.NewFile()
+		v.SetFileName(test.file)
+		if test.exts != nil {
+			v.Settings().Set("extension_syntaxes", test.exts)
+		}
+		e := v.BeginEdit()
+		v.Insert(e, 0, test.data)
+		v.EndEdit(e)
+
+		if got := v.detectSyntax(); got != test.exp {
+			t.Errorf("Test %d: Expected syntax %q, but got %q", i, test.exp, got)
+		}
+		v.SetScratch(true)
+		v.Close()
+	}
+}
+
+func TestSetFileNameSyntax(t *testing.T) {
+	const golang = "testdata/Go.tmLanguage"
+	GetEditor().AddSyntax(golang, newDummySytax(t, golang))
+
+	w := GetEditor().NewWindow()
+	defer w.Close()
+
+	v := w.NewFile()
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+
+	v.SetFileName("a.go")
+	if got := v.Settings().String("syntax", ""); got != golang {
+		t.Errorf("Expected syntax %s, but got %s", golang, got)
+	}
+	// A name no syntax is detected for leaves the syntax alone
+	v.SetFileName("a")
+	if got := v.Settings().String("syntax", ""); got != golang {
+		t.Errorf("Expected syntax %s, but got %s", golang, got)
+	}
+}
+
+func TestEmbeddedSyntax(t *testing.T) {
+	const (
+		golang = "testdata/Go.tmLanguage"
+		host   = "testdata/Embed.syntax"
+	)
+	ed := GetEditor()
+	ed.AddSyntax(golang, newDummySytax(t, golang))
+	defer delete(ed.syntaxes, host)
+	// The data is "x package main", embedding go from the 2nd character
+	code := text.Region{A: 2, B: 14}
+	nested := strings.TrimSpace(strings.Repeat("text.embed ", maxEmbedDepth+1))
+
+	tests := []struct {
+		embeds []EmbeddedSyntax
+		exp    string
+	}{
+		{[]EmbeddedSyntax{{code, golang}}, "text.embed source.go keyword.control.go"},
+		// Mode names are resolved like in modelines
+		{[]EmbeddedSyntax{{code, "go"}}, "text.embed source.go keyword.control.go"},
+		{[]EmbeddedSyntax{{code, "testdata/Missing.tmLanguage"}}, "text.embed"},
+		{[]EmbeddedSyntax{{text.Region{A: -1, B: 14}, golang}}, "text.embed"},
+		{[]EmbeddedSyntax{{text.Region{A: 2, B: 15}, golang}}, "text.embed"},
+		{[]EmbeddedSyntax{{text.Region{A: 2, B: 2}, golang}}, "text.embed"},
+		// A syntax embedding itself is only embedded so deep
+		{[]EmbeddedSyntax{{text.Region{A: 0, B: 14}, host}}, nested},
+	}
+
+	w := ed.NewWindow()
+	defer w.Close()
+	for i, test := range tests {
+		ed.AddSyntax(host, &embedSyntax{test.embeds})
+		v := w.NewFile()
+		v.Settings().Set("syntax", host)
+		e := v.BeginEdit()
+		v.Insert(e, 0, "x package main")
+		v.EndEdit(e)
+		if !v.waitForParse(time.Second) {
+			t.Fatalf("Test %d: Parsing the view took too long", i)
+		}
+		if got := v.ScopeName(2); got != test.exp {
+			t.Errorf("Test %d: Expected the scope name %q, but got %q", i, test.exp, got)
+		}
+		v.SetScratch(true)
+		v.Close()
+	}
+}
@@ -66,6 +66,9 @@ func (us *UndoStack) Undo(hard bool) {
 		// Nothing to undo
 		return
 	}
+	if us.readOnly() {
+		return
+	}
 	to := us.index(0, hard)
 	if to == -1 {
 		to = 0
@@ -86,6 +89,9 @@ func (us *UndoStack) Redo(hard bool) {
 		// No more actions to redo
 		return
 	}
+	if us.readOnly() {
+		return
+	}
 	to := us.index(1, hard)
 	if to == -1 {
 		to = len(us.actions)
@@ -96,6 +102,17 @@ func (us *UndoStack) Redo(hard bool) {
 	}
 }
 
+// Returns whether the View of the actions is read-only,
+// in which case they can't be undone nor redone.
+func (us *UndoStack) readOnly() bool {
+	for _, a := range us.actions {
+		if a.v != nil {
+			return a.v.IsReadOnly()
+		}
+	}
+	return false
+}
+
 // Returns the current position in the UndoStack.
 func (us *UndoStack) Position() int {
 	return us.position
@@ -0,0 +1,51 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package vfs
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/limetext/backend/watch"
+)
+
+// Local is the local file system, whose files
+// are watched with Watcher if it's not nil.
+type Local struct {
+	Watcher *watch.Watcher
+}
+
+var errNoWatcher = errors.New("no watcher for the local file system")
+
+func (l *Local) Open(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+func (l *Local) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (l *Local) Write(path string) (io.WriteCloser, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (l *Local) Rename(from, to string) error {
+	return os.Rename(from, to)
+}
+
+func (l *Local) Watch(path string, cb interface{}) error {
+	if l.Watcher == nil {
+		return errNoWatcher
+	}
+	return l.Watcher.Watch(path, cb)
+}
+
+func (l *Local) UnWatch(path string, cb interface{}) error {
+	if l.Watcher == nil {
+		return errNoWatcher
+	}
+	return l.Watcher.UnWatch(path, cb)
+}
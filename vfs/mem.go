@@ -0,0 +1,190 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package vfs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/limetext/backend/watch"
+)
+
+type (
+	// Mem is a file system in memory, e.g for test fixtures. It has
+	// no directories, only files, which are written once the writers
+	// of Write are closed. Callbacks are called by the goroutine
+	// which changed the file.
+	Mem struct {
+		lock    sync.Mutex
+		files   map[string]*memFile
+		watched map[string][]interface{}
+	}
+
+	memFile struct {
+		data    []byte
+		modTime time.Time
+	}
+
+	memFileInfo struct {
+		name string
+		*memFile
+	}
+
+	memWriter struct {
+		bytes.Buffer
+		m    *Mem
+		path string
+	}
+)
+
+// NewMem returns an empty file system in memory.
+func NewMem() *Mem {
+	return &Mem{
+		files:   make(map[string]*memFile),
+		watched: make(map[string][]interface{}),
+	}
+}
+
+func (fi memFileInfo) Name() string       { return path.Base(fi.name) }
+func (fi memFileInfo) Size() int64        { return int64(len(fi.data)) }
+func (fi memFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memFileInfo) IsDir() bool        { return false }
+func (fi memFileInfo) Sys() interface{}   { return nil }
+
+func (m *Mem) Open(p string) (io.ReadCloser, error) {
+	p = path.Clean(p)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	f := m.files[p]
+	if f == nil {
+		return nil, &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+	}
+	return ioutil.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+func (m *Mem) Stat(p string) (os.FileInfo, error) {
+	p = path.Clean(p)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	f := m.files[p]
+	if f == nil {
+		return nil, &os.PathError{Op: "stat", Path: p, Err: os.ErrNotExist}
+	}
+	// Files are replaced rather than changed when written, so f is kept as it is
+	return memFileInfo{p, f}, nil
+}
+
+func (m *Mem) Write(p string) (io.WriteCloser, error) {
+	return &memWriter{m: m, path: path.Clean(p)}, nil
+}
+
+func (w *memWriter) Close() error {
+	w.m.put(w.path, append([]byte(nil), w.Bytes()...), "")
+	return nil
+}
+
+// Makes data the content of the file p, which was renamed from
+// the file from if it's not empty, and calls the callbacks.
+func (m *Mem) put(p string, data []byte, from string) {
+	m.lock.Lock()
+	old := m.files[p]
+	f := &memFile{data: data, modTime: time.Now()}
+	// Each write is told apart by its time
+	if old != nil && !f.modTime.After(old.modTime) {
+		f.modTime = old.modTime.Add(time.Nanosecond)
+	}
+	m.files[p] = f
+	var renamed []interface{}
+	if from != "" {
+		delete(m.files, from)
+		renamed = m.watched[from]
+	}
+	cbs := append([]interface{}(nil), m.watched[p]...)
+	m.lock.Unlock()
+
+	for _, cb := range renamed {
+		if c, ok := cb.(watch.FileRenamedCallback); ok {
+			c.FileRenamed(from)
+		}
+	}
+	for _, cb := range cbs {
+		if c, ok := cb.(watch.FileCreatedCallback); ok && old == nil {
+			c.FileCreated(p)
+		} else if c, ok := cb.(watch.FileChangedCallback); ok && old != nil {
+			c.FileChanged(p)
+		}
+	}
+}
+
+func (m *Mem) Rename(from, to string) error {
+	from, to = path.Clean(from), path.Clean(to)
+	m.lock.Lock()
+	f := m.files[from]
+	m.lock.Unlock()
+	if f == nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrNotExist}
+	}
+	if from != to {
+		m.put(to, f.data, from)
+	}
+	return nil
+}
+
+// Remove removes the file p.
+func (m *Mem) Remove(p string) error {
+	p = path.Clean(p)
+	m.lock.Lock()
+	f := m.files[p]
+	delete(m.files, p)
+	cbs := append([]interface{}(nil), m.watched[p]...)
+	m.lock.Unlock()
+	if f == nil {
+		return &os.PathError{Op: "remove", Path: p, Err: os.ErrNotExist}
+	}
+	for _, cb := range cbs {
+		if c, ok := cb.(watch.FileRemovedCallback); ok {
+			c.FileRemoved(p)
+		}
+	}
+	return nil
+}
+
+func (m *Mem) Watch(p string, cb interface{}) error {
+	p = path.Clean(p)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, c := range m.watched[p] {
+		if c == cb {
+			return nil
+		}
+	}
+	m.watched[p] = append(m.watched[p], cb)
+	return nil
+}
+
+func (m *Mem) UnWatch(p string, cb interface{}) error {
+	p = path.Clean(p)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	cbs := m.watched[p]
+	for i, c := range cbs {
+		if c == cb {
+			cbs = append(cbs[:i:i], cbs[i+1:]...)
+			break
+		}
+	}
+	if cb == nil || len(cbs) == 0 {
+		delete(m.watched, p)
+	} else {
+		m.watched[p] = cbs
+	}
+	return nil
+}
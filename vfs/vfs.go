@@ -0,0 +1,279 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+// Package vfs provides the file systems files are read from and written to,
+// chosen by the scheme of their URI. URIs without a scheme, such as plain
+// paths, are on the local file system.
+//
+//	/home/user/file.txt        the local file system
+//	file:///home/user/file.txt the local file system
+//	mem://fixtures/file.txt    a file system in memory
+//	zip://archive.zip/file.txt a file in a zip archive, read-only
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/limetext/backend/watch"
+)
+
+// The schemes of the built-in file systems
+const (
+	LOCAL = "file"
+	MEM   = "mem"
+	ZIP   = "zip"
+)
+
+type (
+	// A FS is a file system. The paths it's given are the
+	// URIs of its files without their scheme.
+	FS interface {
+		// Opens the file path for reading.
+		Open(path string) (io.ReadCloser, error)
+		// Returns the FileInfo of the file path.
+		Stat(path string) (os.FileInfo, error)
+		// Creates the file path, or truncates it if it exists, for
+		// writing. What's written may only be in the file once the
+		// writer is closed.
+		Write(path string) (io.WriteCloser, error)
+		// Renames the file from to to, replacing to if it exists.
+		Rename(from, to string) error
+		// Calls the callbacks of cb when the file path changes, see
+		// the callback interfaces of the watch package.
+		Watch(path string, cb interface{}) error
+		// Stops calling the callbacks of cb when the file path changes.
+		UnWatch(path string, cb interface{}) error
+	}
+
+	// Calls the callbacks of cb with the URIs of
+	// the paths a file system calls them with
+	watcher struct {
+		scheme string
+		cb     interface{}
+	}
+
+	watcherKey struct {
+		uri string
+		cb  interface{}
+	}
+)
+
+// Returned when writing to a read-only file system
+var ErrReadOnly = errors.New("read-only file system")
+
+var (
+	fss = struct {
+		sync.Mutex
+		m map[string]FS
+	}{m: make(map[string]FS)}
+
+	watchers = struct {
+		sync.Mutex
+		m map[watcherKey]*watcher
+	}{m: make(map[watcherKey]*watcher)}
+)
+
+func (w *watcher) FileChanged(path string) {
+	if cb, ok := w.cb.(watch.FileChangedCallback); ok {
+		cb.FileChanged(w.scheme + "://" + path)
+	}
+}
+
+func (w *watcher) FileCreated(path string) {
+	if cb, ok := w.cb.(watch.FileCreatedCallback); ok {
+		cb.FileCreated(w.scheme + "://" + path)
+	}
+}
+
+func (w *watcher) FileRemoved(path string) {
+	if cb, ok := w.cb.(watch.FileRemovedCallback); ok {
+		cb.FileRemoved(w.scheme + "://" + path)
+	}
+}
+
+func (w *watcher) FileRenamed(path string) {
+	if cb, ok := w.cb.(watch.FileRenamedCallback); ok {
+		cb.FileRenamed(w.scheme + "://" + path)
+	}
+}
+
+// Register makes fs the file system of the URIs with the given
+// scheme, replacing the one it had if any.
+func Register(scheme string, fs FS) {
+	fss.Lock()
+	defer fss.Unlock()
+	fss.m[strings.ToLower(scheme)] = fs
+}
+
+// Get returns the file system of the given scheme, or nil if there's none.
+func Get(scheme string) FS {
+	fss.Lock()
+	defer fss.Unlock()
+	return fss.m[strings.ToLower(scheme)]
+}
+
+// Split returns the scheme and the path of uri. The scheme of
+// URIs without one, such as plain paths, is LOCAL.
+func Split(uri string) (scheme, path string) {
+	i := strings.Index(uri, "://")
+	if i <= 0 {
+		return LOCAL, uri
+	}
+	for j, r := range uri[:i] {
+		alpha := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+		if !alpha && (j == 0 || !(r >= '0' && r <= '9' || r == '+' || r == '-' || r == '.')) {
+			return LOCAL, uri
+		}
+	}
+	return strings.ToLower(uri[:i]), uri[i+3:]
+}
+
+// IsLocal returns whether uri is on the local file system.
+func IsLocal(uri string) bool {
+	scheme, _ := Split(uri)
+	return scheme == LOCAL
+}
+
+// Abs returns the absolute path of a local path without a scheme,
+// and uri as it is otherwise.
+func Abs(uri string) string {
+	if !strings.Contains(uri, "://") {
+		if abs, err := filepath.Abs(uri); err == nil {
+			return abs
+		}
+	}
+	return uri
+}
+
+// Returns the file system and path of uri.
+func lookup(uri string) (FS, string, error) {
+	scheme, path := Split(uri)
+	if fs := Get(scheme); fs != nil {
+		return fs, path, nil
+	}
+	return nil, "", fmt.Errorf("no file system for %s", uri)
+}
+
+// Open opens the file uri for reading.
+func Open(uri string) (io.ReadCloser, error) {
+	fs, path, err := lookup(uri)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Open(path)
+}
+
+// Stat returns the FileInfo of the file uri.
+func Stat(uri string) (os.FileInfo, error) {
+	fs, path, err := lookup(uri)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(path)
+}
+
+// Write creates or truncates the file uri for writing.
+func Write(uri string) (io.WriteCloser, error) {
+	fs, path, err := lookup(uri)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Write(path)
+}
+
+// Rename renames the file from to to, which must be on the same file system.
+func Rename(from, to string) error {
+	fs, fp, err := lookup(from)
+	if err != nil {
+		return err
+	}
+	tfs, tp, err := lookup(to)
+	if err != nil {
+		return err
+	}
+	if fs != tfs {
+		return fmt.Errorf("can't rename %s to %s on another file system", from, to)
+	}
+	return fs.Rename(fp, tp)
+}
+
+// ReadFile reads all of the file uri.
+func ReadFile(uri string) ([]byte, error) {
+	r, err := Open(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// WriteFile writes data to the file uri.
+func WriteFile(uri string, data []byte) error {
+	w, err := Write(uri)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Watch calls the callbacks of cb, with uri, when the file uri changes.
+func Watch(uri string, cb interface{}) error {
+	fs, path, err := lookup(uri)
+	if err != nil {
+		return err
+	}
+	if path == uri {
+		return fs.Watch(path, cb)
+	}
+	scheme, _ := Split(uri)
+	watchers.Lock()
+	defer watchers.Unlock()
+	k := watcherKey{uri, cb}
+	if w := watchers.m[k]; w != nil {
+		return nil
+	}
+	w := &watcher{scheme, cb}
+	if err := fs.Watch(path, w); err != nil {
+		return err
+	}
+	watchers.m[k] = w
+	return nil
+}
+
+// UnWatch stops calling the callbacks of cb when the file uri changes.
+func UnWatch(uri string, cb interface{}) error {
+	fs, path, err := lookup(uri)
+	if err != nil {
+		return err
+	}
+	if path == uri {
+		return fs.UnWatch(path, cb)
+	}
+	watchers.Lock()
+	defer watchers.Unlock()
+	k := watcherKey{uri, cb}
+	w := watchers.m[k]
+	if w == nil {
+		return nil
+	}
+	delete(watchers.m, k)
+	return fs.UnWatch(path, w)
+}
+
+func init() {
+	Register(LOCAL, &Local{})
+	Register(MEM, NewMem())
+	Register(ZIP, &Zip{})
+}
@@ -0,0 +1,136 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package vfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type dummyCallback struct {
+	sync.Mutex
+	calls []string
+}
+
+func (d *dummyCallback) add(s string) {
+	d.Lock()
+	defer d.Unlock()
+	d.calls = append(d.calls, s)
+}
+
+func (d *dummyCallback) FileChanged(name string) { d.add("changed " + name) }
+func (d *dummyCallback) FileCreated(name string) { d.add("created " + name) }
+func (d *dummyCallback) FileRemoved(name string) { d.add("removed " + name) }
+func (d *dummyCallback) FileRenamed(name string) { d.add("renamed " + name) }
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		uri, scheme, path string
+	}{
+		{"/home/file.txt", LOCAL, "/home/file.txt"},
+		{"file.txt", LOCAL, "file.txt"},
+		{`C:\dir\file.txt`, LOCAL, `C:\dir\file.txt`},
+		{"file:///home/file.txt", LOCAL, "/home/file.txt"},
+		{"mem://fixtures/file.txt", MEM, "fixtures/file.txt"},
+		{"MEM://file.txt", MEM, "file.txt"},
+		{"zip://dir/a.zip/file.txt", ZIP, "dir/a.zip/file.txt"},
+		{"svn+ssh://host/file", "svn+ssh", "host/file"},
+		{"://file", LOCAL, "://file"},
+		{"dir/a://b", LOCAL, "dir/a://b"},
+	}
+	for i, test := range tests {
+		if s, p := Split(test.uri); s != test.scheme || p != test.path {
+			t.Errorf("Test %d: Expected %q and %q, but got %q and %q", i, test.scheme, test.path, s, p)
+		}
+	}
+}
+
+func TestMem(t *testing.T) {
+	Register("test", NewMem())
+	defer Register("test", nil)
+
+	if _, err := Stat("test://a"); !os.IsNotExist(err) {
+		t.Errorf("Expected the file not to exist, but got %v", err)
+	}
+	cb := &dummyCallback{}
+	if err := Watch("test://a", cb); err != nil {
+		t.Fatal(err)
+	}
+	if err := Watch("test://b", cb); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile("test://a", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := Stat("test://a")
+	if err != nil || fi.Size() != 5 || fi.Name() != "a" {
+		t.Fatalf("Expected the file to be written, but got %v, %v", fi, err)
+	}
+	if err := WriteFile("test://a", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if fi2, _ := Stat("test://a"); !fi2.ModTime().After(fi.ModTime()) {
+		t.Errorf("Expected the modification time %s to be after %s", fi2.ModTime(), fi.ModTime())
+	}
+	if d, err := ReadFile("test://a"); err != nil || string(d) != "second" {
+		t.Errorf("Expected %q, but got %q, %v", "second", d, err)
+	}
+
+	if err := Rename("test://a", "test://b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Stat("test://a"); !os.IsNotExist(err) {
+		t.Errorf("Expected the renamed file not to exist, but got %v", err)
+	}
+	if d, _ := ReadFile("test://b"); string(d) != "second" {
+		t.Errorf("Expected the file to be renamed, but got %q", d)
+	}
+	if err := Rename("test://b", "mem://b"); err == nil {
+		t.Error("Expected an error renaming to another file system")
+	}
+
+	if err := UnWatch("test://a", cb); err != nil {
+		t.Fatal(err)
+	}
+	WriteFile("test://a", nil)
+	exp := []string{"created test://a", "changed test://a", "renamed test://a", "created test://b"}
+	if !reflect.DeepEqual(cb.calls, exp) {
+		t.Errorf("Expected the callbacks %v, but got %v", exp, cb.calls)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "file")
+
+	for _, uri := range []string{fn, "file://" + fn} {
+		if err := WriteFile(uri, []byte(uri)); err != nil {
+			t.Fatal(err)
+		}
+		if d, err := ioutil.ReadFile(fn); err != nil || string(d) != uri {
+			t.Errorf("Expected %q to be written, but got %q, %v", uri, d, err)
+		}
+	}
+	if err := Rename(fn, fn+"2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Stat(fn + "2"); err != nil {
+		t.Errorf("Expected the file to be renamed, but got %s", err)
+	}
+	if abs := Abs("file"); !filepath.IsAbs(abs) {
+		t.Errorf("Expected an absolute path, but got %s", abs)
+	}
+	if abs := Abs("mem://file"); abs != "mem://file" {
+		t.Errorf("Expected the URI to be left alone, but got %s", abs)
+	}
+}
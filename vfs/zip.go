@@ -0,0 +1,187 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package vfs
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/limetext/backend/watch"
+)
+
+type (
+	// Zip is the read-only file system of the files in zip archives,
+	// whose paths are the path of an archive on the local file system
+	// followed by the path of a file in it, e.g "dir/archive.zip/file".
+	// The files of an archive are watched by watching the archive.
+	Zip struct {
+		lock    sync.Mutex
+		watched map[watcherKey]*zipWatcher
+	}
+
+	// Calls the callbacks of cb with the path
+	// of a file when its archive changes
+	zipWatcher struct {
+		path string
+		cb   interface{}
+	}
+
+	// A zipReader closes its archive when closed
+	zipReader struct {
+		io.ReadCloser
+		archive *zip.ReadCloser
+	}
+
+	// The FileInfo of a file in an archive, which
+	// is modified when its archive is
+	zipFileInfo struct {
+		os.FileInfo
+		modTime time.Time
+	}
+)
+
+// Returns the path of the archive path is in and
+// the path of the file in it, "" if it's the archive.
+func splitZip(path string) (archive, file string, err error) {
+	path = strings.Replace(path, "\\", "/", -1)
+	for i := 0; i < len(path); {
+		j := strings.Index(path[i:], "/")
+		if j == -1 {
+			j = len(path)
+		} else {
+			j += i
+		}
+		if p := path[:j]; strings.HasSuffix(strings.ToLower(p), ".zip") {
+			if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+				return p, strings.TrimPrefix(path[j:], "/"), nil
+			}
+		}
+		i = j + 1
+	}
+	return "", "", &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+}
+
+// Opens the archive path is in, and returns the file.
+func openZip(op, path string) (*zip.ReadCloser, *zip.File, error) {
+	archive, name, err := splitZip(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, f := range r.File {
+		if f.Name == name {
+			return r, f, nil
+		}
+	}
+	r.Close()
+	return nil, nil, &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+}
+
+func (fi zipFileInfo) Mode() os.FileMode {
+	return fi.FileInfo.Mode() &^ 0222
+}
+
+func (fi zipFileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (r *zipReader) Close() error {
+	r.ReadCloser.Close()
+	return r.archive.Close()
+}
+
+func (z *Zip) Open(path string) (io.ReadCloser, error) {
+	r, f, err := openZip("open", path)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := f.Open()
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return &zipReader{rc, r}, nil
+}
+
+func (z *Zip) Stat(path string) (os.FileInfo, error) {
+	r, f, err := openZip("stat", path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	archive, _, err := splitZip(path)
+	if err != nil {
+		return nil, err
+	}
+	afi, err := os.Stat(archive)
+	if err != nil {
+		return nil, err
+	}
+	return zipFileInfo{f.FileInfo(), afi.ModTime()}, nil
+}
+
+func (z *Zip) Write(path string) (io.WriteCloser, error) {
+	return nil, &os.PathError{Op: "write", Path: path, Err: ErrReadOnly}
+}
+
+func (z *Zip) Rename(from, to string) error {
+	return &os.LinkError{Op: "rename", Old: from, New: to, Err: ErrReadOnly}
+}
+
+func (w *zipWatcher) FileChanged(string) {
+	if cb, ok := w.cb.(watch.FileChangedCallback); ok {
+		cb.FileChanged(w.path)
+	}
+}
+
+func (w *zipWatcher) FileRemoved(string) {
+	if cb, ok := w.cb.(watch.FileRemovedCallback); ok {
+		cb.FileRemoved(w.path)
+	}
+}
+
+func (z *Zip) Watch(path string, cb interface{}) error {
+	archive, _, err := splitZip(path)
+	if err != nil {
+		return err
+	}
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	k := watcherKey{path, cb}
+	if z.watched == nil {
+		z.watched = make(map[watcherKey]*zipWatcher)
+	} else if z.watched[k] != nil {
+		return nil
+	}
+	w := &zipWatcher{path, cb}
+	if err := Get(LOCAL).Watch(archive, w); err != nil {
+		return err
+	}
+	z.watched[k] = w
+	return nil
+}
+
+func (z *Zip) UnWatch(path string, cb interface{}) error {
+	archive, _, err := splitZip(path)
+	if err != nil {
+		return err
+	}
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	k := watcherKey{path, cb}
+	w := z.watched[k]
+	if w == nil {
+		return nil
+	}
+	delete(z.watched, k)
+	return Get(LOCAL).UnWatch(archive, w)
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package vfs
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "fixtures.zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, data := range map[string]string{
+		"a.txt":     "a",
+		"dir/b.txt": "b",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(data))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	tests := []struct {
+		uri  string
+		data string
+	}{
+		{"zip://" + archive + "/a.txt", "a"},
+		{"zip://" + archive + "/dir/b.txt", "b"},
+	}
+	for i, test := range tests {
+		if d, err := ReadFile(test.uri); err != nil || string(d) != test.data {
+			t.Errorf("Test %d: Expected %q, but got %q, %v", i, test.data, d, err)
+		}
+		fi, err := Stat(test.uri)
+		if err != nil {
+			t.Errorf("Test %d: Couldn't stat %s: %s", i, test.uri, err)
+		} else if fi.Mode()&0222 != 0 {
+			t.Errorf("Test %d: Expected a read-only file, but got %s", i, fi.Mode())
+		}
+	}
+
+	for _, uri := range []string{
+		"zip://" + archive + "/missing.txt",
+		"zip://" + filepath.Join(dir, "missing.zip") + "/a.txt",
+	} {
+		if _, err := Open(uri); !os.IsNotExist(err) {
+			t.Errorf("Expected %s not to exist, but got %v", uri, err)
+		}
+	}
+	if err := WriteFile("zip://"+archive+"/a.txt", nil); err == nil {
+		t.Error("Expected an error writing to an archive")
+	}
+}
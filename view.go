@@ -5,8 +5,9 @@
 package backend
 
 import (
+	"bufio"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path"
 	"reflect"
@@ -19,6 +20,7 @@ import (
 	"github.com/limetext/backend/packages"
 	"github.com/limetext/backend/parser"
 	"github.com/limetext/backend/render"
+	"github.com/limetext/backend/vfs"
 	"github.com/limetext/rubex"
 	"github.com/limetext/text"
 	"github.com/limetext/util"
@@ -31,25 +33,60 @@ type (
 	View struct {
 		text.HasSettings
 		text.HasId
-		window           *Window
-		buffer           text.Buffer
-		selection        text.RegionSet
-		undoStack        UndoStack
-		scratch          bool
-		overwrite        bool
-		cursyntax        string
-		syntax           parser.SyntaxHighlighter
-		regions          render.ViewRegionMap
+		window    *Window
+		buffer    text.Buffer
+		selection text.RegionSet
+		undoStack UndoStack
+		scratch   bool
+		overwrite bool
+		readOnly  bool
+		cursyntax string
+		syntax    parser.SyntaxHighlighter
+		regions   render.ViewRegionMap
+		folds     text.RegionSet
+		phantoms  []render.Phantom
+		phantomId int
+		// The name of the encoding, and the line ending
+		// style, the file is saved with
+		encoding   string
+		lineEnding string
+		// The name of the FileCodec the file is read and written with
+		codec string
+		// The buffer's content when it was last loaded or saved, which
+		// is only kept once the buffer has changed since, see savedText
+		saved     string
+		savedKept bool
+		// The file as it was then, for telling our own saves apart
+		// from changes made by other programs
+		savedFile os.FileInfo
+		// The changes of the buffer's lines since it was saved,
+		// and the ChangeCount they were diffed at
+		lineChanges   []render.LineChange
+		lineChangesAt int
+		// The last Minimap, what it was made from and the selection
+		// at the time, as the whole buffer is scanned to make one
+		minimap    *render.Minimap
+		minimapKey minimapKey
+		minimapSel []text.Region
+		// Counts the changes to regions, and the last buffer
+		// edits, for telling RenderStates what changed
+		regionsChanged   int
+		renderEdits      []renderEdit
 		editstack        []*Edit
 		lock             sync.Mutex
-		reparseChan      chan parseReq
+		closed           bool
+		lastParse        int
+		parsePanics      int
 		status           map[string]string
 		defaultSettings  *text.HasSettings
 		platformSettings *text.HasSettings
 		userSettings     *text.HasSettings
 	}
-	parseReq struct {
-		forced bool
+
+	// What a Minimap of a View is made from
+	minimapKey struct {
+		changeCount, regions, buckets int
+		scheme                        string
 	}
 )
 
@@ -58,7 +95,7 @@ func newView(w *Window) *View {
 		window:           w,
 		regions:          make(render.ViewRegionMap),
 		status:           make(map[string]string),
-		reparseChan:      make(chan parseReq, 32),
+		lastParse:        -1,
 		defaultSettings:  new(text.HasSettings),
 		platformSettings: new(text.HasSettings),
 		userSettings:     new(text.HasSettings),
@@ -74,6 +111,12 @@ func newView(w *Window) *View {
 	v.Settings().SetParent(v.userSettings)
 
 	v.loadSettings()
+	ed.loadColorScheme(v.Settings().String("color_scheme", ""))
+	v.Settings().AddOnChange("backend.view.color_scheme", func(name string) {
+		if name == "color_scheme" {
+			ed.loadColorScheme(v.Settings().String("color_scheme", ""))
+		}
+	})
 	v.Settings().AddOnChange("backend.view.syntax", func(name string) {
 		if name != "syntax" {
 			return
@@ -87,7 +130,6 @@ func newView(w *Window) *View {
 			v.reparse(true)
 		}
 	})
-	go v.parsethread()
 	v.Settings().Set("is_widget", false)
 
 	return v
@@ -103,7 +145,6 @@ func (v *View) setBuffer(b text.Buffer) error {
 		return fmt.Errorf("There is already a buffer set")
 	}
 	v.buffer = b
-	// TODO(q): Dynamically load the correct syntax file
 	b.AddObserver(v)
 	return nil
 }
@@ -111,10 +152,12 @@ func (v *View) setBuffer(b text.Buffer) error {
 // BufferObserver
 
 func (v *View) Erased(changed_buffer text.Buffer, region_removed text.Region, data_removed []rune) {
+	v.keepSaved(region_removed.A, region_removed.A, string(data_removed))
 	v.flush(region_removed.B, region_removed.A-region_removed.B)
 }
 
 func (v *View) Inserted(changed_buffer text.Buffer, region_inserted text.Region, data_inserted []rune) {
+	v.keepSaved(region_inserted.A, region_inserted.B, "")
 	v.flush(region_inserted.A, region_inserted.B-region_inserted.A)
 }
 
@@ -139,96 +182,119 @@ func (v *View) flush(position, delta int) {
 			v2.Regions.Adjust(position, delta)
 			v.regions[k] = v2
 		}
+		v.folds.Adjust(position, delta)
+		for i := range v.phantoms {
+			v.phantoms[i].Region.Adjust(position, delta)
+		}
+		if len(v.renderEdits) == maxRenderEdits {
+			v.renderEdits = append(v.renderEdits[:0], v.renderEdits[1:]...)
+		}
+		v.renderEdits = append(v.renderEdits, renderEdit{v.ChangeCount(), position, delta})
 	}()
 	OnModified.Call(v)
 	v.reparse(false)
 }
 
-// parsethread() would be the go-routine used for dealing with reparsing the
-// current buffer when it has been modified. Each opened view has its own
-// go-routine parsethread() which sits idle and waits for requests to be sent
-// on this view's reparseChan.
+// parse is called by the parse pool's worker go-routines, which are shared
+// between all the views, when a reparse of this view has been requested.
+// See parsePool for how the views to parse are picked.
 //
 // The Buffer's ChangeCount, as well as the parse request's "forced" attribute
 // is used to determined if a parse actually needs to happen or not.
 //
 // If it is decided that a reparse should take place, a snapshot of the Buffer is
-// taken and a parse is performed. Upon completion of this parse operation,
-// and if the snapshot of the buffer has not already become outdated,
-// then the regions of the view associated with syntax highlighting is updated.
+// taken and a parse is performed. The parse is abandoned if ctx is cancelled,
+// which happens when the Buffer is modified again before the parse has finished.
+// Upon completion of this parse operation, and if the snapshot of the buffer has
+// not already become outdated, then the regions of the view associated with
+// syntax highlighting is updated.
 //
 // Changes made to the Buffer during the time when there is no accurate
 // parse of the buffer is a monkey-patched version of the old syntax highlighting
 // regions, which in most instances will be accurate.
 //
 // See package backend/parser for more details.
-func (v *View) parsethread() {
-	pc := 0
-	lastParse := -1
-	doparse := func() (ret bool) {
-		p := util.Prof.Enter("syntax.parse")
-		defer p.Exit()
-		defer func() {
-			if r := recover(); r != nil {
-				log.Error("Panic in parse thread: %v\n%s", r, string(debug.Stack()))
-				if pc > 0 {
-					panic(r)
-				}
-				pc++
-			}
-		}()
-
-		data := v.Substr(text.Region{0, v.Size()})
-		syntax := v.Settings().String("syntax", "")
-		sh := syntaxHighlighter(syntax, data)
-
-		// Only set if it isn't invalid already, otherwise the
-		// current syntax highlighting will be more accurate
-		// as it will have had incremental adjustments done to it
-		if v.ChangeCount() != lastParse {
-			return
-		}
-
+func (v *View) parse(ctx context.Context, forced bool) {
+	cc := v.ChangeCount()
+	v.lock.Lock()
+	if cc == v.lastParse && !forced {
+		v.lock.Unlock()
+		return
+	}
+	v.lastParse = cc
+	v.lock.Unlock()
+	if v.doparse(ctx, cc) {
+		v.Settings().Set("lime.syntax.updated", cc)
+	} else if ctx.Err() != nil {
+		// Make sure the next request parses
+		// whether it's forced or not
 		v.lock.Lock()
-		defer v.lock.Unlock()
+		v.lastParse = -1
+		v.lock.Unlock()
+	}
+}
 
-		v.syntax = sh
-		for k := range v.regions {
-			if strings.HasPrefix(k, "lime.syntax") {
-				delete(v.regions, k)
+// Parses the buffer as it was at the ChangeCount cc.
+func (v *View) doparse(ctx context.Context, cc int) (ret bool) {
+	p := util.Prof.Enter("syntax.parse")
+	defer p.Exit()
+	defer func() {
+		if r := recover(); r != nil {
+			v.lock.Lock()
+			v.parsePanics++
+			n := v.parsePanics
+			v.lock.Unlock()
+			// A syntax which panics will likely do so on each
+			// change, so the stack is only logged the first time
+			if n == 1 {
+				log.Error("Panic in parse thread: %v\n%s", r, string(debug.Stack()))
+			} else {
+				log.Error("Panic in parse thread: %v", r)
 			}
 		}
+	}()
 
-		for k, v2 := range sh.Flatten() {
-			if v2.Regions.HasNonEmpty() {
-				v.regions[k] = v2
-			}
-		}
+	// Large files aren't highlighted, see IsLargeFile
+	var data, syntax string
+	if !v.IsLargeFile() {
+		data = v.Substr(text.Region{0, v.Size()})
+		syntax = v.Settings().String("syntax", "")
+	}
+	sh := syntaxHighlighter(ctx, syntax, data)
 
-		return true
+	// Only set if it isn't invalid already, otherwise the
+	// current syntax highlighting will be more accurate
+	// as it will have had incremental adjustments done to it
+	if sh == nil {
+		return
 	}
 
 	v.lock.Lock()
-	ch := v.reparseChan
-	v.lock.Unlock()
-	defer v.cleanup()
-	if ch == nil {
+	defer v.lock.Unlock()
+	if v.ChangeCount() != cc {
 		return
 	}
 
-	for pr := range ch {
-		if cc := v.ChangeCount(); lastParse != cc || pr.forced {
-			lastParse = cc
-			if doparse() {
-				v.Settings().Set("lime.syntax.updated", lastParse)
-			}
+	v.syntax = sh
+	for k := range v.regions {
+		if strings.HasPrefix(k, "lime.syntax") {
+			delete(v.regions, k)
 		}
 	}
+
+	for k, v2 := range sh.Flatten() {
+		if v2.Regions.HasNonEmpty() {
+			v.regions[k] = v2
+		}
+	}
+	v.regionsChanged++
+
+	return true
 }
 
-// Send a reparse request via the reparse channel.
-// If "forced" is set to true, then a reparse will be made
-// even if the Buffer appears to not have changed.
+// Send a reparse request to the parse pool, cancelling any parse
+// of this view in progress. If "forced" is set to true, then a
+// reparse will be made even if the Buffer appears to not have changed.
 //
 // The actual parsing is done in a separate go-routine, for which the
 // "lime.syntax.updated" setting will be set once it has finished.
@@ -240,9 +306,7 @@ func (v *View) reparse(forced bool) {
 		// No point in issuing a re-parse if the view has been closed
 		return
 	}
-	if len(v.reparseChan) < cap(v.reparseChan) || forced {
-		v.reparseChan <- parseReq{forced}
-	}
+	parsers.add(v, forced)
 }
 
 // Will load view settings respect to current syntax
@@ -343,6 +407,10 @@ func (v *View) Window() *Window {
 // Tabs are (sometimes, depending on the View's settings) translated to spaces.
 // The return value is the length of the string that was inserted.
 func (v *View) Insert(edit *Edit, point int, value string) int {
+	if edit.readOnly {
+		v.refuseEdit(edit, "inserting into")
+		return 0
+	}
 	if t := v.Settings().Bool("translate_tabs_to_spaces", false); t && strings.Contains(value, "\t") {
 		tab_size := v.Settings().Int("tab_size", 4)
 		lines := strings.Split(value, "\n")
@@ -378,14 +446,35 @@ func (v *View) Insert(edit *Edit, point int, value string) int {
 
 // Adds an Erase action of the given Region to the provided Edit object.
 func (v *View) Erase(edit *Edit, r text.Region) {
+	if edit.readOnly {
+		v.refuseEdit(edit, "erasing from")
+		return
+	}
 	edit.composite.AddExec(text.NewEraseAction(v.buffer, r))
 }
 
 // Adds a Replace action of the given Region to the provided Edit object.
 func (v *View) Replace(edit *Edit, r text.Region, value string) {
+	if edit.readOnly {
+		v.refuseEdit(edit, "replacing in")
+		return
+	}
 	edit.composite.AddExec(text.NewReplaceAction(v.buffer, r, value))
 }
 
+// Called instead of changing the buffer with edit, as the View was
+// read-only when edit began. The user is told the first time.
+func (v *View) refuseEdit(edit *Edit, what string) {
+	log.Fine("Not %s read-only view %s", what, v)
+	if edit.refused {
+		return
+	}
+	edit.refused = true
+	if fe := GetEditor().Frontend(); fe != nil {
+		fe.StatusMessage("The view is read-only")
+	}
+}
+
 // Creates a new Edit object. Think of it a bit like starting an SQL transaction.
 // Another Edit object should not be created before ending the previous one.
 //
@@ -399,6 +488,14 @@ func (v *View) BeginEdit() *Edit {
 	return e
 }
 
+// Same as BeginEdit, but the Edit changes the buffer even if the View is
+// read-only, for reloading its file, which isn't editing it.
+func (v *View) beginReloadEdit() *Edit {
+	e := v.BeginEdit()
+	e.readOnly = false
+	return e
+}
+
 // Ends the given Edit object.
 func (v *View) EndEdit(edit *Edit) {
 	if edit.invalid {
@@ -475,6 +572,22 @@ func (v *View) IsScratch() bool {
 	return v.scratch
 }
 
+// Sets whether the view is read-only. The buffer of a read-only view
+// can't be changed with the Edits begun on it, nor by undoing and
+// redoing them, but only by reloading its file.
+func (v *View) SetReadOnly(ro bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.readOnly = ro
+}
+
+// Checks whether the view is read-only, see SetReadOnly.
+func (v *View) IsReadOnly() bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.readOnly
+}
+
 // Sets the overwrite status property of the view.
 // TODO(.): Couldn't this just be a value in the View's Settings?
 func (v *View) OverwriteStatus() bool {
@@ -508,17 +621,35 @@ func (v *View) FileChanged(filename string) {
 		// This reload was triggered by ourselves saving to this file, so don't reload it
 		return
 	}
-	if !GetEditor().Frontend().OkCancelDialog("File was changed by another program, reload?", "reload") {
+	v.lock.Lock()
+	saved := v.savedFile
+	v.lock.Unlock()
+	if fi, err := vfs.Stat(filename); err == nil && saved != nil && fi.Size() == saved.Size() && fi.ModTime().Equal(saved.ModTime()) {
+		// The file is as we last saved it, we were
+		// told about our own save after it finished
+		return
+	}
+	// The content of large files isn't kept for merging
+	if v.IsLargeFile() && !GetEditor().Frontend().OkCancelDialog("File was changed by another program, reload?", "reload") {
 		return
 	}
 
-	if d, err := ioutil.ReadFile(filename); err != nil {
+	d, err := v.readFile(filename, v.Encoding())
+	if err != nil {
 		log.Error("Could not read file: %s\n. Error was: %v", filename, err)
-	} else {
-		edit := v.BeginEdit()
+		return
+	}
+	if v.IsLargeFile() {
+		edit := v.beginReloadEdit()
 		end := v.Size()
 		v.Replace(edit, text.Region{0, end}, string(d))
 		v.EndEdit(edit)
+		v.setSaved()
+		return
+	}
+	// Changes made on disk are merged with those in the view
+	if res := v.MergeDisk(d); len(res.Conflicts) != 0 {
+		GetEditor().Frontend().StatusMessage(fmt.Sprintf("%d conflict(s) merging the changes made to %s by another program", len(res.Conflicts), filename))
 	}
 }
 
@@ -527,36 +658,46 @@ func (v *View) Save() error {
 	return v.SaveAs(v.FileName())
 }
 
-// Saves the file to the specified filename
+// Saves the file to the specified filename, see the "atomic_save" setting.
+// The user is asked before read-only files are overwritten. If permission
+// to write the file is denied, and the Frontend is an ElevationFrontend,
+// it's asked to save the file instead. Files are written through the
+// FileCodec they were read with or, when saving to another file, that
+// of its extension, e.g. compressed with gzip if it ends with ".gz".
 func (v *View) SaveAs(name string) (err error) {
 	log.Fine("SaveAs(%s)", name)
 	v.Settings().Set("lime.saving", true)
 	defer v.Settings().Erase("lime.saving")
-	OnPreSave.Call(v)
-	if atomic := v.Settings().Bool("atomic_save", true); v.FileName() == "" || !atomic {
-		if err := v.nonAtomicSave(name); err != nil {
-			return err
-		}
-	} else {
-		n, err := ioutil.TempDir(path.Dir(v.FileName()), "lime")
-		if err != nil {
-			return err
+	c := v.fileCodec()
+	if name != v.FileName() {
+		c, _ = codecByExtension(name)
+	}
+	if c != nil && !c.CanWrite() {
+		return fmt.Errorf("%s files can't be written", c.Name())
+	}
+	if isReadOnlyFile(name) {
+		if fe := GetEditor().Frontend(); fe == nil || !fe.OkCancelDialog(fmt.Sprintf("%s is read-only, overwrite it?", name), "Overwrite") {
+			return errReadOnlyFile
 		}
-		tmpf := path.Join(n, "tmp")
-		if err := v.nonAtomicSave(tmpf); err != nil {
+	}
+	// Make sure the content can be encoded before any file is truncated,
+	// and before the pre save commands change it for a save which fails
+	if err := v.checkEncoding(); err != nil {
+		return err
+	}
+	cc := v.ChangeCount()
+	OnPreSave.Call(v)
+	if v.ChangeCount() != cc {
+		if err := v.checkEncoding(); err != nil {
 			return err
 		}
-		if err := os.Rename(tmpf, name); err != nil {
-			// When we want to save as a file in another directory
-			// we can't go with os.Rename so we need to force
-			// not atomic saving sometimes as 4th test in TestSaveAsOpenFile
-			if err := v.nonAtomicSave(name); err != nil {
-				return err
-			}
-		}
-		if err := os.RemoveAll(n); err != nil {
+	}
+	if err := v.write(name, c); os.IsPermission(err) {
+		if err := v.saveWithElevation(name, c, err); err != nil {
 			return err
 		}
+	} else if err != nil {
+		return err
 	}
 
 	ed := GetEditor()
@@ -567,18 +708,71 @@ func (v *View) SaveAs(name string) (err error) {
 		}
 		ed.Watch(name, v)
 	}
+	v.setFileCodec(c)
 
-	v.Settings().Set("lime.last_save_change_count", v.ChangeCount())
+	v.setSaved()
 	OnPostSave.Call(v)
 	return nil
 }
 
-func (v *View) nonAtomicSave(name string) error {
-	data := []byte(v.Substr(text.Region{0, v.Size()}))
-	if err := ioutil.WriteFile(name, data, 0644); err != nil {
+// Marks the current content of the buffer as saved. It's only copied
+// once the buffer changes, see keepSaved.
+func (v *View) setSaved() {
+	fi, _ := vfs.Stat(v.FileName())
+	v.Settings().Set("lime.last_save_change_count", v.ChangeCount())
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.saved, v.savedKept = "", false
+	v.savedFile = fi
+	v.lineChanges = nil
+	v.minimap = nil
+}
+
+// Called by the buffer observer once the buffer has changed, with the
+// region of the buffer that replaced the text old. The saved content is
+// kept the first time the buffer changes after it was saved, by undoing
+// the change. The content of large files isn't kept, see IsLargeFile.
+func (v *View) keepSaved(a, b int, old string) {
+	v.lock.Lock()
+	kept := v.savedKept
+	v.lock.Unlock()
+	if kept || v.IsLargeFile() {
+		return
+	}
+	saved := v.buffer.Substr(text.Region{A: 0, B: a}) + old + v.buffer.Substr(text.Region{A: b, B: v.buffer.Size()})
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.saved, v.savedKept = saved, true
+}
+
+// Returns the buffer's content when it was last loaded or saved.
+func (v *View) savedText() string {
+	v.lock.Lock()
+	saved, kept := v.saved, v.savedKept
+	v.lock.Unlock()
+	if kept {
+		return saved
+	}
+	return v.Substr(text.Region{A: 0, B: v.Size()})
+}
+
+// Writes the buffer to the file name in place, through the codec c
+// if it's not nil.
+func (v *View) nonAtomicSave(name string, c FileCodec) error {
+	f, err := vfs.Write(name)
+	if err != nil {
 		return err
 	}
-	return nil
+	w := bufio.NewWriter(f)
+	if err := v.writeFile(w, c); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
 // Returns the CommandHistory entry at the given relative index.
@@ -627,6 +821,19 @@ func (v *View) AddRegions(key string, regions []text.Region, scope, icon string,
 	v.lock.Lock()
 	defer v.lock.Unlock()
 	v.regions[key] = vr
+	v.regionsChanged++
+}
+
+// Sets the priority of the gutter icon of the regions associated with
+// the given key. Icons of higher priority are shown first in the gutter.
+func (v *View) SetRegionsPriority(key string, priority int) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if vr, ok := v.regions[key]; ok {
+		vr.Priority = priority
+		v.regions[key] = vr
+		v.regionsChanged++
+	}
 }
 
 // Returns the Regions associated with the given key.
@@ -645,6 +852,60 @@ func (v *View) EraseRegions(key string) {
 	v.lock.Lock()
 	defer v.lock.Unlock()
 	delete(v.regions, key)
+	v.regionsChanged++
+}
+
+// AddPhantom adds a phantom, virtual text which isn't part of the buffer,
+// annotating region. Like the regions added with AddRegions its region is
+// adjusted as the buffer is edited. Phantoms are shown by the Layout of
+// the View and never affect the buffer itself. In a Recipe made by
+// render.TransformFlags, the points they're shown at are empty regions
+// with the render.PHANTOM flag.
+//
+// Returns the id of the phantom, for use with ErasePhantomById.
+func (v *View) AddPhantom(key string, region text.Region, content string, layout render.PhantomLayout) int {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.phantomId++
+	v.phantoms = append(v.phantoms, render.Phantom{Id: v.phantomId, Key: key, Region: region, Content: content, Layout: layout})
+	v.regionsChanged++
+	return v.phantomId
+}
+
+// Returns the phantoms added with the given key,
+// or all of the phantoms of the View if key is empty.
+func (v *View) Phantoms(key string) (ret []render.Phantom) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	for _, p := range v.phantoms {
+		if key == "" || p.Key == key {
+			ret = append(ret, p)
+		}
+	}
+	return
+}
+
+// Removes the phantoms added with the given key.
+func (v *View) ErasePhantoms(key string) {
+	v.erasePhantoms(func(p render.Phantom) bool { return p.Key == key })
+}
+
+// Removes the phantom with the given id.
+func (v *View) ErasePhantomById(id int) {
+	v.erasePhantoms(func(p render.Phantom) bool { return p.Id == id })
+}
+
+func (v *View) erasePhantoms(erase func(render.Phantom) bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	ps := v.phantoms[:0]
+	for _, p := range v.phantoms {
+		if !erase(p) {
+			ps = append(ps, p)
+		}
+	}
+	v.phantoms = ps
+	v.regionsChanged++
 }
 
 // Returns the UndoStack of this view. Tread lightly.
@@ -656,6 +917,12 @@ func (v *View) UndoStack() *UndoStack {
 // returns a Recipe suitable for rendering the contents of this View
 // that is visible in that viewport.
 func (v *View) Transform(viewport text.Region) render.Recipe {
+	return v.transform(viewport, render.Transform)
+}
+
+// Same as Transform, but transforms the regions with tf,
+// e.g render.TransformFlags.
+func (v *View) transform(viewport text.Region, tf func(render.ColourScheme, render.ViewRegionMap, text.Region) render.Recipe) render.Recipe {
 	pe := util.Prof.Enter("view.Transform")
 	defer pe.Exit()
 	v.lock.Lock()
@@ -665,14 +932,198 @@ func (v *View) Transform(viewport text.Region) render.Recipe {
 	}
 	cs := v.Settings().String("color_scheme", "")
 	scheme := ed.GetColorScheme(cs)
+	return tf(scheme, v.renderRegions(), viewport)
+}
+
+// Compose splits viewport into Runs of text with the same decorations,
+// stacking the syntax highlighting, regions, underlines and selection
+// of this View on top of each other. See render.Compose.
+//
+// Unlike Transform, this also works for Views without a syntax, whose
+// regions and selection are still drawn.
+func (v *View) Compose(viewport text.Region) []render.Run {
+	pe := util.Prof.Enter("view.Compose")
+	defer pe.Exit()
+	scheme := ed.GetColorScheme(v.Settings().String("color_scheme", ""))
+	v.lock.Lock()
+	recipe := render.TransformFlags(scheme, v.renderRegions(), viewport)
+	v.lock.Unlock()
+	return render.Compose(recipe, scheme.GlobalSettings(), viewport)
+}
+
+// Calls f with each of the regions of this View which are rendered,
+// including the selection as "lime.selection", and the points at which
+// phantoms are shown as "lime.phantoms". f mustn't modify or keep vr,
+// but can clone it. The View must be locked.
+func (v *View) walkRenderRegions(f func(key string, vr *render.ViewRegions)) {
+	for k, vr := range v.regions {
+		f(k, &vr)
+	}
+	sel := &render.ViewRegions{Flags: render.SELECTION}
+	sel.Regions.AddAll(v.Sel().Regions())
+	f("lime.selection", sel)
+	if len(v.phantoms) != 0 {
+		ps := &render.ViewRegions{Flags: render.PHANTOM | render.DRAW_EMPTY | render.DRAW_NO_FILL | render.DRAW_NO_OUTLINE | render.HIDE_ON_MINIMAP}
+		for i := range v.phantoms {
+			p := v.phantoms[i].Point(v.buffer)
+			ps.Regions.Add(text.Region{A: p, B: p})
+		}
+		f("lime.phantoms", ps)
+	}
+}
+
+// Returns a copy of the regions of this View which are rendered,
+// see walkRenderRegions. The View must be locked.
+func (v *View) renderRegions() render.ViewRegionMap {
 	rr := make(render.ViewRegionMap)
-	for k, v := range v.regions {
-		rr[k] = *v.Clone()
+	v.walkRenderRegions(func(k string, vr *render.ViewRegions) {
+		rr[k] = *vr.Clone()
+	})
+	return rr
+}
+
+// Minimap returns a downscaled overview of the whole of this View for
+// drawing a minimap or an overview ruler, with at most the given number
+// of buckets of lines. See render.NewMinimap.
+//
+// The Minimap is kept until the buffer, the regions, the selection or the
+// colour scheme change, and mustn't be modified.
+func (v *View) Minimap(buckets int) *render.Minimap {
+	pe := util.Prof.Enter("view.Minimap")
+	defer pe.Exit()
+	cs := v.Settings().String("color_scheme", "")
+	sel := v.Sel().Regions()
+	cc := v.ChangeCount()
+	v.lock.Lock()
+	key := minimapKey{cc, v.regionsChanged, buckets, cs}
+	if v.minimap != nil && v.minimapKey == key && sameRegions(v.minimapSel, sel) {
+		defer v.lock.Unlock()
+		return v.minimap
 	}
-	rs := render.ViewRegions{Flags: render.SELECTION}
-	rs.Regions.AddAll(v.Sel().Regions())
-	rr["lime.selection"] = rs
-	return render.Transform(scheme, rr, viewport)
+	v.lock.Unlock()
+
+	recipe := v.transform(text.Region{A: 0, B: v.Size()}, render.TransformFlags)
+	changes := v.LineChanges()
+	gs := ed.GetColorScheme(cs).GlobalSettings()
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	var regions []*render.ViewRegions
+	v.walkRenderRegions(func(k string, vr *render.ViewRegions) {
+		regions = append(regions, vr.Clone())
+	})
+	m := render.NewMinimap(v.buffer, recipe, gs, regions, changes, buckets)
+	v.minimap, v.minimapKey, v.minimapSel = m, key, sel
+	return m
+}
+
+// Layout lays out the text of this View in viewport into visual lines,
+// according to the "tab_size", "word_wrap" and "wrap_width" settings and
+// the folded regions and phantoms of the View. "wrap_width" is a number of
+// columns, and if it's 0, lines are wrapped at width, which would be the
+// width of the frontend's text area.
+//
+// m is used to measure the text in "font_face" and "font_size" if not nil,
+// in which case widths are in m's units rather than in columns, and a column
+// of "wrap_width" is as wide as a space.
+func (v *View) Layout(viewport text.Region, m render.FontMetrics, width int) *render.Layout {
+	pe := util.Prof.Enter("view.Layout")
+	defer pe.Exit()
+	s := v.Settings()
+	ls := render.LayoutSettings{
+		TabSize:   s.Int("tab_size", 4),
+		WrapWidth: s.Int("wrap_width", 0),
+		Metrics:   m,
+		Folds:     v.Folds(),
+		Phantoms:  v.Phantoms(""),
+	}
+	// "word_wrap" can also be "auto" in the default settings
+	ls.WordWrap, _ = s.Get("word_wrap", false).(bool)
+	if m != nil {
+		ls.Font = render.Font{Name: s.String("font_face", ""), Size: float64(s.Int("font_size", 0))}
+		ls.WrapWidth *= m.Measure(ls.Font, []rune{' '}).Width
+	}
+	if ls.WrapWidth <= 0 {
+		ls.WrapWidth = width
+	}
+	return render.NewLayout(v.buffer, viewport, ls)
+}
+
+// Gutter returns what should be displayed in the gutter next to the lines
+// of this View in viewport: line numbers, the icons of the regions added
+// with AddRegions, fold markers and how the lines have changed since
+// the buffer was last saved.
+func (v *View) Gutter(viewport text.Region) render.Gutter {
+	pe := util.Prof.Enter("view.Gutter")
+	defer pe.Exit()
+	folds := v.Folds()
+	changes := v.LineChanges()
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return render.NewGutter(v.buffer, viewport, v.regions, folds, changes)
+}
+
+// Returns how each line of the buffer differs from the buffer's content when
+// it was last loaded or saved. The result is reused until the buffer changes.
+// Changes aren't tracked for large files, for which nil is returned.
+func (v *View) LineChanges() []render.LineChange {
+	if v.IsLargeFile() {
+		return nil
+	}
+	cc := v.ChangeCount()
+	v.lock.Lock()
+	if v.lineChanges != nil && v.lineChangesAt == cc {
+		defer v.lock.Unlock()
+		return v.lineChanges
+	}
+	v.lock.Unlock()
+
+	saved := v.savedText()
+	current := v.Substr(text.Region{A: 0, B: v.Size()})
+	changes := render.DiffLines(strings.Split(saved, "\n"), strings.Split(current, "\n"))
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.lineChanges, v.lineChangesAt = changes, cc
+	return changes
+}
+
+// Fold hides the text in r, which is shown as a placeholder
+// by Layout. Returns false if r is empty.
+func (v *View) Fold(r text.Region) bool {
+	if r.Empty() {
+		return false
+	}
+	v.folds.Add(text.Region{A: r.Begin(), B: r.End()})
+	v.lock.Lock()
+	v.regionsChanged++
+	v.lock.Unlock()
+	return true
+}
+
+// Unfolds the folded regions intersecting r, returning them.
+func (v *View) Unfold(r text.Region) (ret []text.Region) {
+	for _, f := range v.folds.Regions() {
+		if f.Intersects(r) || r.Covers(f) || f.Covers(r) {
+			v.folds.Subtract(f)
+			ret = append(ret, f)
+		}
+	}
+	if len(ret) != 0 {
+		v.lock.Lock()
+		v.regionsChanged++
+		v.lock.Unlock()
+	}
+	return
+}
+
+// Returns the folded regions of this View.
+func (v *View) Folds() (ret []text.Region) {
+	for _, f := range v.folds.Regions() {
+		if !f.Empty() {
+			ret = append(ret, f)
+		}
+	}
+	return
 }
 
 func (v *View) cleanup() {
@@ -687,7 +1138,7 @@ func (v *View) cleanup() {
 }
 
 func (v *View) isClosed() bool {
-	return v.reparseChan == nil
+	return v.closed
 }
 
 // Initiate the "close" operation of this view.
@@ -710,12 +1161,14 @@ func (v *View) Close() bool {
 
 	v.window.remove(v)
 
-	// Closing the reparseChan, and setting to nil will eventually clean up other resources
-	// when the parseThread exits
 	v.lock.Lock()
-	defer v.lock.Unlock()
-	close(v.reparseChan)
-	v.reparseChan = nil
+	v.closed = true
+	v.lock.Unlock()
+	// If the view is being parsed other resources are
+	// cleaned up once the parse has been abandoned
+	if parsers.remove(v) {
+		v.cleanup()
+	}
 
 	return true
 }
@@ -1019,14 +1472,14 @@ func (v *View) Lines(r text.Region) []text.Region {
 	return v.buffer.Lines(r)
 }
 
+// SetFileName sets the file name of the view, and its syntax to the one
+// detected for the new name, see detectSyntax.
 func (v *View) SetFileName(n string) error {
 	if err := v.buffer.SetFileName(n); err != nil {
 		return err
 	}
-	if ext := path.Ext(n); ext != "" {
-		if file := GetEditor().fileTypeSyntax(ext[1:]); file != "" {
-			v.SetSyntaxFile(file)
-		}
+	if syn := v.detectSyntax(); syn != "" && !v.IsLargeFile() {
+		v.SetSyntaxFile(syn)
 	}
 	return nil
 }
@@ -13,6 +13,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/limetext/backend/render"
+	"github.com/limetext/backend/vfs"
 	"github.com/limetext/text"
 	"github.com/limetext/util"
 )
@@ -839,6 +841,113 @@ func TestTransform(t *testing.T) {
 	}
 }
 
+// Every character is 10 wide
+type layoutMetrics struct{}
+
+func (m layoutMetrics) Measure(f render.Font, rs []rune) render.FontMeasurement {
+	return render.FontMeasurement{Width: 10 * len(rs), Height: 12}
+}
+
+func TestViewLayout(t *testing.T) {
+	w := GetEditor().NewWindow()
+	defer w.Close()
+
+	v := w.NewFile()
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+
+	e := v.BeginEdit()
+	v.Insert(e, 0, "func f() {\n\treturn\n}\nx")
+	v.EndEdit(e)
+	v.Settings().Set("tab_size", 2)
+	v.Settings().Set("word_wrap", true)
+
+	if !v.Fold(text.Region{A: 10, B: 19}) {
+		t.Fatal("Expected the region to be folded")
+	}
+	e = v.BeginEdit()
+	v.Insert(e, 0, "  ")
+	v.EndEdit(e)
+	if exp, got := []text.Region{{A: 12, B: 21}}, v.Folds(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected the folds to be adjusted to %v, but got %v", exp, got)
+	}
+
+	l := v.Layout(text.Region{A: 0, B: v.Size()}, nil, 8)
+	var got []text.Region
+	for _, line := range l.Lines {
+		got = append(got, line.Region)
+	}
+	exp := []text.Region{{A: 0, B: 7}, {A: 7, B: 22}, {A: 23, B: 24}}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected lines %v, but got %v", exp, got)
+	}
+
+	// "wrap_width" is in columns even when the text is measured
+	v.Settings().Set("wrap_width", 4)
+	l = v.Layout(text.Region{A: 0, B: 7}, layoutMetrics{}, 1000)
+	got = nil
+	for _, line := range l.Lines {
+		got = append(got, line.Region)
+	}
+	if exp := []text.Region{{A: 0, B: 2}, {A: 2, B: 7}, {A: 7, B: 11}, {A: 11, B: 12}}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected lines %v, but got %v", exp, got)
+	}
+	v.Settings().Erase("wrap_width")
+
+	if exp, got := []text.Region{{A: 12, B: 21}}, v.Unfold(text.Region{A: 15, B: 15}); !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected %v to be unfolded, but got %v", exp, got)
+	}
+	if f := v.Folds(); len(f) != 0 {
+		t.Errorf("Expected no folds, but got %v", f)
+	}
+}
+
+func TestViewGutter(t *testing.T) {
+	w := GetEditor().NewWindow()
+	defer w.Close()
+
+	v := w.NewFile()
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+
+	e := v.BeginEdit()
+	v.Insert(e, 0, "a\nb\nc")
+	v.EndEdit(e)
+	v.setSaved()
+	if v.savedKept {
+		t.Error("Expected no copy of the saved content to be kept before the buffer changes")
+	}
+
+	e = v.BeginEdit()
+	v.Insert(e, 2, "x\n")
+	v.Replace(e, text.Region{A: 6, B: 7}, "y")
+	v.EndEdit(e)
+	if s := v.savedText(); s != "a\nb\nc" {
+		t.Errorf("Expected the saved content %q, but got %q", "a\nb\nc", s)
+	}
+
+	v.AddRegions("low", []text.Region{{A: 2, B: 3}}, "", "dot", 0)
+	v.AddRegions("high", []text.Region{{A: 2, B: 2}}, "", "circle", 0)
+	v.SetRegionsPriority("high", 1)
+
+	exp := render.Gutter{
+		{Number: 1, Region: text.Region{A: 0, B: 1}},
+		{Number: 2, Region: text.Region{A: 2, B: 3}, Change: render.LINE_ADDED, Icons: []render.GutterIcon{
+			{Key: "high", Icon: "circle", Priority: 1},
+			{Key: "low", Icon: "dot"},
+		}},
+		{Number: 3, Region: text.Region{A: 4, B: 5}},
+		{Number: 4, Region: text.Region{A: 6, B: 7}, Change: render.LINE_MODIFIED},
+	}
+	if g := v.Gutter(text.Region{A: 0, B: v.Size()}); !reflect.DeepEqual(g, exp) {
+		t.Errorf("Expected gutter\n%v, but got\n%v", exp, g)
+	}
+}
+
 // This is not 100% what ST3 does
 func TestViewExtractScope(t *testing.T) {
 	w := GetEditor().NewWindow()
@@ -1082,3 +1191,189 @@ func BenchmarkViewTransformTranscribe(b *testing.B) {
 	}
 	fmt.Println(util.Prof.String())
 }
+
+func TestViewMinimap(t *testing.T) {
+	w := GetEditor().NewWindow()
+	defer w.Close()
+
+	v := w.NewFile()
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+
+	e := v.BeginEdit()
+	v.Insert(e, 0, "a\nb\nc\nd")
+	v.EndEdit(e)
+	v.Sel().Clear()
+	v.Sel().Add(text.Region{A: 0, B: 0})
+	v.AddRegions("errors", []text.Region{{A: 6, B: 7}}, "invalid", "", 0)
+	v.AddRegions("brackets", []text.Region{{A: 2, B: 3}}, "invalid", "", render.HIDE_ON_MINIMAP)
+
+	m := v.Minimap(2)
+	if len(m.Buckets) != 2 {
+		t.Fatalf("Expected 2 buckets, but got %d", len(m.Buckets))
+	}
+	if exp := render.MINIMAP_SELECTION | render.MINIMAP_CHANGE; m.Buckets[0].Markers != exp {
+		t.Errorf("Expected the markers of the first bucket to be %d, but got %d", exp, m.Buckets[0].Markers)
+	}
+	if exp := render.MINIMAP_ERROR | render.MINIMAP_CHANGE; m.Buckets[1].Markers != exp {
+		t.Errorf("Expected the markers of the second bucket to be %d, but got %d", exp, m.Buckets[1].Markers)
+	}
+	fg := GetEditor().GetColorScheme(v.Settings().String("color_scheme", "")).GlobalSettings().Foreground
+	if exp := []render.MinimapColour{{Colour: fg, Weight: 2}}; !reflect.DeepEqual(m.Buckets[0].Colours, exp) {
+		t.Errorf("Expected the text to have the default colour %v, but got %v", exp, m.Buckets[0].Colours)
+	}
+
+	if m2 := v.Minimap(2); m2 != m {
+		t.Error("Expected the minimap to be kept while nothing changes")
+	}
+	v.Sel().Add(text.Region{A: 7, B: 7})
+	if m2 := v.Minimap(2); m2 == m || m2.Buckets[1].Markers&render.MINIMAP_SELECTION == 0 {
+		t.Error("Expected a new minimap after the selection changed")
+	}
+	m = v.Minimap(2)
+	e = v.BeginEdit()
+	v.Insert(e, 0, "x")
+	v.EndEdit(e)
+	if m2 := v.Minimap(2); m2 == m {
+		t.Error("Expected a new minimap after the buffer changed")
+	}
+}
+
+func TestViewPhantoms(t *testing.T) {
+	w := GetEditor().NewWindow()
+	defer w.Close()
+
+	v := w.NewFile()
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+
+	e := v.BeginEdit()
+	v.Insert(e, 0, "abc\ndef")
+	v.EndEdit(e)
+
+	hint := v.AddPhantom("hints", text.Region{A: 1, B: 1}, ": int", render.PHANTOM_INLINE)
+	v.AddPhantom("lint", text.Region{A: 4, B: 7}, "error", render.PHANTOM_BELOW)
+
+	e = v.BeginEdit()
+	v.Insert(e, 0, "xx")
+	v.EndEdit(e)
+
+	if s := v.Substr(text.Region{A: 0, B: v.Size()}); s != "xxabc\ndef" || v.Size() != 9 {
+		t.Errorf("Expected the phantoms not to be part of the buffer, but got %q", s)
+	}
+	ps := v.Phantoms("")
+	if len(ps) != 2 {
+		t.Fatalf("Expected 2 phantoms, but got %d", len(ps))
+	}
+	if ps[0].Region != (text.Region{A: 3, B: 3}) || ps[1].Region != (text.Region{A: 6, B: 9}) {
+		t.Errorf("Expected the phantoms to be adjusted, but got %v and %v", ps[0].Region, ps[1].Region)
+	}
+
+	l := v.Layout(text.Region{A: 0, B: v.Size()}, nil, 0)
+	if len(l.Lines) != 3 || l.Lines[2].Phantom == nil || l.Lines[2].Phantom.Content != "error" {
+		t.Errorf("Expected the lint phantom to be laid out below the last line, but got %+v", l.Lines)
+	}
+	if c := l.Lines[0].Cells[3]; c.Phantom == nil || c.Phantom.Id != hint {
+		t.Errorf("Expected the hint phantom in the first line, but got %+v", c)
+	}
+	v.lock.Lock()
+	recipe := render.TransformFlags(GetEditor().GetColorScheme(""), v.renderRegions(), text.Region{A: 0, B: v.Size()})
+	v.lock.Unlock()
+	var points []text.Region
+	for f, rs := range recipe {
+		if f.Flags&render.PHANTOM != 0 {
+			points = append(points, rs.Regions()...)
+		}
+	}
+	if exp := []text.Region{{A: 3, B: 3}, {A: 9, B: 9}}; !reflect.DeepEqual(points, exp) {
+		t.Errorf("Expected the phantoms at %v in the recipe, but got %v", exp, points)
+	}
+
+	v.ErasePhantomById(hint)
+	if ps := v.Phantoms("hints"); len(ps) != 0 {
+		t.Errorf("Expected the hint to be erased, but got %v", ps)
+	}
+	v.ErasePhantoms("lint")
+	if ps := v.Phantoms(""); len(ps) != 0 {
+		t.Errorf("Expected all phantoms to be erased, but got %v", ps)
+	}
+}
+
+func TestViewCompose(t *testing.T) {
+	w := GetEditor().NewWindow()
+	defer w.Close()
+
+	v := w.NewFile()
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+
+	e := v.BeginEdit()
+	v.Insert(e, 0, "abcdef")
+	v.EndEdit(e)
+	v.Sel().Clear()
+	v.Sel().Add(text.Region{A: 1, B: 3})
+	v.AddRegions("spelling", []text.Region{{A: 2, B: 5}}, "invalid", "", render.DRAW_SQUIGGLY_UNDERLINE|render.DRAW_NO_FILL|render.DRAW_NO_OUTLINE)
+
+	runs := v.Compose(text.Region{A: 0, B: v.Size()})
+	if len(runs) == 0 || runs[0].Region.A != 0 || runs[len(runs)-1].Region.B != v.Size() {
+		t.Fatalf("Expected the runs to cover the view, but got %+v", runs)
+	}
+	for _, r := range runs {
+		if r.Region.Contains(2) && r.Region.B > 2 && r.Flavour.Flags&(render.SELECTION|render.DRAW_SQUIGGLY_UNDERLINE) != render.SELECTION|render.DRAW_SQUIGGLY_UNDERLINE {
+			t.Errorf("Expected %v to be both selected and underlined, but got flags %d", r.Region, r.Flavour.Flags)
+		}
+	}
+}
+
+func TestViewMemFile(t *testing.T) {
+	const fn = "mem://view/file.txt"
+	if err := vfs.WriteFile(fn, []byte("a\nb\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	ed := GetEditor()
+	old := ed.Frontend()
+	defer ed.SetFrontend(old)
+	ed.SetFrontend(&dummyFrontend{})
+
+	w := ed.NewWindow()
+	defer w.Close()
+	v := w.OpenFile(fn, 0)
+	defer func() {
+		v.SetScratch(true)
+		v.Close()
+	}()
+	content := func() string {
+		return v.Substr(text.Region{A: 0, B: v.Size()})
+	}
+	if v.FileName() != fn || content() != "a\nb\n" {
+		t.Fatalf("Expected %s to be opened, but got %s with %q", fn, v.FileName(), content())
+	}
+
+	e := v.BeginEdit()
+	v.Insert(e, 0, "0\n")
+	v.EndEdit(e)
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := vfs.ReadFile(fn); err != nil || string(d) != "0\na\nb\n" {
+		t.Errorf("Expected the view to be saved, but got %q, %v", d, err)
+	}
+	if v.IsDirty() {
+		t.Error("Expected the view to be clean after saving")
+	}
+
+	// The watcher tells the view about changes made by others
+	if err := vfs.WriteFile(fn, []byte("0\na\nb\nc\n")); err != nil {
+		t.Fatal(err)
+	}
+	if s := content(); s != "0\na\nb\nc\n" {
+		t.Errorf("Expected the view to be reloaded, but got %q", s)
+	}
+}
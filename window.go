@@ -6,12 +6,11 @@ package backend
 
 import (
 	"fmt"
-	"io/ioutil"
-	"path/filepath"
 	"runtime/debug"
 	"sync"
 
 	"github.com/limetext/backend/log"
+	"github.com/limetext/backend/vfs"
 	"github.com/limetext/text"
 )
 
@@ -39,7 +38,7 @@ func (w *Window) NewFile() *View {
 	v.setBuffer(text.NewBuffer())
 	v.Sel().Clear()
 	v.Sel().Add(text.Region{A: 0, B: 0})
-	v.Settings().Set("lime.last_save_change_count", v.ChangeCount())
+	v.setSaved()
 
 	OnNew.Call(v)
 	w.SetActiveView(v)
@@ -71,25 +70,37 @@ func (w *Window) remove(v *View) {
 	log.Error("Wanted to remove view %s, but it doesn't appear to be a child of this window", v)
 }
 
+// Opens filename in a new View. Files larger than the "large_file_size"
+// setting are opened in large file mode, see View.IsLargeFile. Files with a
+// FileCodec, such as compressed files, show the text they contain, and are
+// opened read-only if the codec can't write them. Returns nil if the file
+// looks binary and the user chose not to open it, which without a Frontend
+// never happens.
 func (w *Window) OpenFile(filename string, flags int) *View {
 	v := w.NewFile()
 
 	v.SetScratch(true)
 	e := v.BeginEdit()
-	if fn, err := filepath.Abs(filename); err != nil {
-		v.SetFileName(filename)
-	} else {
-		v.SetFileName(fn)
-	}
-	if d, err := ioutil.ReadFile(filename); err != nil {
+	// The syntax is detected once the file's contents are loaded
+	v.buffer.SetFileName(vfs.Abs(filename))
+	err := v.loadFile(e, filename)
+	v.EndEdit(e)
+	if err == errBinaryFile {
+		v.Close()
+		return nil
+	} else if err != nil {
 		log.Error("Couldn't load file %s: %s", filename, err)
-	} else {
-		v.Insert(e, 0, string(d))
 	}
-	v.EndEdit(e)
+	// Files which can't be written back are only for reading
+	if c := v.fileCodec(); c != nil && !c.CanWrite() {
+		v.SetReadOnly(true)
+	}
+	if syn := v.detectSyntax(); syn != "" && !v.IsLargeFile() {
+		v.SetSyntaxFile(syn)
+	}
 	v.Sel().Clear()
 	v.Sel().Add(text.Region{A: 0, B: 0})
-	v.Settings().Set("lime.last_save_change_count", v.ChangeCount())
+	v.setSaved()
 	v.SetScratch(false)
 
 	OnLoad.Call(v)
@@ -148,11 +159,7 @@ func (w *Window) OpenProject(name string) *Project {
 		log.Error(err)
 		return nil
 	}
-	if abs, err := filepath.Abs(name); err != nil {
-		w.Project().SetName(name)
-	} else {
-		w.Project().SetName(abs)
-	}
+	w.Project().SetName(vfs.Abs(name))
 
 	GetEditor().Watch(w.Project().FileName(), w.Project())
 	OnProjectChanged.Call(w)
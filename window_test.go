@@ -198,6 +198,21 @@ func TestOpenFile(t *testing.T) {
 	}
 }
 
+func TestOpenFileSyntax(t *testing.T) {
+	const syntax = "testdata/Go.tmLanguage"
+	GetEditor().AddSyntax(syntax, newDummySytax(t, syntax))
+
+	w := GetEditor().NewWindow()
+	defer w.Close()
+
+	v := w.OpenFile("testdata/code.go", 0644)
+	defer v.Close()
+
+	if got := v.Settings().String("syntax", ""); got != syntax {
+		t.Errorf("Expected syntax %s, but got %s", syntax, got)
+	}
+}
+
 func TestOpenProject(t *testing.T) {
 	w := GetEditor().NewWindow()
 	defer w.Close()
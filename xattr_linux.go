@@ -0,0 +1,59 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"syscall"
+)
+
+// Calls f with ever larger buffers, starting with none to get the size
+// needed, until the buffer is large enough for what f reads into it.
+func xattrBuffer(f func([]byte) (int, error)) ([]byte, error) {
+	for {
+		n, err := f(nil)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, n)
+		n, err = f(buf)
+		if err == syscall.ERANGE {
+			// It grew in between
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+// Copies the extended attributes of the file from to the file to.
+// File systems without them are skipped.
+func copyXattrs(from, to string) error {
+	names, err := xattrBuffer(func(b []byte) (int, error) {
+		return syscall.Listxattr(from, b)
+	})
+	if err == syscall.ENOTSUP {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		attr := string(name)
+		val, err := xattrBuffer(func(b []byte) (int, error) {
+			return syscall.Getxattr(from, attr, b)
+		})
+		if err != nil {
+			return err
+		}
+		if err := syscall.Setxattr(to, attr, val, 0); err != nil && err != syscall.ENOTSUP {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+// Copyright 2016 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package backend
+
+// Extended attributes aren't kept on this platform.
+func copyXattrs(from, to string) error {
+	return nil
+}